@@ -15,12 +15,15 @@
 package protohash
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
 	"fmt"
+	"hash"
 	"math"
 )
 
-const hashLength int = sha256.Size
+// defaultHashFunction is the hash function used by ObjectHash.
+var defaultHashFunction = sha256.New
 
 const (
 	// Sorted alphabetically by value.
@@ -31,11 +34,42 @@ const (
 	listIdentifier     = `l`
 	nilIdentifier      = `n`
 	byteIdentifier     = `r`
+	setIdentifier      = `s`
 	unicodeIndentifier = `u`
 )
 
-func hash(t string, b []byte) ([]byte, error) {
-	h := sha256.New()
+// newHash returns a new instance of the hash function used by the hasher.
+//
+// This is an HMAC of the hash function when the hasher has an HMAC key.
+func (hasher *objectHasher) newHash() hash.Hash {
+	hashFunction := defaultHashFunction
+	if hasher.hashFunction != nil {
+		hashFunction = hasher.hashFunction
+	}
+
+	if hasher.hmacKey != nil {
+		return hmac.New(hashFunction, hasher.hmacKey)
+	}
+	return hashFunction()
+}
+
+// hash returns the hash of a leaf value (ie. anything but a list or a
+// dictionary), given its type identifier and its normalized representation.
+func (hasher *objectHasher) hash(t string, b []byte) ([]byte, error) {
+	h, err := hasher.digest(t, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasher.tracer != nil {
+		hasher.tracer.leaf(t, b, h)
+	}
+	return h, nil
+}
+
+// digest returns the hash of a type identifier followed by some bytes.
+func (hasher *objectHasher) digest(t string, b []byte) ([]byte, error) {
+	h := hasher.newHash()
 
 	if _, err := h.Write([]byte(t)); err != nil {
 		return nil, err
@@ -48,19 +82,19 @@ func hash(t string, b []byte) ([]byte, error) {
 	return h.Sum(nil), nil
 }
 
-func hashBool(b bool) ([]byte, error) {
+func (hasher *objectHasher) hashBool(b bool) ([]byte, error) {
 	bb := []byte(`0`)
 	if b {
 		bb = []byte(`1`)
 	}
-	return hash(boolIdentifier, bb)
+	return hasher.hash(boolIdentifier, bb)
 }
 
-func hashBytes(bs []byte) ([]byte, error) {
-	return hash(byteIdentifier, bs)
+func (hasher *objectHasher) hashBytes(bs []byte) ([]byte, error) {
+	return hasher.hash(byteIdentifier, bs)
 }
 
-func hashFloat(f float64) ([]byte, error) {
+func (hasher *objectHasher) hashFloat(f float64) ([]byte, error) {
 	var normalizedFloat string
 
 	switch {
@@ -78,21 +112,21 @@ func hashFloat(f float64) ([]byte, error) {
 		}
 	}
 
-	return hash(floatIdentifier, []byte(normalizedFloat))
+	return hasher.hash(floatIdentifier, []byte(normalizedFloat))
 }
 
-func hashInt64(i int64) ([]byte, error) {
-	return hash(intIdentifier, []byte(fmt.Sprintf("%d", i)))
+func (hasher *objectHasher) hashInt64(i int64) ([]byte, error) {
+	return hasher.hash(intIdentifier, []byte(fmt.Sprintf("%d", i)))
 }
 
-func hashNil() ([]byte, error) {
-	return hash(nilIdentifier, []byte(``))
+func (hasher *objectHasher) hashNil() ([]byte, error) {
+	return hasher.hash(nilIdentifier, []byte(``))
 }
 
-func hashUint64(i uint64) ([]byte, error) {
-	return hash(intIdentifier, []byte(fmt.Sprintf("%d", i)))
+func (hasher *objectHasher) hashUint64(i uint64) ([]byte, error) {
+	return hasher.hash(intIdentifier, []byte(fmt.Sprintf("%d", i)))
 }
 
-func hashUnicode(s string) ([]byte, error) {
-	return hash(unicodeIndentifier, []byte(s))
+func (hasher *objectHasher) hashUnicode(s string) ([]byte, error) {
+	return hasher.hash(unicodeIndentifier, []byte(s))
 }
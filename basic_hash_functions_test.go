@@ -0,0 +1,124 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+	"google.golang.org/protobuf/proto"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// newBlake2b256 returns a new unkeyed BLAKE2b-256 hash.
+func newBlake2b256() hash.Hash {
+	h, err := blake2b.New256(nil)
+	if err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// TestHashFunction checks the hashes calculated using different hash
+// functions against independently calculated test vectors.
+func TestHashFunction(t *testing.T) {
+	// Each message is hashed with every hash function.
+	messages := []proto.Message{
+		// Hashed as {1: true, 5: 1.5, 15: -5, 25: "foo"}.
+		protoV1.MessageV2(&pb3_latest.Simple{BoolField: true, DoubleField: 1.5, Int64Field: -5, StringField: "foo"}),
+
+		// Hashed as {2: [1, 2]}.
+		protoV1.MessageV2(&pb3_latest.Int64Message{Values: []int64{1, 2}}),
+
+		// Hashed as nil.
+		nil,
+	}
+
+	testCases := []struct {
+		name         string
+		hashFunction func() hash.Hash
+		expected     []string
+	}{
+		{
+			name:         "Default",
+			hashFunction: nil,
+			expected: []string{
+				"c0f4913ac469fd0a2dd277168bdefa659c9152acb5ef9a39a2d07b0d6e8c4889",
+				"d3a09d1073a66e6a82bdfd79e7a9328ab8a60bf8e88ac89e4d5a5f79f0354867",
+				"1b16b1df538ba12dc3f97edbb85caa7050d46c148134290feba80f8236c83db9",
+			},
+		},
+		{
+			name:         "SHA-256",
+			hashFunction: sha256.New,
+			expected: []string{
+				"c0f4913ac469fd0a2dd277168bdefa659c9152acb5ef9a39a2d07b0d6e8c4889",
+				"d3a09d1073a66e6a82bdfd79e7a9328ab8a60bf8e88ac89e4d5a5f79f0354867",
+				"1b16b1df538ba12dc3f97edbb85caa7050d46c148134290feba80f8236c83db9",
+			},
+		},
+		{
+			name:         "SHA-512/256",
+			hashFunction: sha512.New512_256,
+			expected: []string{
+				"5bf5c716a121f979fcf07738614408511510ac21efc7b2865ab29d13eba903c9",
+				"4cc5abc35f21da2ebcdea9fc56b26c10b8785bf03566a0cdc5b5a724f0f63d66",
+				"a93ffe1fcc1d712f6ce5ec1281ea7f506ebe0cf1697280617804e2845293047a",
+			},
+		},
+		{
+			name:         "SHA3-256",
+			hashFunction: sha3.New256,
+			expected: []string{
+				"f569e27b9cf5c40c8c692c9f6bcb694e01efd01843349f2e13992f7b8b126869",
+				"d789646ba97ed5b8e911369404aacc06db46e627688a5df352656150811886eb",
+				"8ee93ceda95bbe450f7fb53a700c56dfac4387e48eb127881a2a68727bc7810c",
+			},
+		},
+		{
+			name:         "BLAKE2b-256",
+			hashFunction: newBlake2b256,
+			expected: []string{
+				"930f9df8943c3e613a44216cc98cf15edb5a60a022300cee5efaab6723720b58",
+				"b25a19a312dbb8b56dd9b8969a95591971a2e8b25181fa745bae5378ef183c8f",
+				"1593de8fa374083bfd10fb9300b401b52dff963181c5854fdb00ade06153b9d5",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			hasher := NewHasher(HashFunction(tc.hashFunction))
+
+			for i, msg := range messages {
+				h, err := hasher.HashProto(msg)
+				if err != nil {
+					t.Fatalf("Hashing %v returned an error: %v", msg, err)
+				}
+
+				if actual := hex.EncodeToString(h); actual != tc.expected[i] {
+					t.Errorf("Got the wrong objecthash for %v.\nActual:   %s\nExpected: %s\n", msg, actual, tc.expected[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cmdutil contains the code shared by the commands of this repository.
+package cmdutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	protohash "github.com/deepmind/objecthash-proto"
+)
+
+// AnyModes are the valid values of the flags that choose how
+// google.protobuf.Any messages get hashed (see AnyOptions).
+const AnyModes = `"none" (unsupported), "embedded" (see AnyResolver) or "bytes" (see AnyAsTypeURLAndBytes)`
+
+// AnyOptions returns the hasher options for hashing google.protobuf.Any
+// messages in the given mode (see AnyModes). The resolver is used for the
+// "embedded" mode.
+func AnyOptions(mode string, resolver protohash.TypeResolver) ([]protohash.Option, error) {
+	switch mode {
+	case "none":
+		return nil, nil
+	case "embedded":
+		return []protohash.Option{protohash.AnyResolver(resolver)}, nil
+	case "bytes":
+		return []protohash.Option{protohash.AnyAsTypeURLAndBytes()}, nil
+	default:
+		return nil, fmt.Errorf("invalid google.protobuf.Any mode %q, expected one of %s", mode, AnyModes)
+	}
+}
+
+// RequiredFieldModes are the valid values of the flags that choose how proto2
+// required fields get hashed (see RequiredFieldOptions).
+const RequiredFieldModes = `"error" (unsupported) or "ordinary" (see RequiredFieldsAsOrdinaryFields)`
+
+// RequiredFieldOptions returns the hasher options for hashing proto2 required
+// fields in the given mode (see RequiredFieldModes).
+func RequiredFieldOptions(mode string) ([]protohash.Option, error) {
+	switch mode {
+	case "error":
+		return nil, nil
+	case "ordinary":
+		return []protohash.Option{protohash.RequiredFieldsAsOrdinaryFields()}, nil
+	default:
+		return nil, fmt.Errorf("invalid required field mode %q, expected one of %s", mode, RequiredFieldModes)
+	}
+}
+
+// ExplicitDefaultModes are the valid values of the flags that choose how
+// proto2 fields with explicit defaults get hashed (see ExplicitDefaultOptions).
+const ExplicitDefaultModes = `"error" (unsupported), "ordinary" (see ExplicitDefaultsAsOrdinaryFields) or "unset" (see ExplicitDefaultsAsUnset)`
+
+// ExplicitDefaultOptions returns the hasher options for hashing proto2 fields
+// with explicit defaults in the given mode (see ExplicitDefaultModes).
+func ExplicitDefaultOptions(mode string) ([]protohash.Option, error) {
+	switch mode {
+	case "error":
+		return nil, nil
+	case "ordinary":
+		return []protohash.Option{protohash.ExplicitDefaultsAsOrdinaryFields()}, nil
+	case "unset":
+		return []protohash.Option{protohash.ExplicitDefaultsAsUnset()}, nil
+	default:
+		return nil, fmt.Errorf("invalid explicit default mode %q, expected one of %s", mode, ExplicitDefaultModes)
+	}
+}
+
+// ReadDescriptorSets reads and merges FileDescriptorSet files. Files that are
+// included in several sets are only kept once.
+func ReadDescriptorSets(paths []string) (*descriptorpb.FileDescriptorSet, error) {
+	merged := new(descriptorpb.FileDescriptorSet)
+	seen := make(map[string]bool)
+
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		set := new(descriptorpb.FileDescriptorSet)
+		if err := proto.Unmarshal(b, set); err != nil {
+			return nil, fmt.Errorf("could not parse the FileDescriptorSet in %s: %v", path, err)
+		}
+
+		for _, f := range set.GetFile() {
+			if !seen[f.GetName()] {
+				seen[f.GetName()] = true
+				merged.File = append(merged.File, f)
+			}
+		}
+	}
+
+	return merged, nil
+}
+
+// CompileProtoFiles compiles .proto files into a FileDescriptorSet (along with
+// the files they import), using the protoc compiler found in the PATH. The
+// import paths are passed to protoc using its -I flag.
+func CompileProtoFiles(importPaths []string, files []string) (*descriptorpb.FileDescriptorSet, error) {
+	dir, err := ioutil.TempDir("", "protohash")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "descriptor_set.pb")
+	args := []string{"--include_imports", "--descriptor_set_out=" + out}
+	for _, p := range importPaths {
+		args = append(args, "-I", p)
+	}
+	args = append(args, files...)
+
+	cmd := exec.Command("protoc", args...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("could not compile %s with protoc: %v", strings.Join(files, ", "), err)
+	}
+
+	return ReadDescriptorSets([]string{out})
+}
+
+// Resolver finds message types and extensions within a FileDescriptorSet. The
+// messages and extensions it returns are dynamic (see dynamicpb).
+//
+// It can be used for resolving the contents of google.protobuf.Any messages,
+// both when hashing them (see protohash.AnyResolver) and when parsing them
+// (ex. with protojson.UnmarshalOptions), as well as for resolving extensions
+// (see protohash.ExtensionResolver).
+type Resolver struct {
+	files      *protoregistry.Files
+	extensions *protoregistry.Types
+}
+
+// NewResolver returns a Resolver for the messages and extensions of a
+// FileDescriptorSet, which must be self-contained.
+func NewResolver(set *descriptorpb.FileDescriptorSet) (*Resolver, error) {
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("could not build the descriptors of a FileDescriptorSet: %v", err)
+	}
+
+	extensions := new(protoregistry.Types)
+	files.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+		err = registerExtensions(extensions, f.Extensions(), f.Messages())
+		return err == nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not register the extensions of a FileDescriptorSet: %v", err)
+	}
+
+	return &Resolver{files: files, extensions: extensions}, nil
+}
+
+// registerExtensions registers extensions, along with the extensions declared
+// within messages (and their nested messages).
+func registerExtensions(types *protoregistry.Types, extensions protoreflect.ExtensionDescriptors, messages protoreflect.MessageDescriptors) error {
+	for i := 0; i < extensions.Len(); i++ {
+		if err := types.RegisterExtension(dynamicpb.NewExtensionType(extensions.Get(i))); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		if err := registerExtensions(types, md.Extensions(), md.Messages()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindMessageByName returns the type of the message with the given full name.
+func (r *Resolver) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	d, err := r.files.FindDescriptorByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, protoregistry.NotFound
+	}
+	return dynamicpb.NewMessageType(md), nil
+}
+
+// FindMessageByURL returns the type of the message with the given type URL
+// (ex. "type.googleapis.com/example.Person").
+func (r *Resolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	return r.FindMessageByName(protoreflect.FullName(url[strings.LastIndexByte(url, '/')+1:]))
+}
+
+// FindExtensionByName returns the extension with the given full name.
+func (r *Resolver) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	return r.extensions.FindExtensionByName(field)
+}
+
+// FindExtensionByNumber returns the extension of the given message with the
+// given tag number.
+func (r *Resolver) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	return r.extensions.FindExtensionByNumber(message, field)
+}
+
+// RangeExtensionsByMessage calls f for every extension of the given message,
+// which lets protohash.LintSchema check the extensions.
+func (r *Resolver) RangeExtensionsByMessage(message protoreflect.FullName, f func(protoreflect.ExtensionType) bool) {
+	r.extensions.RangeExtensionsByMessage(message, f)
+}
+
+// Resolve returns an empty message of the type with the given type URL (see
+// protohash.TypeResolver).
+func (r *Resolver) Resolve(typeURL string) (proto.Message, error) {
+	mt, err := r.FindMessageByURL(typeURL)
+	if err != nil {
+		return nil, err
+	}
+	return mt.New().Interface(), nil
+}
@@ -0,0 +1,407 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command protohash prints the ObjectHash of a proto message.
+//
+// Usage:
+//
+//	protohash -descriptor_set=SET -message=NAME [flags] [FILE]
+//	protohash -proto=FILE.proto [-I=DIR] -message=NAME [flags] [FILE]
+//
+// The schema of the message is read from FileDescriptorSet files (as produced
+// by protoc's --descriptor_set_out flag, along with --include_imports), or
+// compiled from .proto files using protoc. The message is read from the given
+// file, or from the standard input, in the wire format, the text format or the
+// JSON format.
+//
+// The flags of the hasher mirror the options of the protohash package (ex.
+// -field_names_as_keys for FieldNamesAsKeys).
+//
+// With the -delimited flag, the input is a stream of messages in the wire
+// format, each preceded by its size as a varint (ie. as written by Java's
+// writeDelimitedTo). One hash is printed per message, optionally preceded by
+// the offset of the message within the stream (with -offsets). The hash of the
+// list of all the messages, which is the hash they would have as the elements
+// of a repeated field, can be printed last (with -aggregate).
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/sha3"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	protohash "github.com/deepmind/objecthash-proto"
+	"github.com/deepmind/objecthash-proto/cmd/internal/cmdutil"
+)
+
+// hashFunctions are the supported values of the -hash flag.
+var hashFunctions = map[string]func() hash.Hash{
+	"sha256":     sha256.New,
+	"sha512_256": sha512.New512_256,
+	"sha3_256":   sha3.New256,
+	"blake2b_256": func() hash.Hash {
+		h, _ := blake2b.New256(nil)
+		return h
+	},
+}
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(os.Stderr, "protohash: %v\n", err)
+		}
+		os.Exit(2)
+	}
+}
+
+// command is a parsed command line.
+type command struct {
+	hasher    protohash.ProtoHasher
+	md        protoreflect.MessageDescriptor
+	unmarshal func(b []byte, m proto.Message) error
+	encode    func(h []byte) string
+	input     string
+
+	// Flags for streams of delimited messages.
+	delimited bool
+	offsets   bool
+	aggregate bool
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	cmd, err := parseCommand(args)
+	if err != nil {
+		return err
+	}
+
+	in := stdin
+	if cmd.input != "" && cmd.input != "-" {
+		f, err := os.Open(cmd.input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	if cmd.delimited {
+		w := bufio.NewWriter(stdout)
+		if err := cmd.hashDelimited(bufio.NewReader(in), w); err != nil {
+			w.Flush()
+			return err
+		}
+		return w.Flush()
+	}
+
+	b, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	h, err := cmd.hash(b)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintln(stdout, cmd.encode(h))
+	return err
+}
+
+// maxMessageSize is the maximum size of a message in the wire format (2 GiB
+// minus one byte), above which the sizes of delimited messages are invalid.
+const maxMessageSize = math.MaxInt32
+
+// hashDelimited prints the hashes of a stream of delimited messages, one by
+// one, followed by their aggregate hash if needed.
+func (cmd *command) hashDelimited(r *bufio.Reader, w io.Writer) error {
+	var hashes [][]byte
+	var offset int64
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			break
+		}
+
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return fmt.Errorf("could not read the size of the message at offset %d: %v", offset, err)
+		}
+		if size > maxMessageSize {
+			return fmt.Errorf("the message at offset %d is too large: its size is %d bytes, while the maximum is %d", offset, size, maxMessageSize)
+		}
+		// The message is read without allocating its size upfront, so that
+		// the memory used is bounded by the size of the actual input.
+		b := new(bytes.Buffer)
+		if n, err := io.CopyN(b, r, int64(size)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("could not read the message at offset %d (got %d of its %d bytes): %v", offset, n, size, err)
+		}
+
+		h, err := cmd.hash(b.Bytes())
+		if err != nil {
+			return fmt.Errorf("message at offset %d: %v", offset, err)
+		}
+
+		if cmd.offsets {
+			_, err = fmt.Fprintf(w, "%d\t%s\n", offset, cmd.encode(h))
+		} else {
+			_, err = fmt.Fprintln(w, cmd.encode(h))
+		}
+		if err != nil {
+			return err
+		}
+
+		if cmd.aggregate {
+			hashes = append(hashes, h)
+		}
+		offset += int64(protowire.SizeVarint(size)) + int64(size)
+	}
+
+	if !cmd.aggregate {
+		return nil
+	}
+
+	h, err := protohash.HashList(cmd.hasher, hashes)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "aggregate\t%s\n", cmd.encode(h))
+	return err
+}
+
+// hash parses a message and returns its ObjectHash.
+func (cmd *command) hash(b []byte) ([]byte, error) {
+	m := dynamicpb.NewMessage(cmd.md)
+	if err := cmd.unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("could not parse a %s proto: %v", cmd.md.FullName(), err)
+	}
+	return cmd.hasher.HashProto(m)
+}
+
+func parseCommand(args []string) (*command, error) {
+	fs := flag.NewFlagSet("protohash", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: protohash (-descriptor_set=SET | -proto=FILE.proto) -message=NAME [-delimited] [flags] [FILE]")
+		fs.PrintDefaults()
+	}
+
+	var descriptorSets, protoFiles, importPaths stringList
+	fs.Var(&descriptorSets, "descriptor_set", "A FileDescriptorSet file containing the schema of the message (can be repeated)")
+	fs.Var(&protoFiles, "proto", "A .proto file containing the schema of the message, which is compiled using protoc (can be repeated)")
+	fs.Var(&importPaths, "I", "An import path for compiling .proto files (can be repeated)")
+	message := fs.String("message", "", "The full name of the type of the message (ex. example.Person)")
+	format := fs.String("format", "wire", `The format of the message: "wire", "text" or "json"`)
+	output := fs.String("output", "hex", `The format of the hash: "hex" or "base64"`)
+
+	enumsAsStrings := fs.Bool("enums_as_strings", false, "Hash enum values as strings (see EnumsAsStrings)")
+	fieldNamesAsKeys := fs.Bool("field_names_as_keys", false, "Use field names as keys (see FieldNamesAsKeys)")
+	jsonNamesAsKeys := fs.Bool("json_names_as_keys", false, "Use the JSON names of fields as keys (see JSONNamesAsKeys)")
+	messageIdentifier := fs.String("message_identifier", "", "The type identifier of messages (see MessageIdentifier)")
+	anyMode := fs.String("any", "none", "How google.protobuf.Any messages get hashed: "+cmdutil.AnyModes)
+	var setFields, multisetFields stringList
+	fs.Var(&setFields, "set_field", "The full name of a repeated field to hash as a set (see RepeatedFieldsAsSets, can be repeated)")
+	fs.Var(&multisetFields, "multiset_field", "The full name of a repeated field to hash as a multiset (see RepeatedFieldsAsMultisets, can be repeated)")
+	var ignoredFields stringList
+	fs.Var(&ignoredFields, "ignore_field", "The path of a field to hash as if it was unset (see IgnoreFields, can be repeated)")
+	requiredFields := fs.String("required_fields", "error", "How proto2 required fields get hashed: "+cmdutil.RequiredFieldModes)
+	explicitDefaults := fs.String("explicit_defaults", "error", "How proto2 fields with explicit defaults get hashed: "+cmdutil.ExplicitDefaultModes)
+	hashUnknownFields := fs.Bool("hash_unknown_fields", false, "Hash unknown fields based on their wire types (see HashUnknownFields)")
+	protoJSONCompatible := fs.Bool("protojson_compatible", false, "Hash messages like their JSON format, before applying the other flags (see ProtoJSONCompatible)")
+	extensions := fs.Bool("extensions", false, "Hash extendable messages along with their extensions, which are found in the schema (see ExtensionResolver)")
+	hashFunction := fs.String("hash", "sha256", `The hash function: "sha256", "sha512_256", "sha3_256" or "blake2b_256" (see HashFunction)`)
+	hmacKeyFile := fs.String("hmac_key_file", "", "A file containing a hex-encoded key for calculating HMACs instead of plain hashes (see HMACKey)")
+
+	delimited := fs.Bool("delimited", false, "Read a stream of messages in the wire format, each preceded by its size as a varint, and print one hash per message")
+	offsets := fs.Bool("offsets", false, "Print the offset of each delimited message before its hash, separated by a tab")
+	aggregate := fs.Bool("aggregate", false, `Print the hash of the list of all the delimited messages last, preceded by "aggregate" and a tab`)
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 1 {
+		return nil, errors.New("expected at most one input file")
+	}
+	if *message == "" {
+		return nil, errors.New("the -message flag is required")
+	}
+
+	var set *descriptorpb.FileDescriptorSet
+	var err error
+	switch {
+	case len(descriptorSets) > 0 && len(protoFiles) > 0:
+		return nil, errors.New("the -descriptor_set and -proto flags cannot be used together")
+	case len(descriptorSets) > 0:
+		set, err = cmdutil.ReadDescriptorSets(descriptorSets)
+	case len(protoFiles) > 0:
+		set, err = cmdutil.CompileProtoFiles(importPaths, protoFiles)
+	default:
+		return nil, errors.New("either the -descriptor_set or the -proto flag is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if (*offsets || *aggregate) && !*delimited {
+		return nil, errors.New("the -offsets and -aggregate flags require the -delimited flag")
+	}
+	if *delimited && *format != "wire" {
+		return nil, errors.New("delimited messages must be in the wire format")
+	}
+
+	cmd := &command{
+		input:     fs.Arg(0),
+		delimited: *delimited,
+		offsets:   *offsets,
+		aggregate: *aggregate,
+	}
+	if cmd.md, err = protohash.FindMessageDescriptor(set, *message); err != nil {
+		return nil, err
+	}
+	resolver, err := cmdutil.NewResolver(set)
+	if err != nil {
+		return nil, err
+	}
+
+	// Missing required fields are left for the hasher to deal with (see
+	// -required_fields).
+	switch *format {
+	case "wire":
+		cmd.unmarshal = proto.UnmarshalOptions{Resolver: resolver, AllowPartial: true}.Unmarshal
+	case "text":
+		cmd.unmarshal = prototext.UnmarshalOptions{Resolver: resolver, AllowPartial: true}.Unmarshal
+	case "json":
+		cmd.unmarshal = protojson.UnmarshalOptions{Resolver: resolver, AllowPartial: true}.Unmarshal
+	default:
+		return nil, fmt.Errorf("invalid -format: %q", *format)
+	}
+
+	switch *output {
+	case "hex":
+		cmd.encode = hex.EncodeToString
+	case "base64":
+		cmd.encode = base64.StdEncoding.EncodeToString
+	default:
+		return nil, fmt.Errorf("invalid -output: %q", *output)
+	}
+
+	var opts []protohash.Option
+	if *protoJSONCompatible {
+		// Any messages are resolved using the schema, like when parsing JSON.
+		opts = append(opts, protohash.AnyResolver(resolver), protohash.ProtoJSONCompatible())
+	}
+	anyOpts, err := cmdutil.AnyOptions(*anyMode, resolver)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, anyOpts...)
+	if *extensions {
+		opts = append(opts, protohash.ExtensionResolver(resolver))
+	}
+	if len(setFields) > 0 {
+		opts = append(opts, protohash.RepeatedFieldsAsSets(setFields...))
+	}
+	if len(multisetFields) > 0 {
+		opts = append(opts, protohash.RepeatedFieldsAsMultisets(multisetFields...))
+	}
+	if len(ignoredFields) > 0 {
+		opts = append(opts, protohash.IgnoreFields(ignoredFields...))
+	}
+	requiredOpts, err := cmdutil.RequiredFieldOptions(*requiredFields)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, requiredOpts...)
+	defaultOpts, err := cmdutil.ExplicitDefaultOptions(*explicitDefaults)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, defaultOpts...)
+	if *hashUnknownFields {
+		opts = append(opts, protohash.HashUnknownFields())
+	}
+	if *enumsAsStrings {
+		opts = append(opts, protohash.EnumsAsStrings())
+	}
+	if *fieldNamesAsKeys && *jsonNamesAsKeys {
+		return nil, errors.New("the -field_names_as_keys and -json_names_as_keys flags cannot be used together")
+	}
+	if *fieldNamesAsKeys {
+		opts = append(opts, protohash.FieldNamesAsKeys())
+	}
+	if *jsonNamesAsKeys {
+		opts = append(opts, protohash.JSONNamesAsKeys())
+	}
+	if *messageIdentifier != "" {
+		opts = append(opts, protohash.MessageIdentifier(*messageIdentifier))
+	}
+
+	hashFunc, ok := hashFunctions[*hashFunction]
+	if !ok {
+		return nil, fmt.Errorf("invalid -hash: %q", *hashFunction)
+	}
+	opts = append(opts, protohash.HashFunction(hashFunc))
+
+	// The key is read from a file rather than from the command line, where it
+	// would be visible to other users (ex. with ps).
+	if *hmacKeyFile != "" {
+		b, err := ioutil.ReadFile(*hmacKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read -hmac_key_file: %v", err)
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid -hmac_key_file: %v", err)
+		}
+		opts = append(opts, protohash.HMACKey(key))
+	}
+
+	cmd.hasher = protohash.NewHasher(opts...)
+	return cmd, nil
+}
@@ -0,0 +1,410 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	any_pb "github.com/golang/protobuf/ptypes/any"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	protohash "github.com/deepmind/objecthash-proto"
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// writeDescriptorSet writes the FileDescriptorSet of a message (including the
+// files it imports, followed by the extra files) into a temporary file, and
+// returns its path.
+func writeDescriptorSet(t *testing.T, msg proto.Message, extraFiles ...*descriptorpb.FileDescriptorProto) string {
+	t.Helper()
+
+	set := new(descriptorpb.FileDescriptorSet)
+	seen := make(map[string]bool)
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	add(msg.ProtoReflect().Descriptor().ParentFile())
+	set.File = append(set.File, extraFiles...)
+
+	b, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "descriptor_set.pb")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestRun checks that the command prints the same hashes as the library.
+func TestRun(t *testing.T) {
+	simple := protoV1.MessageV2(&pb3_latest.Simple{StringField: "foo", Int64Field: -5, SimpleField: &pb3_latest.Simple{BoolField: true}})
+	descriptorSet := writeDescriptorSet(t, simple)
+
+	value, err := proto.Marshal(simple)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownTypes := protoV1.MessageV2(&pb3_latest.KnownTypes{
+		AnyField: &any_pb.Any{TypeUrl: "type.googleapis.com/schema.proto3.Simple", Value: value},
+	})
+	knownTypesSet := writeDescriptorSet(t, knownTypes)
+
+	wire, err := proto.Marshal(simple)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := prototext.Marshal(simple)
+	if err != nil {
+		t.Fatal(err)
+	}
+	json, err := protojson.Marshal(simple)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownTypesJSON, err := protojson.Marshal(knownTypes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withDefault := protoV1.MessageV2(&pb2_latest.BadWithDefaults{Text: protoV1.String("N/A")})
+	withDefaultSet := writeDescriptorSet(t, withDefault)
+	withDefaultWire, err := proto.Marshal(withDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withoutRequired := protoV1.MessageV2(&pb2_latest.BadWithRequirements{})
+
+	withUnknownField := proto.Clone(simple)
+	withUnknownField.ProtoReflect().SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 1000, protowire.VarintType), 1))
+
+	keyFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := ioutil.WriteFile(keyFile, []byte("736563726574\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	inputFile := filepath.Join(t.TempDir(), "input.txt")
+	if err := ioutil.WriteFile(inputFile, text, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hash := func(msg proto.Message, opts ...protohash.Option) []byte {
+		h, err := protohash.NewHasher(opts...).HashProto(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	testCases := []struct {
+		args     []string
+		input    []byte
+		expected string
+	}{
+		{
+			args:     []string{"-descriptor_set", descriptorSet, "-message", "schema.proto3.Simple"},
+			input:    wire,
+			expected: hex.EncodeToString(hash(simple)),
+		},
+		{
+			args:     []string{"-descriptor_set", descriptorSet, "-message", "schema.proto3.Simple", "-format", "text", "-output", "base64", "-field_names_as_keys"},
+			input:    text,
+			expected: base64.StdEncoding.EncodeToString(hash(simple, protohash.FieldNamesAsKeys())),
+		},
+		{
+			args:     []string{"-descriptor_set", descriptorSet, "-message", "schema.proto3.Simple", "-format", "json", "-message_identifier", "m", "-enums_as_strings"},
+			input:    json,
+			expected: hex.EncodeToString(hash(simple, protohash.MessageIdentifier("m"), protohash.EnumsAsStrings())),
+		},
+		{
+			args:     []string{"-descriptor_set", descriptorSet, "-message", "schema.proto3.Simple", "-format", "json", "-json_names_as_keys"},
+			input:    json,
+			expected: hex.EncodeToString(hash(simple, protohash.JSONNamesAsKeys())),
+		},
+		{
+			args:     []string{"-descriptor_set", knownTypesSet, "-descriptor_set", descriptorSet, "-message", "schema.proto3.KnownTypes", "-format", "json", "-protojson_compatible"},
+			input:    knownTypesJSON,
+			expected: hex.EncodeToString(hash(knownTypes, protohash.ProtoJSONCompatible())),
+		},
+		{
+			args:     []string{"-descriptor_set", descriptorSet, "-message", "schema.proto3.Simple", "-format", "text", "-hash", "sha512_256", "-hmac_key_file", keyFile, inputFile},
+			expected: hex.EncodeToString(hash(simple, protohash.HashFunction(hashFunctions["sha512_256"]), protohash.HMACKey([]byte("secret")))),
+		},
+		{
+			args:     []string{"-descriptor_set", knownTypesSet, "-descriptor_set", descriptorSet, "-message", "schema.proto3.KnownTypes", "-format", "json", "-any", "embedded"},
+			input:    knownTypesJSON,
+			expected: hex.EncodeToString(hash(knownTypes, protohash.AnyResolver(nil))),
+		},
+		{
+			args:     []string{"-descriptor_set", descriptorSet, "-message", "schema.proto3.Simple", "-set_field", "schema.proto3.Repetitive.string_field", "-multiset_field", "schema.proto3.Repetitive.int32_field"},
+			input:    wire,
+			expected: hex.EncodeToString(hash(simple, protohash.RepeatedFieldsAsSets("schema.proto3.Repetitive.string_field"), protohash.RepeatedFieldsAsMultisets("schema.proto3.Repetitive.int32_field"))),
+		},
+		{
+			args:     []string{"-descriptor_set", descriptorSet, "-message", "schema.proto3.Simple", "-ignore_field", "string_field", "-ignore_field", "simple_field.bool_field"},
+			input:    wire,
+			expected: hex.EncodeToString(hash(simple, protohash.IgnoreFields("string_field", "simple_field.bool_field"))),
+		},
+		{
+			args:     []string{"-descriptor_set", descriptorSet, "-message", "schema.proto3.Simple", "-hash_unknown_fields"},
+			input:    append(append([]byte{}, wire...), protowire.AppendVarint(protowire.AppendTag(nil, 1000, protowire.VarintType), 1)...),
+			expected: hex.EncodeToString(hash(withUnknownField, protohash.HashUnknownFields())),
+		},
+		{
+			args:     []string{"-descriptor_set", withDefaultSet, "-message", "schema.proto2.BadWithDefaults", "-explicit_defaults", "unset"},
+			input:    withDefaultWire,
+			expected: hex.EncodeToString(hash(withDefault, protohash.ExplicitDefaultsAsUnset())),
+		},
+		{
+			args:     []string{"-descriptor_set", withDefaultSet, "-message", "schema.proto2.BadWithRequirements", "-format", "json", "-required_fields", "ordinary"},
+			input:    []byte("{}"),
+			expected: hex.EncodeToString(hash(withoutRequired, protohash.RequiredFieldsAsOrdinaryFields())),
+		},
+	}
+
+	for _, tc := range testCases {
+		stdout := new(bytes.Buffer)
+		if err := run(tc.args, bytes.NewReader(tc.input), stdout); err != nil {
+			t.Errorf("Running with %q returned an error: %v", tc.args, err)
+			continue
+		}
+		if actual := strings.TrimSuffix(stdout.String(), "\n"); actual != tc.expected {
+			t.Errorf("Got the wrong output when running with %q.\nActual:   %s\nExpected: %s", tc.args, actual, tc.expected)
+		}
+	}
+}
+
+// TestRunWithExtensions checks that extensions are found in the schema when
+// they are enabled.
+func TestRunWithExtensions(t *testing.T) {
+	extensionsFile := &descriptorpb.FileDescriptorProto{
+		Name:       proto.String("extensions.proto"),
+		Package:    proto.String("schema.proto2"),
+		Dependency: []string{"bad.proto"},
+		Extension: []*descriptorpb.FieldDescriptorProto{{
+			Name:     proto.String("special_number"),
+			Number:   proto.Int32(123),
+			Type:     descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Extendee: proto.String(".schema.proto2.BadWithExtensions"),
+		}},
+	}
+	descriptorSet := writeDescriptorSet(t, protoV1.MessageV2(&pb2_latest.BadWithExtensions{}), extensionsFile)
+
+	specialNumber := &protoV1.ExtensionDesc{
+		ExtendedType:  (*pb2_latest.BadWithExtensions)(nil),
+		ExtensionType: (*int32)(nil),
+		Field:         123,
+		Name:          "schema.proto2.special_number",
+		Tag:           "varint,123,opt,name=special_number,json=specialNumber",
+		Filename:      "extensions.proto",
+	}
+	registry := new(protoregistry.Types)
+	if err := registry.RegisterExtension(specialNumber); err != nil {
+		t.Fatal(err)
+	}
+	m := &pb2_latest.BadWithExtensions{Text: protoV1.String("Test")}
+	if err := protoV1.SetExtension(m, specialNumber, protoV1.Int32(42)); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := protohash.NewHasher(protohash.ExtensionResolver(registry), protohash.FieldNamesAsKeys()).HashProto(protoV1.MessageV2(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire, err := protoV1.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := []string{"-descriptor_set", descriptorSet, "-message", "schema.proto2.BadWithExtensions", "-field_names_as_keys"}
+	if err := run(args, bytes.NewReader(wire), ioutil.Discard); err == nil {
+		t.Error("Expected an error for an extendable message without the -extensions flag")
+	}
+
+	stdout := new(bytes.Buffer)
+	if err := run(append(args, "-extensions"), bytes.NewReader(wire), stdout); err != nil {
+		t.Fatal(err)
+	}
+	if actual := strings.TrimSuffix(stdout.String(), "\n"); actual != hex.EncodeToString(expected) {
+		t.Errorf("Got the wrong hash with extensions: %s, expected %x", actual, expected)
+	}
+}
+
+// TestRunWithBadArguments checks that bad arguments are rejected.
+func TestRunWithBadArguments(t *testing.T) {
+	descriptorSet := writeDescriptorSet(t, protoV1.MessageV2(&pb3_latest.Simple{}))
+	base := []string{"-descriptor_set", descriptorSet, "-message", "schema.proto3.Simple"}
+
+	badKeyFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := ioutil.WriteFile(badKeyFile, []byte("not hex"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := [][]string{
+		{"-message", "schema.proto3.Simple"},
+		{"-descriptor_set", descriptorSet},
+		{"-descriptor_set", descriptorSet, "-message", "schema.proto3.Unknown"},
+		{"-descriptor_set", filepath.Join(t.TempDir(), "missing.pb"), "-message", "schema.proto3.Simple"},
+		append(base, "-proto", "simple.proto"),
+		append(base, "-format", "yaml"),
+		append(base, "-output", "binary"),
+		append(base, "-any", "everything"),
+		append(base, "-required_fields", "optional"),
+		append(base, "-explicit_defaults", "zero"),
+		append(base, "-hash", "md5"),
+		append(base, "-hmac_key", "736563726574"),
+		append(base, "-hmac_key_file", badKeyFile),
+		append(base, "-hmac_key_file", filepath.Join(t.TempDir(), "missing.txt")),
+		append(base, "first.pb", "second.pb"),
+		append(base, "-offsets"),
+		append(base, "-aggregate"),
+		append(base, "-delimited", "-format", "text"),
+		append(base, "-field_names_as_keys", "-json_names_as_keys"),
+	}
+
+	for _, args := range testCases {
+		if err := run(args, bytes.NewReader(nil), ioutil.Discard); err == nil {
+			t.Errorf("Expected an error when running with %q", args)
+		}
+	}
+
+	// Bad inputs are rejected too.
+	if err := run(append(base, "-format", "json"), strings.NewReader("{"), ioutil.Discard); err == nil {
+		t.Error("Expected an error for a malformed input")
+	}
+}
+
+// TestRunWithDelimitedMessages checks the hashes of streams of delimited
+// messages.
+func TestRunWithDelimitedMessages(t *testing.T) {
+	messages := []proto.Message{
+		protoV1.MessageV2(&pb3_latest.Simple{StringField: "foo"}),
+		protoV1.MessageV2(&pb3_latest.Simple{}),
+		protoV1.MessageV2(&pb3_latest.Simple{Int64Field: -5, StringField: strings.Repeat("bar", 100)}),
+	}
+	descriptorSet := writeDescriptorSet(t, messages[0])
+	hasher := protohash.NewHasher(protohash.FieldNamesAsKeys())
+
+	var stream []byte
+	var lines, offsetLines []string
+	var hashes [][]byte
+	for _, m := range messages {
+		b, err := proto.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h, err := hasher.HashProto(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines = append(lines, hex.EncodeToString(h))
+		offsetLines = append(offsetLines, fmt.Sprintf("%d\t%x", len(stream), h))
+		hashes = append(hashes, h)
+		stream = protowire.AppendBytes(stream, b)
+	}
+
+	aggregate, err := protohash.HashList(hasher, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aggregateLine := fmt.Sprintf("aggregate\t%x", aggregate)
+
+	base := []string{"-descriptor_set", descriptorSet, "-message", "schema.proto3.Simple", "-field_names_as_keys", "-delimited"}
+	testCases := []struct {
+		args     []string
+		input    []byte
+		expected []string
+	}{
+		{args: base, input: stream, expected: lines},
+		{args: append(base, "-offsets"), input: stream, expected: offsetLines},
+		{args: append(base, "-aggregate"), input: stream, expected: append(lines, aggregateLine)},
+		{args: append(base, "-aggregate"), input: nil, expected: []string{"aggregate\tacac86c0e609ca906f632b0e2dacccb2b77d22b0621f20ebece1a4835b93f6f0"}},
+	}
+
+	for _, tc := range testCases {
+		stdout := new(bytes.Buffer)
+		if err := run(tc.args, bytes.NewReader(tc.input), stdout); err != nil {
+			t.Errorf("Running with %q returned an error: %v", tc.args, err)
+			continue
+		}
+		if actual, expected := stdout.String(), strings.Join(tc.expected, "\n")+"\n"; actual != expected {
+			t.Errorf("Got the wrong output when running with %q.\nActual:\n%s\nExpected:\n%s", tc.args, actual, expected)
+		}
+	}
+
+	// Truncated streams are rejected, after printing the hashes of the
+	// complete messages.
+	stdout := new(bytes.Buffer)
+	if err := run(base, bytes.NewReader(stream[:len(stream)-1]), stdout); err == nil {
+		t.Error("Expected an error for a truncated stream")
+	}
+	if actual, expected := stdout.String(), strings.Join(lines[:2], "\n")+"\n"; actual != expected {
+		t.Errorf("Got the wrong output for a truncated stream.\nActual:\n%s\nExpected:\n%s", actual, expected)
+	}
+
+	// So are truncated sizes, and sizes above the maximum size of a message.
+	for _, size := range [][]byte{
+		{0x80},
+		protowire.AppendVarint(nil, maxMessageSize+1),
+		protowire.AppendVarint(nil, math.MaxUint64),
+	} {
+		if err := run(base, bytes.NewReader(append(stream[:len(stream):len(stream)], size...)), ioutil.Discard); err == nil {
+			t.Errorf("Expected an error for a stream ending with the size %x", size)
+		}
+	}
+
+	// Huge sizes followed by short messages are rejected without allocating
+	// that much memory.
+	huge := append(protowire.AppendVarint(nil, maxMessageSize), stream...)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	if err := run(base, bytes.NewReader(huge), ioutil.Discard); err == nil {
+		t.Error("Expected an error for a huge size followed by a short message")
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 64<<20 {
+		t.Errorf("Allocated %d bytes for reading a short message with a huge size", allocated)
+	}
+}
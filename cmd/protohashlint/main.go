@@ -0,0 +1,122 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Command protohashlint reports the messages and fields of proto schemas that
+// would make hashing some of their values fail.
+//
+// Usage:
+//
+//	protohashlint [-any=MODE] [-extensions] [-required_fields=MODE] [-explicit_defaults=MODE] [-message=NAME] DESCRIPTOR_SET...
+//
+// The schemas are read from FileDescriptorSet files, as produced by protoc's
+// --descriptor_set_out flag (along with --include_imports). Every message
+// defined in the files is checked, unless a single message is given with the
+// -message flag. The exit status is 1 if any problems are found.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	protohash "github.com/deepmind/objecthash-proto"
+	"github.com/deepmind/objecthash-proto/cmd/internal/cmdutil"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command, and returns its exit status: 0 if no problems are
+// found, 1 if some are, and 2 if the schemas cannot be checked.
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("protohashlint", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: protohashlint [flags] DESCRIPTOR_SET...")
+		fs.PrintDefaults()
+	}
+
+	anyMode := fs.String("any", "none", "How google.protobuf.Any messages get hashed: "+cmdutil.AnyModes)
+	extensions := fs.Bool("extensions", false, "Allow extendable messages, and check their extensions, which are found in the schemas (see ExtensionResolver)")
+	requiredFields := fs.String("required_fields", "error", "How proto2 required fields get hashed: "+cmdutil.RequiredFieldModes)
+	explicitDefaults := fs.String("explicit_defaults", "error", "How proto2 fields with explicit defaults get hashed: "+cmdutil.ExplicitDefaultModes)
+	message := fs.String("message", "", "The full name of a single message to check, along with the messages it can contain")
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	problems, err := lint(fs.Args(), *anyMode, *extensions, *requiredFields, *explicitDefaults, *message)
+	if err != nil {
+		fmt.Fprintf(stderr, "protohashlint: %v\n", err)
+		return 2
+	}
+
+	for _, p := range problems {
+		fmt.Fprintln(stdout, p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// lint returns the problems of the schemas read from some FileDescriptorSet
+// files, or of a single message of theirs, for a hasher configured by the
+// flags.
+func lint(descriptorSets []string, anyMode string, extensions bool, requiredFields, explicitDefaults, message string) ([]protohash.SchemaProblem, error) {
+	set, err := cmdutil.ReadDescriptorSets(descriptorSets)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, err := cmdutil.AnyOptions(anyMode, nil)
+	if err != nil {
+		return nil, err
+	}
+	requiredOpts, err := cmdutil.RequiredFieldOptions(requiredFields)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, requiredOpts...)
+	defaultOpts, err := cmdutil.ExplicitDefaultOptions(explicitDefaults)
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, defaultOpts...)
+	if extensions {
+		resolver, err := cmdutil.NewResolver(set)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, protohash.ExtensionResolver(resolver))
+	}
+	hasher := protohash.NewHasher(opts...)
+
+	if message == "" {
+		return protohash.LintSchema(hasher, set)
+	}
+
+	md, err := protohash.FindMessageDescriptor(set, message)
+	if err != nil {
+		return nil, err
+	}
+	return protohash.LintMessage(hasher, md)
+}
@@ -0,0 +1,170 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	protohash "github.com/deepmind/objecthash-proto"
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// writeDescriptorSet writes the FileDescriptorSet of some messages (including
+// the files they import) into a temporary file, and returns its path.
+func writeDescriptorSet(t *testing.T, msgs ...proto.Message) string {
+	t.Helper()
+
+	set := new(descriptorpb.FileDescriptorSet)
+	seen := make(map[string]bool)
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	for _, msg := range msgs {
+		add(msg.ProtoReflect().Descriptor().ParentFile())
+	}
+
+	b, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "descriptor_set.pb")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestRun checks that the command prints the same problems as the library,
+// and that its exit status reports whether there are any.
+func TestRun(t *testing.T) {
+	requirements := protoV1.MessageV2(&pb2_latest.BadWithRequirements{})
+	knownTypes := protoV1.MessageV2(&pb3_latest.KnownTypes{})
+	descriptorSet := writeDescriptorSet(t, requirements, knownTypes)
+
+	// problems returns the output expected for the problems of a message.
+	problems := func(msg proto.Message, opts ...protohash.Option) string {
+		found, err := protohash.LintMessage(protohash.NewHasher(opts...), msg.ProtoReflect().Descriptor())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(found) == 0 {
+			t.Fatalf("Expected some problems with %s", msg.ProtoReflect().Descriptor().FullName())
+		}
+		b := new(strings.Builder)
+		for _, p := range found {
+			fmt.Fprintln(b, p)
+		}
+		return b.String()
+	}
+
+	testCases := []struct {
+		args     []string
+		expected string
+		status   int
+	}{
+		{
+			args:     []string{"-message", "schema.proto2.BadWithRequirements", descriptorSet},
+			expected: problems(requirements),
+			status:   1,
+		},
+		{
+			args:   []string{"-message", "schema.proto2.BadWithRequirements", "-required_fields", "ordinary", descriptorSet},
+			status: 0,
+		},
+		{
+			args:     []string{"-message", "schema.proto3.KnownTypes", descriptorSet},
+			expected: problems(knownTypes),
+			status:   1,
+		},
+		{
+			args:   []string{"-message", "schema.proto3.KnownTypes", "-any", "embedded", descriptorSet},
+			status: 0,
+		},
+		{
+			args:   []string{"-message", "schema.proto3.KnownTypes", "-any", "bytes", descriptorSet},
+			status: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		stdout, stderr := new(bytes.Buffer), new(bytes.Buffer)
+		if status := run(tc.args, stdout, stderr); status != tc.status {
+			t.Errorf("Running with %q returned the exit status %d, expected %d (stderr: %s)", tc.args, status, tc.status, stderr)
+		}
+		if actual := stdout.String(); actual != tc.expected {
+			t.Errorf("Got the wrong output when running with %q.\nActual:\n%s\nExpected:\n%s", tc.args, actual, tc.expected)
+		}
+	}
+
+	// The problems of every message of the schema are reported by default,
+	// including the required field and google.protobuf.Any.
+	stdout := new(bytes.Buffer)
+	if status := run([]string{descriptorSet}, stdout, ioutil.Discard); status != 1 {
+		t.Errorf("Running with the whole schema returned the exit status %d, expected 1", status)
+	}
+	for _, expected := range []string{problems(requirements), problems(knownTypes)} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Errorf("The output for the whole schema does not contain the expected problems.\nActual:\n%s\nExpected:\n%s", stdout, expected)
+		}
+	}
+}
+
+// TestRunWithBadArguments checks that bad command lines result in the exit
+// status 2.
+func TestRunWithBadArguments(t *testing.T) {
+	descriptorSet := writeDescriptorSet(t, protoV1.MessageV2(&pb3_latest.Simple{}))
+
+	testCases := [][]string{
+		{},
+		{"-unknown_flag", descriptorSet},
+		{filepath.Join(t.TempDir(), "missing.pb")},
+		{"-message", "schema.proto3.Unknown", descriptorSet},
+		{"-any", "everything", descriptorSet},
+		{"-required_fields", "optional", descriptorSet},
+		{"-explicit_defaults", "zero", descriptorSet},
+	}
+
+	for _, args := range testCases {
+		stdout := new(bytes.Buffer)
+		if status := run(args, stdout, ioutil.Discard); status != 2 {
+			t.Errorf("Running with %q returned the exit status %d, expected 2", args, status)
+		}
+		if stdout.Len() > 0 {
+			t.Errorf("Running with %q printed some problems: %s", args, stdout)
+		}
+	}
+}
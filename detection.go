@@ -15,227 +15,174 @@
 package protohash
 
 import (
-	"errors"
 	"fmt"
 	"reflect"
-	"strings"
 
-	"github.com/golang/protobuf/proto"
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
-// wktProto is the interface satisfied by the proto library's well-known types.
-//
-// Notice that the method XXX_WellKnownType requires a pointer receiver, so you
-// should take the Addr() of a reflect.value before checking if it satisfies
-// this interface.
-type wktProto interface {
-	proto.Message
-	XXX_WellKnownType() string
+// wellKnownTypesPackage is the proto package of the proto library's
+// well-known types.
+const wellKnownTypesPackage protoreflect.FullName = "google.protobuf"
+
+// regularGoogleProtobufFiles are the files that define messages within the
+// well-known types package which do not have any special semantics. Messages
+// defined in those files are hashed like any other message.
+var regularGoogleProtobufFiles = map[string]bool{
+	"google/protobuf/api.proto":            true,
+	"google/protobuf/descriptor.proto":     true,
+	"google/protobuf/field_mask.proto":     true,
+	"google/protobuf/source_context.proto": true,
+	"google/protobuf/type.proto":           true,
 }
 
-// CheckWellKnownType checks if a value is one of the proto library's well-known
-// types. The ok return value reports whether the value is a well-known type or
-// not, while the name return value reports the name provided by the proto
-// library for this type.
+// CheckWellKnownType checks if a message is one of the proto library's
+// well-known types. The ok return value reports whether the message is a
+// well-known type or not, while the name return value reports its short name
+// (ex. "Timestamp" for google.protobuf.Timestamp).
 //
-// This is done by checking if the proto message has a XXX_WellKnownType method
-// defined on it.
-func CheckWellKnownType(sv reflect.Value) (name string, ok bool) {
-	// The method XXX_WellKnownType requires a pointer receiver.
-	wellKnownValue, ok := sv.Addr().Interface().(wktProto)
-	if ok {
-		return wellKnownValue.XXX_WellKnownType(), true
-	}
-
-	return "", false
-}
-
-// isExtendable checks if the proto message is extendable.
+// This is done by checking if the message is defined at the top level of the
+// google.protobuf package, outside of the files that define messages with no
+// special semantics (ex. google/protobuf/descriptor.proto).
 //
-// This is done by checking if it has the ExtensionRangeArray method.
-func isExtendable(sv reflect.Value) bool {
-	type extendableProto interface {
-		proto.Message
-		ExtensionRangeArray() []proto.ExtensionRange
+// It used to take the reflect.Value of a generated message struct, which
+// cannot describe dynamic messages. The descriptor of a generated message can
+// be passed instead (ex. proto.MessageV2(m).ProtoReflect().Descriptor()).
+func CheckWellKnownType(md protoreflect.MessageDescriptor) (name string, ok bool) {
+	if md.FullName().Parent() != wellKnownTypesPackage {
+		return "", false
 	}
 
-	_, ok := sv.Addr().Interface().(extendableProto)
-	return ok
-}
-
-// isRawMessageField checks if the proto field is a RawMessage.
-//
-// This is done by checking if it has the Bytes method.
-func isRawMessageField(v reflect.Value) bool {
-	type rawMessageField interface {
-		Bytes() []byte
+	if file := md.ParentFile(); file != nil && regularGoogleProtobufFiles[file.Path()] {
+		return "", false
 	}
 
-	_, ok := v.Interface().(rawMessageField)
-	return ok
+	return string(md.Name()), true
 }
 
-// isAOneOfField checks if the proto field is a oneof wrapper field.
+// isExtendable checks if the proto message is extendable.
 //
-// This is done by checking if it is an interface whose tag has a
-// "protobuf_oneof" entry.
-func isAOneOfField(v reflect.Value, sf reflect.StructField) bool {
-	return v.Kind() == reflect.Interface && sf.Tag.Get("protobuf_oneof") != ""
+// This is done by checking if its descriptor has any extension ranges.
+func isExtendable(md protoreflect.MessageDescriptor) bool {
+	return md.ExtensionRanges().Len() > 0
 }
 
-// isAProto2BytesField checks if the field is a proto2 bytes field.
+// isUnset checks if the proto field has not been set.
 //
-// This is done by checking the field's tag. Byte fields do not have a 'rep'
-// tag (for repeated fields).  Additionally, proto3 byte fields have a 'proto3'
-// tag, which proto2 byte fields do not have.
-func isAProto2BytesField(v reflect.Value, sf reflect.StructField) bool {
-	k := v.Kind()
-	if k != reflect.Map && k != reflect.Slice {
-		return false
+// This also includes empty proto3 scalar values.
+func isUnset(m protoreflect.Message, fd protoreflect.FieldDescriptor) bool {
+	if !m.Has(fd) {
+		return true
 	}
 
-	tag := sf.Tag.Get("protobuf")
-
-	// This can potentially break "def" values which come last and can contain
-	// commas. This is fine because we don't care about "def" or its value here.
+	// Default values are considered empty. Otherwise, adding those kinds of
+	// fields to a proto's definition would break all older hashes.
 	//
-	// Example: "bytes,10,opt,name=F_String,json=FString,def=hello, \"world!\"\n"
-	fields := strings.Split(tag, ",")
-
-	// Normal proto tags (incl. bytes) will have at least 2 fields.
-	if len(fields) < 2 {
-		// We do not return an error because this case can happen for certain kinds
-		// of fields, such as 'XXX_unrecognized' for example.
-		return false
-	}
-
-	for i := 2; i < len(fields); i++ {
-		f := fields[i]
-		if f == "rep" || f == "proto3" {
-			return false
+	// The proto library considers negative zero to be set for scalar fields
+	// without presence (ie. proto3 scalar fields), since it is serialized.
+	// However, it is equal to the default value, so it is considered unset.
+	switch fd.Kind() {
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		if !fd.HasPresence() && !fd.IsList() && !fd.IsMap() {
+			return m.Get(fd).Float() == 0
 		}
 	}
 
-	return true
+	return false
 }
 
-// isContentIndependentField checks if a proto field is not related to its
-// contents.
+// isUnset checks if the proto field has not been set, for the purpose of
+// hashing it.
 //
-// Content independent fields include:
-// - "XXX_NoUnkeyedLiteral": This is an empty struct whose presence is used by
-//   the proto library to force users to use keyed literals to ensure forward
-//   compatible usage of messages.
-// - "XXX_sizecache": This is used in serialization. Its value is irrelevant to
-//   the actual content of the proto and should not be used in comparisons or
-//   for generating hashes.
-func isContentIndependentField(v reflect.Value, sf reflect.StructField) bool {
-	name := sf.Name
-	return name == "XXX_NoUnkeyedLiteral" || name == "XXX_sizecache"
+// When messages are hashed like their JSON format, negative zero is considered
+// set for proto3 scalar fields, since it appears in the JSON format. Fields set
+// to their explicit default are considered unset with ExplicitDefaultsAsUnset.
+func (hasher *objectHasher) isUnset(m protoreflect.Message, fd protoreflect.FieldDescriptor) bool {
+	if hasher.isSetToExplicitDefault(m, fd) {
+		return true
+	}
+	if hasher.protoJSONCompatible {
+		return !m.Has(fd)
+	}
+	return isUnset(m, fd)
 }
 
-// isUnset checks if the proto field has not been set.
+// isNilMessage checks if a proto value is a nil message.
 //
-// This also includes empty proto3 scalar values.
-func isUnset(v reflect.Value, sf reflect.StructField) (bool, error) {
-	// Default values are considered empty. Otherwise, adding those kinds of
-	// fields to a proto's definition would break all older hashes.
-	switch v.Kind() {
-	case reflect.Bool:
-		return !v.Bool(), nil
-	case reflect.Int32, reflect.Int64:
-		return v.Int() == 0, nil
-	case reflect.Uint32, reflect.Uint64:
-		return v.Uint() == 0, nil
-	case reflect.Float32, reflect.Float64:
-		return v.Float() == 0, nil
-	case reflect.String:
-		return v.String() == "", nil
-	case reflect.Map, reflect.Slice:
-		// Proto2 bytes fields are considered scalar fields, which means that there
-		// is a distinction between unset fields and fields set to zero values.
-		//
-		// Therefore, if we encounter a proto2 bytes field, we should only check if
-		// it's nil or not, rather than checking its value.
-		if isAProto2BytesField(v, sf) {
-			return v.IsNil(), nil
-		}
+// Nil messages can be found within repeated fields, maps, and oneof fields of
+// generated messages. They are not valid proto values.
+func isNilMessage(v protoreflect.Value) bool {
+	m, ok := v.Interface().(protoreflect.Message)
+	return ok && !m.IsValid()
+}
 
-		// If this is not a proto2 bytes field, then empty values are always unset.
-		//
-		// This applies for: repeated fields, proto3 bytes fields, and special
-		// fields (ex. XXX_unrecognized).
-		return v.Len() == 0, nil
-	case reflect.Interface:
-		// This only happens when we have a oneof field.
-		//
-		// Notice that a non-nil oneof interface implies the presence of the field
-		// it represents. Therefore, even if the value of the selected field is zero
-		// it is still different from (and not equal to) the empty value.
-		//
-		// For example, with a proto defined as:
-		//
-		// message M {
-		//   oneof val {
-		//     string a = 1;
-		//     int32 b = 2;
-		//     M2 c = 3;
-		//   }
-		// }
-		//
-		// The following are all different values:
-		// - <>
-		// - < a:"" >
-		// - < b:0  >
-		// - < c:<> >
-		//
-		// - The first does not set any oneof value (ie. it is empty).
-		// - The second sets the oneof field to "a" and is non-empty even with an
-		//   empty string.
-		// - The third sets the oneof field to "b" and is non-empty even with its
-		//   zero value.
-		// - The fourth sets the oneof field to "c" and is non-empty even with an
-		//   empty message. In fact, if "c" was set but was nil, the proto will be
-		//   invalid. The check for this error is made when hashing the oneof value.
-		return v.IsNil(), nil
-	case reflect.Ptr:
-		// If a pointer is not a null pointer, this means that the value it points
-		// to is distinguishable from it being missing. Usually, that value would
-		// be another proto message or a proto2 scalar value.
-		return v.IsNil(), nil
-	case reflect.Struct:
-		// This should never happen because protobuf generated code never uses structs
-		// as fields, and uses pointers to structs instead.
-		// This means that emptiness checks for nested messages would happen in the
-		// reflect.Ptr case rather than here.
-		return false, fmt.Errorf("got an unexpected struct of type '%+v' for field %+v", v.Type(), sf)
-	default:
-		return false, fmt.Errorf("got an unexpected type '%+v' for field %+v", v.Type(), sf)
+// failIfUnsupported returns an error if the provided message cannot be hashed
+// reliably.
+func failIfUnsupported(m protoreflect.Message) error {
+	// A non-empty set of unknown fields means that the proto message contains
+	// some unrecognized fields.
+	if len(m.GetUnknown()) > 0 {
+		return ErrUnrecognizedFields
 	}
+
+	return failIfMalformedOneOfs(m)
 }
 
-// failIfUnsupported returns an error if the provided field cannot be hashed reliably.
+// failIfMalformedOneOfs returns an error if a oneof field of the provided
+// message is set to a nil wrapper value.
 //
-// Note that unsupported fields are safe to ignore if they've not been set, so
-// an isUnset check should be used before this check.
-func failIfUnsupported(v reflect.Value, sf reflect.StructField) error {
-	// Check "XXX_" fields.
-	if name := sf.Name; strings.HasPrefix(name, "XXX_") {
-		switch name {
-		case "XXX_unrecognized":
-			// A non-empty XXX_unrecognized field means that the proto message
-			// contains some unrecognized fields.
-			return errors.New("unrecognized fields cannot be hashed reliably")
-		case "XXX_extensions", "XXX_InternalExtensions":
-			return errors.New("extensions cannot be hashed reliably")
-		default:
-			return fmt.Errorf("found an unknown XXX_ field: '%s'", name)
-		}
+// For example, with a proto defined as:
+//
+//	message M {
+//	  oneof val {
+//	    string a = 1;
+//	  }
+//	}
+//
+// The generated Go code allows setting the oneof field to a nil pointer of the
+// type that wraps "a" (ie. `&M{Val: (*M_A)(nil)}`). The proto library silently
+// considers such oneof fields to be unset, which could hide bugs. Therefore,
+// they're considered invalid.
+//
+// This is checked on the Go struct of generated messages, since such values are
+// not visible through the protoreflect API. Other kinds of messages (ex.
+// dynamic messages) cannot have malformed oneofs.
+func failIfMalformedOneOfs(m protoreflect.Message) error {
+	if errs := malformedOneOfs(m); len(errs) > 0 {
+		return errs[0]
 	}
+	return nil
+}
 
-	if isRawMessageField(v) {
-		return errors.New("raw message fields not supported")
+// malformedOneOfs returns an error for every oneof field of the provided
+// message that is set to a nil wrapper value (see failIfMalformedOneOfs).
+func malformedOneOfs(m protoreflect.Message) []error {
+	if m.Descriptor().Oneofs().Len() == 0 {
+		return nil
 	}
 
-	return nil
+	sv := reflect.Indirect(reflect.ValueOf(protoV1.MessageV1(m.Interface())))
+	if sv.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var errs []error
+	st := sv.Type()
+	for i := 0; i < sv.NumField(); i++ {
+		v := sv.Field(i)
+		oneof := st.Field(i).Tag.Get("protobuf_oneof")
+		if oneof == "" || v.Kind() != reflect.Interface || v.IsNil() {
+			continue
+		}
+
+		// A oneof field is an interface which contains a pointer to an inner
+		// struct that contains the value.
+		if fieldPointer := v.Elem(); fieldPointer.Kind() == reflect.Ptr && fieldPointer.IsNil() {
+			err := fmt.Errorf("%w, whose value is a nil %v", ErrMalformedOneof, fieldPointer.Type())
+			errs = append(errs, withPathElement(err, oneof))
+		}
+	}
+
+	return errs
 }
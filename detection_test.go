@@ -15,74 +15,88 @@
 package protohash
 
 import (
-	"reflect"
+	"math"
 	"testing"
-)
 
-func TestIsAProto2BytesFieldWithBadArguments(t *testing.T) {
-	t.Run("Zero values should return false", func(t *testing.T) {
-		var emptyV reflect.Value
-		var emptySF reflect.StructField
-		if isAProto2BytesField(emptyV, emptySF) {
-			t.Error("isAProto2BytesField incorrectly returned true for zero values.")
-		}
-	})
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
-	t.Run("String values should return false", func(t *testing.T) {
-		v := reflect.ValueOf(struct{ s string }{"Hello"})
-		tp := v.Type()
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
 
-		if isAProto2BytesField(v.Field(0), tp.Field(0)) {
-			t.Error("isAProto2BytesField incorrectly returned true for a string.")
-		}
-	})
+func TestCheckWellKnownType(t *testing.T) {
+	testCases := []struct {
+		message      proto.Message
+		expectedName string
+		expectedOk   bool
+	}{
+		{message: &timestamppb.Timestamp{}, expectedName: "Timestamp", expectedOk: true},
+		{message: &wrapperspb.UInt64Value{}, expectedName: "UInt64Value", expectedOk: true},
+
+		// Messages of the google.protobuf package without any special semantics.
+		{message: &descriptorpb.DescriptorProto{}, expectedOk: false},
+		{message: &fieldmaskpb.FieldMask{}, expectedOk: false},
 
-	t.Run("Integer values should return false", func(t *testing.T) {
-		v := reflect.ValueOf(struct{ i int64 }{42})
-		tp := v.Type()
+		// Regular messages.
+		{message: protoV1.MessageV2(&pb3_latest.Simple{}), expectedOk: false},
+	}
 
-		if isAProto2BytesField(v.Field(0), tp.Field(0)) {
-			t.Error("isAProto2BytesField incorrectly returned true for an int.")
+	for _, tc := range testCases {
+		md := tc.message.ProtoReflect().Descriptor()
+		name, ok := CheckWellKnownType(md)
+		if name != tc.expectedName || ok != tc.expectedOk {
+			t.Errorf("CheckWellKnownType(%s) returned (%q, %t). Expected (%q, %t).", md.FullName(), name, ok, tc.expectedName, tc.expectedOk)
 		}
-	})
+	}
 }
 
-func TestIsUnsetWithBadArguments(t *testing.T) {
-	t.Run("Should return errors for struct fields", func(t *testing.T) {
-		v := reflect.ValueOf(struct {
-			s struct{}
-		}{})
-		tp := v.Type()
+func TestIsUnset(t *testing.T) {
+	testCases := []struct {
+		message  protoV1.Message
+		expected bool
+	}{
+		// Proto3 scalar fields are unset when they're zero, including negative
+		// zero.
+		{message: &pb3_latest.FloatMessage{}, expected: true},
+		{message: &pb3_latest.FloatMessage{Value: float32(math.Copysign(0, -1))}, expected: true},
+		{message: &pb3_latest.FloatMessage{Value: 1}, expected: false},
 
-		_, err := isUnset(v.Field(0), tp.Field(0))
+		// Proto2 scalar fields are set whenever they're present.
+		{message: &pb2_latest.FloatMessage{}, expected: true},
+		{message: &pb2_latest.FloatMessage{Value: protoV1.Float32(0)}, expected: false},
+		{message: &pb2_latest.FloatMessage{Value: protoV1.Float32(float32(math.Copysign(0, -1)))}, expected: false},
+	}
 
-		if err == nil {
-			t.Error("isUnset should have returned an error when running on a struct field.")
+	for _, tc := range testCases {
+		m := protoV1.MessageV2(tc.message).ProtoReflect()
+		fd := m.Descriptor().Fields().ByName("value")
+		if unset := isUnset(m, fd); unset != tc.expected {
+			t.Errorf("isUnset returned %t for the 'value' field of %v. Expected %t.", unset, tc.message, tc.expected)
 		}
+	}
+}
 
-		// Make sure that the returned error is meaningful.
-		expectedError := "got an unexpected struct of type 'struct {}' for field {Name:s PkgPath:github.com/deepmind/objecthash-proto Type:struct {} Tag: Offset:0 Index:[0] Anonymous:false}"
-		if err.Error() != expectedError {
-			t.Errorf("Expected the error returned by isUnset to be '%s'. Instead got '%s'.", expectedError, err)
-		}
-	})
-
-	t.Run("Should return errors for fields with unsupported types", func(t *testing.T) {
-		v := reflect.ValueOf(struct {
-			c chan interface{}
-		}{})
-		tp := v.Type()
-
-		_, err := isUnset(v.Field(0), tp.Field(0))
+func TestIsNilMessage(t *testing.T) {
+	var nilMessage *timestamppb.Timestamp
 
-		if err == nil {
-			t.Error("isUnset should have returned an error when running on a field with an unexpected type.")
-		}
+	testCases := []struct {
+		value    protoreflect.Value
+		expected bool
+	}{
+		{value: protoreflect.ValueOfMessage(nilMessage.ProtoReflect()), expected: true},
+		{value: protoreflect.ValueOfMessage((&timestamppb.Timestamp{}).ProtoReflect()), expected: false},
+		{value: protoreflect.ValueOfString(""), expected: false},
+	}
 
-		// Make sure that the returned error is meaningful.
-		expectedError := "got an unexpected type 'chan interface {}' for field {Name:c PkgPath:github.com/deepmind/objecthash-proto Type:chan interface {} Tag: Offset:0 Index:[0] Anonymous:false}"
-		if err.Error() != expectedError {
-			t.Errorf("Expected the error returned by isUnset to be '%s'. Instead got '%s'.", expectedError, err)
+	for _, tc := range testCases {
+		if isNil := isNilMessage(tc.value); isNil != tc.expected {
+			t.Errorf("isNilMessage returned %t for %v. Expected %t.", isNil, tc.value, tc.expected)
 		}
-	})
+	}
 }
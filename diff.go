@@ -0,0 +1,132 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Diff returns the paths of the values that differ between two messages of
+// the same type (ex. "people[3].address.city"), using the same format as the
+// paths of hash trees (see HashNode).
+//
+// The messages are compared using their hash trees, so only the values whose
+// hashes differ are looked into, and the paths are those of the outermost
+// values that cannot be compared any further: the fields or map entries that
+// are only set in one of the messages, the list elements that only one of the
+// messages has, and the values that are different altogether. Lists are
+// compared element by element, so an element that got inserted into a list
+// makes all the elements after it differ, while sets are compared by the
+// hashes of their elements. The paths are sorted, and there are
+// none if the messages have the same ObjectHash.
+//
+// The hasher must be one returned by NewHasher.
+func Diff(hasher ProtoHasher, a, b proto.Message) ([]string, error) {
+	if _, ok := hasher.(*objectHasher); !ok {
+		return nil, fmt.Errorf("diffs are not supported by %T", hasher)
+	}
+	if a == nil || !a.ProtoReflect().IsValid() || b == nil || !b.ProtoReflect().IsValid() {
+		return nil, errors.New("cannot diff nil messages")
+	}
+	if an, bn := a.ProtoReflect().Descriptor().FullName(), b.ProtoReflect().Descriptor().FullName(); an != bn {
+		return nil, fmt.Errorf("cannot diff messages of different types: %s and %s", an, bn)
+	}
+
+	treeA, err := HashProtoTree(hasher, a)
+	if err != nil {
+		return nil, err
+	}
+	treeB, err := HashProtoTree(hasher, b)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	diffNodes(treeA, treeB, &paths)
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// diffNodes appends the paths of the values that differ between two nodes of
+// hash trees with the same path.
+func diffNodes(a, b *HashNode, paths *[]string) {
+	if bytes.Equal(a.Hash, b.Hash) {
+		return
+	}
+	if !a.container || !b.container || a.TypeIdentifier != b.TypeIdentifier {
+		*paths = append(*paths, a.Path)
+		return
+	}
+
+	if a.TypeIdentifier == listIdentifier {
+		for i := 0; i < len(a.Children) || i < len(b.Children); i++ {
+			switch {
+			case i >= len(a.Children):
+				*paths = append(*paths, b.Children[i].Path)
+			case i >= len(b.Children):
+				*paths = append(*paths, a.Children[i].Path)
+			default:
+				diffNodes(a.Children[i], b.Children[i], paths)
+			}
+		}
+		return
+	}
+
+	// The elements of sets (and multisets) are matched using their hashes, so
+	// only the elements that are missing from either set differ.
+	if a.TypeIdentifier == setIdentifier {
+		counts := make(map[string]int, len(b.Children))
+		for _, c := range b.Children {
+			counts[string(c.Hash)]++
+		}
+		for _, c := range a.Children {
+			if counts[string(c.Hash)] == 0 {
+				*paths = append(*paths, c.Path)
+				continue
+			}
+			counts[string(c.Hash)]--
+		}
+		for i := len(b.Children) - 1; i >= 0; i-- {
+			if c := b.Children[i]; counts[string(c.Hash)] > 0 {
+				*paths = append(*paths, c.Path)
+				counts[string(c.Hash)]--
+			}
+		}
+		return
+	}
+
+	// The entries of dictionaries are matched using the hashes of their keys.
+	entries := make(map[string]*HashNode, len(b.Children))
+	for _, c := range b.Children {
+		entries[string(c.Key.Hash)] = c
+	}
+	for _, c := range a.Children {
+		other, ok := entries[string(c.Key.Hash)]
+		if !ok {
+			*paths = append(*paths, c.Path)
+			continue
+		}
+		delete(entries, string(c.Key.Hash))
+		diffNodes(c, other, paths)
+	}
+	for _, c := range entries {
+		*paths = append(*paths, c.Path)
+	}
+}
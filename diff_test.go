@@ -0,0 +1,115 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"reflect"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	timestamp_pb "github.com/golang/protobuf/ptypes/timestamp"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// TestDiff checks that Diff reports the paths of the values that differ.
+func TestDiff(t *testing.T) {
+	hashers := []ProtoHasher{
+		NewHasher(),
+		NewHasher(FieldNamesAsKeys(), MessageIdentifier(`m`)),
+	}
+
+	family := func() *pb3_latest.PersonV2 {
+		return &pb3_latest.PersonV2{
+			Id:   1,
+			Name: "Alice",
+			Children: []*pb3_latest.PersonV2{
+				{Id: 2, Name: "Bob"},
+				{Id: 3, Name: "Carol", Children: []*pb3_latest.PersonV2{{Name: "Dave", Age: 1}}},
+			},
+		}
+	}
+	renamed := family()
+	renamed.Name = "Alicia"
+	renamed.Children[1].Children[0].Age = 2
+	older := family()
+	older.Age = 40
+	fewerChildren := family()
+	fewerChildren.Children = fewerChildren.Children[:1]
+
+	maps := func() *pb3_latest.StringMaps {
+		return &pb3_latest.StringMaps{
+			StringToString: map[string]string{"foo": "bar", "baz": "qux"},
+			StringToSimple: map[string]*pb3_latest.Simple{"foo": {StringField: "bar", Int32Field: 1}},
+		}
+	}
+	changedMaps := maps()
+	delete(changedMaps.StringToString, "foo")
+	changedMaps.StringToString["a]b"] = "c"
+	changedMaps.StringToSimple["foo"].Int32Field = 2
+
+	testCases := []struct {
+		a, b     protoV1.Message
+		expected []string
+	}{
+		{a: family(), b: family(), expected: nil},
+		{a: family(), b: renamed, expected: []string{"children[1].children[0].age", "name"}},
+		{a: family(), b: older, expected: []string{"age"}},
+		{a: family(), b: fewerChildren, expected: []string{"children[1]"}},
+		{a: fewerChildren, b: family(), expected: []string{"children[1]"}},
+		{a: &pb3_latest.PersonV2{}, b: family(), expected: []string{"children", "id", "name"}},
+		{a: maps(), b: changedMaps, expected: []string{`string_to_simple[foo].int32_field`, `string_to_string["a]b"]`, "string_to_string[foo]"}},
+		{
+			a:        &pb3_latest.KnownTypes{TimestampField: &timestamp_pb.Timestamp{Seconds: 1, Nanos: 2}},
+			b:        &pb3_latest.KnownTypes{TimestampField: &timestamp_pb.Timestamp{Seconds: 1, Nanos: 3}},
+			expected: []string{"timestamp_field.nanos"},
+		},
+	}
+
+	for _, hasher := range hashers {
+		for _, tc := range testCases {
+			paths, err := Diff(hasher, protoV1.MessageV2(tc.a), protoV1.MessageV2(tc.b))
+			if err != nil {
+				t.Fatalf("Diffing %v and %v returned an error: %v", tc.a, tc.b, err)
+			}
+			if !reflect.DeepEqual(paths, tc.expected) {
+				t.Errorf("Got the wrong diff of %v and %v.\nActual:   %q\nExpected: %q", tc.a, tc.b, paths, tc.expected)
+			}
+		}
+	}
+}
+
+// TestDiffWithBadInputs checks that Diff rejects messages it cannot compare.
+func TestDiffWithBadInputs(t *testing.T) {
+	hasher := NewHasher()
+
+	testCases := []struct {
+		a, b protoV1.Message
+	}{
+		{a: nil, b: &pb3_latest.Simple{}},
+		{a: &pb3_latest.Simple{}, b: (*pb3_latest.Simple)(nil)},
+		{a: &pb3_latest.Simple{}, b: &pb3_latest.PersonV2{}},
+	}
+
+	for _, tc := range testCases {
+		if paths, err := Diff(hasher, protoV1.MessageV2(tc.a), protoV1.MessageV2(tc.b)); err == nil {
+			t.Errorf("Expected an error when diffing %v and %v, instead got %q", tc.a, tc.b, paths)
+		}
+	}
+
+	if paths, err := Diff(struct{ ProtoHasher }{hasher}, nil, nil); err == nil {
+		t.Errorf("Expected an error for an unsupported hasher, instead got %q", paths)
+	}
+}
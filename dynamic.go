@@ -0,0 +1,65 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// FindMessageDescriptor returns the descriptor of the message with the given
+// full name (ex. "google.protobuf.Timestamp") from a FileDescriptorSet.
+//
+// The set must be self-contained, meaning that it must include all of the
+// files imported by its files (ie. as produced by protoc's --include_imports
+// flag).
+func FindMessageDescriptor(set *descriptorpb.FileDescriptorSet, name string) (protoreflect.MessageDescriptor, error) {
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("could not build the descriptors of a FileDescriptorSet: %v", err)
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("could not find message %q: %v", name, err)
+	}
+
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("could not find message %q: it is a %T rather than a message", name, d)
+	}
+	return md, nil
+}
+
+// HashWireBytes returns the object hash of a message of the type described by
+// a message descriptor, given its serialized (ie. wire format) bytes.
+//
+// The message is unmarshalled into a dynamic message (see dynamicpb) before
+// being hashed, so its hash is the same as the one HashProto returns for the
+// equivalent generated message.
+func HashWireBytes(hasher ProtoHasher, md protoreflect.MessageDescriptor, b []byte) ([]byte, error) {
+	// Missing required fields are left for the hasher to deal with (see
+	// RequiredFieldsAsOrdinaryFields).
+	m := dynamicpb.NewMessage(md)
+	if err := (proto.UnmarshalOptions{AllowPartial: true}).Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("could not unmarshal a %s proto: %v", md.FullName(), err)
+	}
+	return hasher.HashProto(m)
+}
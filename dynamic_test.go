@@ -0,0 +1,126 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	struct_pb "github.com/golang/protobuf/ptypes/struct"
+	timestamp_pb "github.com/golang/protobuf/ptypes/timestamp"
+	wrappers_pb "github.com/golang/protobuf/ptypes/wrappers"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// fileDescriptorSet returns a self-contained FileDescriptorSet that contains
+// the file which defines the supplied message.
+func fileDescriptorSet(msg proto.Message) *descriptorpb.FileDescriptorSet {
+	set := new(descriptorpb.FileDescriptorSet)
+	seen := make(map[string]bool)
+
+	var add func(fd protoreflect.FileDescriptor)
+	add = func(fd protoreflect.FileDescriptor) {
+		if seen[fd.Path()] {
+			return
+		}
+		seen[fd.Path()] = true
+
+		imports := fd.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			add(imports.Get(i).FileDescriptor)
+		}
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+	}
+	add(msg.ProtoReflect().Descriptor().ParentFile())
+
+	return set
+}
+
+// TestHashWireBytes checks that hashing the wire bytes of messages results in
+// the same hash as hashing the equivalent generated messages.
+func TestHashWireBytes(t *testing.T) {
+	hasher := NewHasher(FieldNamesAsKeys())
+
+	testMessages := []protoV1.Message{
+		&pb3_latest.Simple{StringField: "foo", Int64Field: -5},
+		&pb3_latest.KnownTypes{
+			StructField: &struct_pb.Struct{Fields: map[string]*struct_pb.Value{
+				"foo": {Kind: &struct_pb.Value_StringValue{StringValue: "bar"}},
+			}},
+			TimestampField:  &timestamp_pb.Timestamp{Seconds: 1234, Nanos: 5678},
+			Int64ValueField: &wrappers_pb.Int64Value{},
+		},
+	}
+
+	for _, msg := range testMessages {
+		generated := protoV1.MessageV2(msg)
+		name := string(generated.ProtoReflect().Descriptor().FullName())
+
+		md, err := FindMessageDescriptor(fileDescriptorSet(generated), name)
+		if err != nil {
+			t.Fatalf("Could not find the descriptor of %s: %v", name, err)
+		}
+
+		b, err := proto.Marshal(generated)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := HashWireBytes(hasher, md, b)
+		if err != nil {
+			t.Fatalf("Hashing the wire bytes of %v returned an error: %v", msg, err)
+		}
+
+		expected, err := hasher.HashProto(generated)
+		if err != nil {
+			t.Fatalf("Hashing %v returned an error: %v", msg, err)
+		}
+
+		if !bytes.Equal(h, expected) {
+			t.Errorf("Got the wrong objecthash for the wire bytes of %v.\nActual:   %x\nExpected: %x\n", msg, h, expected)
+		}
+	}
+}
+
+// TestHashWireBytesWithBadInputs tests how FindMessageDescriptor and
+// HashWireBytes handle bad inputs.
+func TestHashWireBytesWithBadInputs(t *testing.T) {
+	set := fileDescriptorSet(protoV1.MessageV2(&pb3_latest.KnownTypes{}))
+
+	for _, name := range []string{"schema.proto3.Unknown", "schema.proto3.KnownTypes.any_field"} {
+		if _, err := FindMessageDescriptor(set, name); err == nil {
+			t.Errorf("Finding the descriptor of %q should have returned an error.", name)
+		}
+	}
+
+	// The set is not self-contained without the files it imports.
+	if _, err := FindMessageDescriptor(&descriptorpb.FileDescriptorSet{File: set.File[len(set.File)-1:]}, "schema.proto3.KnownTypes"); err == nil {
+		t.Error("Finding a descriptor in a FileDescriptorSet with missing imports should have returned an error.")
+	}
+
+	md, err := FindMessageDescriptor(set, "schema.proto3.KnownTypes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := HashWireBytes(NewHasher(), md, []byte{0xff}); err == nil {
+		t.Error("Hashing malformed wire bytes should have returned an error.")
+	}
+}
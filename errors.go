@@ -0,0 +1,145 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Errors returned when hashing messages that cannot be hashed reliably. The
+// errors returned by ProtoHasher.HashProto wrap them, so they can be checked
+// with errors.Is.
+var (
+	// ErrRequiredField is returned for proto2 required fields.
+	ErrRequiredField = errors.New("required fields are not allowed because they're bad for backwards compatibility")
+
+	// ErrExplicitDefault is returned for proto2 fields with explicit default
+	// values.
+	ErrExplicitDefault = errors.New("fields with explicit defaults are not allowed because they're bad for backwards compatibility")
+
+	// ErrNotRepeatedField is returned for fields that are meant to be hashed
+	// as sets or multisets (ex. with the (objecthash.repeated) annotation), but
+	// are not repeated fields.
+	ErrNotRepeatedField = errors.New("only repeated fields can be hashed as sets or multisets")
+
+	// ErrExtendableMessage is returned for messages with extension ranges,
+	// unless extensions are enabled with the ExtensionResolver option.
+	ErrExtendableMessage = errors.New("extendable messages cannot be hashed reliably")
+
+	// ErrUnregisteredExtension is returned for extensions that cannot be found
+	// with the resolver supplied to the ExtensionResolver option, including
+	// extension data that could not be parsed into a known extension.
+	ErrUnregisteredExtension = errors.New("got an unregistered extension")
+
+	// ErrUnrecognizedFields is returned for messages with unknown fields.
+	ErrUnrecognizedFields = errors.New("unrecognized fields cannot be hashed reliably")
+
+	// ErrNilMessage is returned for nil messages that are not valid values (ex.
+	// within repeated fields or maps).
+	ErrNilMessage = errors.New("got a nil message")
+
+	// ErrMalformedOneof is returned for oneof fields of generated messages that
+	// are set to a nil wrapper value.
+	ErrMalformedOneof = errors.New("got a malformed oneof field")
+
+	// ErrUnsupportedType is returned for types that cannot be hashed (ex.
+	// google.protobuf.Any, unless it is enabled with an option).
+	ErrUnsupportedType = errors.New("got an unsupported type")
+
+	// ErrUnresolvableAny is returned for google.protobuf.Any messages whose
+	// type URL cannot be resolved (see AnyResolver). The errors returned by
+	// the resolver are wrapped too.
+	ErrUnresolvableAny = errors.New("could not resolve the type URL of a google.protobuf.Any proto")
+
+	// ErrInvalidWellKnownType is returned for well-known types whose values or
+	// fields are invalid (ex. out of range durations).
+	ErrInvalidWellKnownType = errors.New("got an invalid well-known type")
+)
+
+// HashError describes why a message could not be hashed, and where. The
+// errors returned by ProtoHasher.HashProto are HashErrors, which can be
+// inspected with errors.As.
+type HashError struct {
+	// The path of the value that could not be hashed within the hashed message
+	// (ex. "people[3].address.city"), which is empty when the problem is with
+	// the hashed message itself.
+	Path string
+
+	// The full name of the type of the innermost message that could not be
+	// hashed (ex. "example.Address").
+	MessageType protoreflect.FullName
+
+	// The reason why the value could not be hashed, which wraps one of the
+	// errors above when applicable.
+	Reason error
+}
+
+func (e *HashError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %v", e.MessageType, e.Reason)
+	}
+	return fmt.Sprintf("%s (in %s): %v", e.Path, e.MessageType, e.Reason)
+}
+
+// Unwrap returns the reason of the error.
+func (e *HashError) Unwrap() error {
+	return e.Reason
+}
+
+// withPathElement prepends an element (ex. a field name or a list index within
+// square brackets) to the path of an error, turning it into a HashError if
+// necessary.
+func withPathElement(err error, element string) error {
+	he, ok := err.(*HashError)
+	if !ok {
+		he = &HashError{Reason: err}
+	}
+
+	he.Path = joinPath(element, he.Path)
+	return he
+}
+
+// joinPath joins two paths (or path elements), where list indices and map
+// keys within square brackets are not preceded by a dot.
+func joinPath(parent, child string) string {
+	switch {
+	case parent == "":
+		return child
+	case child == "":
+		return parent
+	case strings.HasPrefix(child, "["):
+		return parent + child
+	default:
+		return parent + "." + child
+	}
+}
+
+// withMessageType sets the message type of an error if it does not have one
+// yet, turning it into a HashError if necessary.
+func withMessageType(err error, md protoreflect.MessageDescriptor) error {
+	he, ok := err.(*HashError)
+	if !ok {
+		he = &HashError{Reason: err}
+	}
+
+	if he.MessageType == "" {
+		he.MessageType = md.FullName()
+	}
+	return he
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"errors"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	any_pb "github.com/golang/protobuf/ptypes/any"
+	duration_pb "github.com/golang/protobuf/ptypes/duration"
+	_struct "github.com/golang/protobuf/ptypes/struct"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// TestHashErrors checks that hashing errors carry their reason and location.
+func TestHashErrors(t *testing.T) {
+	hasher := NewHasher()
+
+	withUnknownFields := &pb3_latest.Simple{}
+	protoV1.MessageReflect(withUnknownFields).SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 1000, protowire.VarintType), 1))
+
+	testCases := []struct {
+		message     protoV1.Message
+		reason      error
+		path        string
+		messageType protoreflect.FullName
+	}{
+		{
+			message:     &pb3_latest.Repetitive{SimpleField: []*pb3_latest.Simple{{}, nil}},
+			reason:      ErrNilMessage,
+			path:        "simple_field[1]",
+			messageType: "schema.proto3.Repetitive",
+		},
+		{
+			message:     &pb3_latest.IntMaps{IntToSimple: map[int64]*pb3_latest.Simple{3: nil}},
+			reason:      ErrNilMessage,
+			path:        "int_to_simple[3]",
+			messageType: "schema.proto3.IntMaps",
+		},
+		{
+			message:     &pb3_latest.Simple{SingletonField: &pb3_latest.Singleton{Singleton: &pb3_latest.Singleton_TheSimple{}}},
+			reason:      ErrNilMessage,
+			path:        "singleton_field.the_simple",
+			messageType: "schema.proto3.Singleton",
+		},
+		{
+			message:     &pb2_latest.Singleton{Singleton: (*pb2_latest.Singleton_TheString)(nil)},
+			reason:      ErrMalformedOneof,
+			path:        "singleton",
+			messageType: "schema.proto2.Singleton",
+		},
+		{
+			message:     &pb2_latest.BadWithDefaults{},
+			reason:      ErrExplicitDefault,
+			path:        "text",
+			messageType: "schema.proto2.BadWithDefaults",
+		},
+		{
+			message:     &pb2_latest.BadWithRequirements{},
+			reason:      ErrRequiredField,
+			path:        "",
+			messageType: "schema.proto2.BadWithRequirements",
+		},
+		{
+			message:     &pb2_latest.BadWithRequirements{Text: protoV1.String("Schlecht!")},
+			reason:      ErrRequiredField,
+			path:        "text",
+			messageType: "schema.proto2.BadWithRequirements",
+		},
+		{
+			message:     &pb2_latest.BadWithExtensions{},
+			reason:      ErrExtendableMessage,
+			path:        "",
+			messageType: "schema.proto2.BadWithExtensions",
+		},
+		{
+			message:     &pb3_latest.Repetitive{SimpleField: []*pb3_latest.Simple{{SimpleField: withUnknownFields}}},
+			reason:      ErrUnrecognizedFields,
+			path:        "simple_field[0].simple_field",
+			messageType: "schema.proto3.Simple",
+		},
+		{
+			message:     &pb3_latest.KnownTypes{DurationField: &duration_pb.Duration{Seconds: 1, Nanos: -1}},
+			reason:      ErrInvalidWellKnownType,
+			path:        "duration_field",
+			messageType: "google.protobuf.Duration",
+		},
+		{
+			message:     &pb3_latest.KnownTypes{StructField: &_struct.Struct{Fields: map[string]*_struct.Value{"a]b": nil}}},
+			reason:      ErrNilMessage,
+			path:        `struct_field["a]b"]`,
+			messageType: "google.protobuf.Struct",
+		},
+		{
+			message:     &pb3_latest.KnownTypes{AnyField: &any_pb.Any{}},
+			reason:      ErrUnsupportedType,
+			path:        "any_field",
+			messageType: "google.protobuf.Any",
+		},
+	}
+
+	for _, tc := range testCases {
+		_, err := hasher.HashProto(protoV1.MessageV2(tc.message))
+		if !errors.Is(err, tc.reason) {
+			t.Errorf("Attempting to hash %T{ %[1]v } should have returned an error wrapping %q, instead got: %v", tc.message, tc.reason, err)
+			continue
+		}
+
+		var hashErr *HashError
+		if !errors.As(err, &hashErr) {
+			t.Errorf("Attempting to hash %T{ %[1]v } returned an error which is not a HashError: %v", tc.message, err)
+			continue
+		}
+		if hashErr.Path != tc.path || hashErr.MessageType != tc.messageType {
+			t.Errorf("Attempting to hash %T{ %[1]v } returned an error with the wrong location. Got %q in %s, expected %q in %s",
+				tc.message, hashErr.Path, hashErr.MessageType, tc.path, tc.messageType)
+		}
+	}
+}
+
+// TestHashErrorMessages checks the messages of hashing errors.
+func TestHashErrorMessages(t *testing.T) {
+	testCases := []struct {
+		err      *HashError
+		expected string
+	}{
+		{
+			err:      &HashError{MessageType: "example.Message", Reason: ErrExtendableMessage},
+			expected: "example.Message: extendable messages cannot be hashed reliably",
+		},
+		{
+			err:      &HashError{Path: "people[3].address", MessageType: "example.Address", Reason: ErrUnrecognizedFields},
+			expected: "people[3].address (in example.Address): unrecognized fields cannot be hashed reliably",
+		},
+	}
+
+	for _, tc := range testCases {
+		if actual := tc.err.Error(); actual != tc.expected {
+			t.Errorf("Got the wrong error message.\nActual:   %s\nExpected: %s", actual, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// messageExtensions returns the message whose fields should be hashed, along
+// with its set extensions sorted by tag number (see ExtensionResolver).
+//
+// Extension data that is still among the unknown fields of the message is
+// parsed with the extension resolver. In that case, the returned message is a
+// copy of the original one, which is never modified. Extension data that
+// remains unknown, as well as set extensions that the resolver does not know
+// about, result in an ErrUnregisteredExtension error.
+func (hasher *objectHasher) messageExtensions(m protoreflect.Message) (protoreflect.Message, []protoreflect.FieldDescriptor, error) {
+	md := m.Descriptor()
+	if !isExtendable(md) {
+		return m, nil, nil
+	}
+	if hasher.extensionResolver == nil {
+		return nil, nil, ErrExtendableMessage
+	}
+
+	if unknown := m.GetUnknown(); len(unknown) > 0 {
+		resolved := proto.Clone(m.Interface()).ProtoReflect()
+		resolved.SetUnknown(nil)
+		opts := proto.UnmarshalOptions{Merge: true, AllowPartial: true, Resolver: hasher.extensionResolver}
+		if err := opts.Unmarshal(unknown, resolved.Interface()); err != nil {
+			return nil, nil, fmt.Errorf("could not parse the unknown fields of the message: %v", err)
+		}
+		if n, ok := unknownExtensionNumber(md, resolved.GetUnknown()); ok {
+			return nil, nil, fmt.Errorf("%w with tag number %d", ErrUnregisteredExtension, n)
+		}
+		m = resolved
+	}
+
+	var extensions []protoreflect.FieldDescriptor
+	var err error
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		if !fd.IsExtension() {
+			return true
+		}
+		xt, findErr := hasher.extensionResolver.FindExtensionByNumber(md.FullName(), fd.Number())
+		if findErr != nil || xt.TypeDescriptor().FullName() != fd.FullName() {
+			err = withPathElement(ErrUnregisteredExtension, fieldLabel(fd))
+			return false
+		}
+		extensions = append(extensions, fd)
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(extensions, func(i, j int) bool { return extensions[i].Number() < extensions[j].Number() })
+	return m, extensions, nil
+}
+
+// unknownExtensionNumber returns the tag number of the first unknown field
+// that is within the extension ranges of a message, if there is one.
+func unknownExtensionNumber(md protoreflect.MessageDescriptor, unknown protoreflect.RawFields) (protoreflect.FieldNumber, bool) {
+	for len(unknown) > 0 {
+		n, _, size := protowire.ConsumeField(unknown)
+		if size < 0 {
+			return 0, false
+		}
+		if md.ExtensionRanges().Has(n) {
+			return n, true
+		}
+		unknown = unknown[size:]
+	}
+	return 0, false
+}
+
+// fieldLabel returns the name of a field within paths, which is the full name
+// of extensions within parentheses (ex. "(example.special_number)").
+func fieldLabel(fd protoreflect.FieldDescriptor) string {
+	if fd.IsExtension() {
+		return "(" + string(fd.FullName()) + ")"
+	}
+	return string(fd.Name())
+}
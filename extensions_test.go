@@ -0,0 +1,210 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+)
+
+// specialNumber is an extension of BadWithExtensions, which is not registered
+// with the proto library.
+var specialNumber = &protoV1.ExtensionDesc{
+	ExtendedType:  (*pb2_latest.BadWithExtensions)(nil),
+	ExtensionType: (*int32)(nil),
+	Field:         123,
+	Name:          "schema.proto2.special_number",
+	Tag:           "varint,123,opt,name=special_number,json=specialNumber",
+	Filename:      "bad.proto",
+}
+
+// withSpecialNumber returns a BadWithExtensions message whose specialNumber
+// extension is set.
+func withSpecialNumber(t *testing.T) *pb2_latest.BadWithExtensions {
+	m := &pb2_latest.BadWithExtensions{Text: protoV1.String("Test")}
+	if err := protoV1.SetExtension(m, specialNumber, protoV1.Int32(42)); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+// withoutExtensions returns a message with the same fields as a
+// BadWithExtensions message whose specialNumber extension is set, except that
+// the extension is an ordinary field.
+func withoutExtensions(t *testing.T) proto.Message {
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:    proto.String("without_extensions.proto"),
+		Package: proto.String("test"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("WithoutExtensions"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("text"), Number: proto.Int32(3), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+				{Name: proto.String("special_number"), Number: proto.Int32(123), Type: descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()},
+			},
+		}},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := dynamicpb.NewMessage(fd.Messages().Get(0))
+	m.Set(m.Descriptor().Fields().ByNumber(3), protoreflect.ValueOfString("Test"))
+	m.Set(m.Descriptor().Fields().ByNumber(123), protoreflect.ValueOfInt32(42))
+	return m
+}
+
+// TestExtensionResolver checks that set extensions are hashed like ordinary
+// fields when they are enabled.
+func TestExtensionResolver(t *testing.T) {
+	registry := new(protoregistry.Types)
+	if err := registry.RegisterExtension(specialNumber); err != nil {
+		t.Fatal(err)
+	}
+	hasher := NewHasher(ExtensionResolver(registry))
+
+	expected, err := NewHasher().HashProto(withoutExtensions(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := withSpecialNumber(t)
+	h, err := hasher.HashProto(protoV1.MessageV2(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h, expected) {
+		t.Errorf("Got the hash %x, expected the hash of the extension as an ordinary field: %x", h, expected)
+	}
+
+	// Extensions that were parsed without knowing about them are kept among the
+	// unknown fields, which get parsed using the resolver.
+	b, err := protoV1.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed := &pb2_latest.BadWithExtensions{}
+	if err := protoV1.Unmarshal(b, parsed); err != nil {
+		t.Fatal(err)
+	}
+	unknown := protoV1.MessageReflect(parsed).GetUnknown()
+	if len(unknown) == 0 {
+		t.Fatal("The extension was expected to be an unknown field.")
+	}
+
+	h, err = hasher.HashProto(protoV1.MessageV2(parsed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h, expected) {
+		t.Errorf("Got the hash %x for a parsed message, expected %x", h, expected)
+	}
+	if !bytes.Equal(protoV1.MessageReflect(parsed).GetUnknown(), unknown) {
+		t.Error("Hashing a message modified its unknown fields.")
+	}
+
+	// An empty message is fine too.
+	if _, err := hasher.HashProto(protoV1.MessageV2(&pb2_latest.BadWithExtensions{})); err != nil {
+		t.Errorf("Got an error when hashing an empty extendable message: %v", err)
+	}
+}
+
+// TestExtensionResolverWithNamesAsKeys checks that the full names of
+// extensions are used as their keys when field names (or JSON names) are used
+// as keys.
+func TestExtensionResolverWithNamesAsKeys(t *testing.T) {
+	registry := new(protoregistry.Types)
+	if err := registry.RegisterExtension(specialNumber); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		option   Option
+		expected []string
+	}{
+		{
+			option:   FieldNamesAsKeys(),
+			expected: []string{"(schema.proto2.special_number)=schema.proto2.special_number", "text=text"},
+		},
+		{
+			option:   JSONNamesAsKeys(),
+			expected: []string{"(schema.proto2.special_number)=[schema.proto2.special_number]", "text=text"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tree, err := HashProtoTree(NewHasher(ExtensionResolver(registry), tc.option), protoV1.MessageV2(withSpecialNumber(t)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var keys []string
+		for _, child := range tree.Children {
+			keys = append(keys, child.Path+"="+string(child.Key.Preimage))
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, tc.expected) {
+			t.Errorf("Got the fields %q with %v, expected %q", keys, tc.option, tc.expected)
+		}
+	}
+}
+
+// TestUnregisteredExtensions checks that extensions which cannot be resolved
+// result in errors, unlike unknown fields outside of the extension ranges.
+func TestUnregisteredExtensions(t *testing.T) {
+	hasher := NewHasher(ExtensionResolver(new(protoregistry.Types)))
+
+	withUnknownExtension := &pb2_latest.BadWithExtensions{}
+	protoV1.MessageReflect(withUnknownExtension).SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 150, protowire.VarintType), 1))
+
+	withUnknownField := &pb2_latest.BadWithExtensions{}
+	protoV1.MessageReflect(withUnknownField).SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 1000, protowire.VarintType), 1))
+
+	testCases := []struct {
+		message  protoV1.Message
+		path     string
+		expected error
+	}{
+		{message: withSpecialNumber(t), path: "(schema.proto2.special_number)", expected: ErrUnregisteredExtension},
+		{message: withUnknownExtension, expected: ErrUnregisteredExtension},
+		{message: withUnknownField, expected: ErrUnrecognizedFields},
+	}
+
+	for _, tc := range testCases {
+		_, err := hasher.HashProto(protoV1.MessageV2(tc.message))
+		var he *HashError
+		if !errors.As(err, &he) || !errors.Is(err, tc.expected) || he.Path != tc.path {
+			t.Errorf("Got the error %v when hashing %v, expected %v at %q", err, tc.message, tc.expected, tc.path)
+		}
+
+		verr := Validate(hasher, protoV1.MessageV2(tc.message))
+		if errs, ok := verr.(ValidationErrors); !ok || len(errs) != 1 || !errors.Is(errs[0], tc.expected) {
+			t.Errorf("Got the validation error %v for %v, expected %v", verr, tc.message, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// The field options defined in objecthash/options.proto.
+const (
+	repeatedOptionName protoreflect.FullName = "objecthash.repeated"
+	ignoreOptionName   protoreflect.FullName = "objecthash.ignore"
+
+	repeatedOptionNumber protowire.Number = 51200
+	ignoreOptionNumber   protowire.Number = 51201
+)
+
+// fieldOptionsFullName is the full name of the message extended by the field
+// options.
+const fieldOptionsFullName protoreflect.FullName = "google.protobuf.FieldOptions"
+
+// optionsFile is the descriptor of objecthash/options.proto.
+var optionsFile = buildOptionsFile()
+
+// buildOptionsFile builds the descriptor of objecthash/options.proto, which
+// must match the file's content.
+func buildOptionsFile() protoreflect.FileDescriptor {
+	option := func(name string, number protowire.Number, t descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+		fd := &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			Number:   proto.Int32(int32(number)),
+			Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:     t.Enum(),
+			Extendee: proto.String("." + string(fieldOptionsFullName)),
+		}
+		if typeName != "" {
+			fd.TypeName = proto.String(typeName)
+		}
+		return fd
+	}
+
+	enumValue := func(name string, number int32) *descriptorpb.EnumValueDescriptorProto {
+		return &descriptorpb.EnumValueDescriptorProto{Name: proto.String(name), Number: proto.Int32(number)}
+	}
+
+	f, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("objecthash/options.proto"),
+		Package:    proto.String("objecthash"),
+		Dependency: []string{"google/protobuf/descriptor.proto"},
+		Syntax:     proto.String("proto2"),
+		Options: &descriptorpb.FileOptions{
+			GoPackage: proto.String("github.com/deepmind/objecthash-proto;protohash"),
+		},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("RepeatedFieldHashing"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				enumValue("AS_LIST", int32(repeatedFieldAsList)),
+				enumValue("AS_SET", int32(repeatedFieldAsSet)),
+				enumValue("AS_MULTISET", int32(repeatedFieldAsMultiset)),
+			},
+		}},
+		Extension: []*descriptorpb.FieldDescriptorProto{
+			option(string(repeatedOptionName.Name()), repeatedOptionNumber, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".objecthash.RepeatedFieldHashing"),
+			option(string(ignoreOptionName.Name()), ignoreOptionNumber, descriptorpb.FieldDescriptorProto_TYPE_BOOL, ""),
+		},
+	}, protoregistry.GlobalFiles)
+	if err != nil {
+		panic(fmt.Sprintf("protohash: invalid descriptor of objecthash/options.proto: %v", err))
+	}
+	return f
+}
+
+// The options are registered with the proto library, which reserves their
+// numbers: registering other extensions of google.protobuf.FieldOptions with
+// the same numbers is a conflict. This package being the Go package of
+// objecthash/options.proto, this also lets the descriptors of generated
+// messages whose files import it get resolved.
+//
+// Options that are already registered (ex. by a package generated from
+// objecthash/options.proto regardless) are left as they are.
+func init() {
+	if _, err := protoregistry.GlobalFiles.FindFileByPath(optionsFile.Path()); err == protoregistry.NotFound {
+		if err := protoregistry.GlobalFiles.RegisterFile(optionsFile); err != nil {
+			panic(fmt.Sprintf("protohash: could not register objecthash/options.proto: %v", err))
+		}
+	}
+
+	extensions := optionsFile.Extensions()
+	for i := 0; i < extensions.Len(); i++ {
+		xd := extensions.Get(i)
+		if _, err := protoregistry.GlobalTypes.FindExtensionByName(xd.FullName()); err != protoregistry.NotFound {
+			continue
+		}
+		if err := protoregistry.GlobalTypes.RegisterExtension(dynamicpb.NewExtensionType(xd)); err != nil {
+			panic(fmt.Sprintf("protohash: could not register %s: %v", xd.FullName(), err))
+		}
+	}
+}
+
+// fieldAnnotations are the options of a field that are defined in
+// objecthash/options.proto.
+type fieldAnnotations struct {
+	repeated repeatedFieldMode
+
+	// Whether the field is hashed as if it was unset.
+	ignore bool
+}
+
+// cachedAnnotations are the annotations of a field descriptor.
+type cachedAnnotations struct {
+	fd          protoreflect.FieldDescriptor
+	annotations fieldAnnotations
+}
+
+// fieldAnnotationsCache caches the annotations of fields, since they are read
+// from the serialized options of the fields. It is keyed by the full names of
+// the fields, and only keeps the last descriptor of every field, so that it
+// does not grow with the descriptors built at runtime (ex. by HashWireBytes).
+var fieldAnnotationsCache sync.Map
+
+// annotationsOf returns the annotations of a field.
+//
+// The options are matched using the full names of the extensions they belong
+// to, as declared within the file of the field or within the files it imports.
+// Extensions of google.protobuf.FieldOptions defined elsewhere with the same
+// numbers are therefore never mistaken for them. The values are read from the
+// wire format of the field's options, so that they are found whether they
+// were parsed as extensions or as unknown fields.
+func annotationsOf(fd protoreflect.FieldDescriptor) fieldAnnotations {
+	if c, ok := fieldAnnotationsCache.Load(fd.FullName()); ok && c.(cachedAnnotations).fd == fd {
+		return c.(cachedAnnotations).annotations
+	}
+
+	var a fieldAnnotations
+	if opts, ok := fd.Options().(proto.Message); ok {
+		b, err := proto.Marshal(opts)
+		for err == nil && len(b) > 0 {
+			n, t, size := protowire.ConsumeField(b)
+			if size < 0 {
+				break
+			}
+			if t == protowire.VarintType {
+				v, _ := protowire.ConsumeVarint(b[protowire.SizeTag(n):])
+				switch fieldOptionName(fd.ParentFile(), n) {
+				case repeatedOptionName:
+					a.repeated = repeatedFieldMode(v)
+				case ignoreOptionName:
+					a.ignore = v != 0
+				}
+			}
+			b = b[size:]
+		}
+	}
+
+	fieldAnnotationsCache.Store(fd.FullName(), cachedAnnotations{fd: fd, annotations: a})
+	return a
+}
+
+// fieldOptionName returns the full name of the extension of
+// google.protobuf.FieldOptions with the given number that is declared within a
+// file or within the files it imports (recursively), or "" if there is none.
+func fieldOptionName(f protoreflect.FileDescriptor, n protowire.Number) protoreflect.FullName {
+	seen := make(map[string]bool)
+
+	var find func(f protoreflect.FileDescriptor) protoreflect.FullName
+	find = func(f protoreflect.FileDescriptor) protoreflect.FullName {
+		if f == nil || seen[f.Path()] {
+			return ""
+		}
+		seen[f.Path()] = true
+
+		if name := findFieldOption(f.Extensions(), f.Messages(), n); name != "" {
+			return name
+		}
+		imports := f.Imports()
+		for i := 0; i < imports.Len(); i++ {
+			if name := find(imports.Get(i).FileDescriptor); name != "" {
+				return name
+			}
+		}
+		return ""
+	}
+	return find(f)
+}
+
+// findFieldOption returns the full name of the extension of
+// google.protobuf.FieldOptions with the given number among some extensions,
+// or among the extensions declared within some messages (recursively).
+func findFieldOption(extensions protoreflect.ExtensionDescriptors, messages protoreflect.MessageDescriptors, n protowire.Number) protoreflect.FullName {
+	for i := 0; i < extensions.Len(); i++ {
+		xd := extensions.Get(i)
+		if xd.Number() == n && xd.ContainingMessage().FullName() == fieldOptionsFullName {
+			return xd.FullName()
+		}
+	}
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		if name := findFieldOption(md.Extensions(), md.Messages(), n); name != "" {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// fieldPathTree is a tree of field paths (ex. "a.b.c"), as used by
+// google.protobuf.FieldMask.
+//
+// Each node of the tree corresponds to a message field, and its children
+// correspond to the fields of that message. A leaf node means that the path
+// refers to the whole field, including all of its descendants.
+type fieldPathTree struct {
+	children map[protoreflect.Name]*fieldPathTree
+}
+
+// newFieldPathTree parses a set of field paths of a message.
+//
+// Every part of a path, except for the last one, must refer to a singular
+// message field, whose type is not a well-known type. Paths that are covered
+// by other paths (ex. "a.b" is covered by "a") are ignored.
+func newFieldPathTree(md protoreflect.MessageDescriptor, paths []string) (*fieldPathTree, error) {
+	root := &fieldPathTree{}
+	for _, path := range paths {
+		if err := root.add(md, path); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// add adds a field path to the tree.
+func (t *fieldPathTree) add(md protoreflect.MessageDescriptor, path string) error {
+	if path == "" {
+		return fmt.Errorf("invalid field path %q: it is empty", path)
+	}
+
+	node := t
+	names := strings.Split(path, ".")
+	var reason string
+	for i, name := range names {
+		if md == nil {
+			return fmt.Errorf("invalid field path %q: %s %s", path, strings.Join(names[:i], "."), reason)
+		}
+
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			return fmt.Errorf("invalid field path %q: %s does not have a field named %q", path, md.FullName(), name)
+		}
+
+		md = nil
+		switch {
+		case fd.IsList() || fd.IsMap():
+			reason = "is a repeated field, whose elements cannot be referred to"
+		case fd.Message() == nil:
+			reason = "is not a message field"
+		default:
+			if _, ok := CheckWellKnownType(fd.Message()); ok {
+				reason = "is a well-known type, whose fields cannot be referred to"
+			} else {
+				md = fd.Message()
+			}
+		}
+
+		if node.children == nil {
+			node.children = make(map[protoreflect.Name]*fieldPathTree)
+		}
+		child, ok := node.children[fd.Name()]
+		if ok && child.isLeaf() {
+			// The path is covered by a shorter path.
+			return nil
+		}
+		if !ok {
+			child = &fieldPathTree{}
+			node.children[fd.Name()] = child
+		}
+		node = child
+	}
+
+	// The whole field is covered by this path, so any longer paths are ignored.
+	node.children = nil
+	return nil
+}
+
+// isLeaf checks if the node refers to a whole field.
+func (t *fieldPathTree) isLeaf() bool {
+	return len(t.children) == 0
+}
+
+// child returns the node of a field, or nil if the field is not part of the
+// tree.
+func (t *fieldPathTree) child(fd protoreflect.FieldDescriptor) *fieldPathTree {
+	if fd.IsExtension() {
+		// Paths only refer to ordinary fields.
+		return nil
+	}
+	return t.children[fd.Name()]
+}
@@ -0,0 +1,729 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// FieldProof is a Merkle inclusion proof for a value within a message. It
+// contains the hashes needed for calculating the ObjectHash of the message
+// from the hash of the value, without the rest of the message.
+type FieldProof struct {
+	// The path of the value within the message (ex. "people[3].address.city").
+	Path string
+
+	// The ObjectHash of the value.
+	ValueHash []byte
+
+	// The steps for calculating the ObjectHash of the message from the hash of
+	// the value, starting from the innermost one.
+	Steps []FieldProofStep
+}
+
+// FieldProofStep is a step of a FieldProof, which calculates the hash of a
+// container (ie. a message, a map, a list or a set) given the hash of one of
+// its values.
+//
+// The hash of the container is calculated from its type identifier and the
+// concatenation of the hashes in Before, Key, the hash of the value and the
+// hashes in After.
+type FieldProofStep struct {
+	// The type identifier of the container.
+	TypeIdentifier string
+
+	// The hashes of the entries (ie. the hashes of their keys and values) or
+	// elements that precede the value within the container.
+	Before [][]byte
+
+	// The hash of the key of the value, which is nil for list and set elements.
+	Key []byte
+
+	// The hashes of the entries or elements that follow the value within the
+	// container.
+	After [][]byte
+}
+
+// pathElement is an element of the path of a FieldProof, which is either the
+// name of a field or the key of a list element or map entry.
+type pathElement struct {
+	name  string
+	key   string
+	isKey bool
+}
+
+func (e pathElement) String() string {
+	if e.isKey {
+		return fmt.Sprintf("[%s]", e.key)
+	}
+	return e.name
+}
+
+// parseProofPath parses the path of a FieldProof.
+//
+// Paths are made of field names separated by dots, where the elements of
+// repeated fields and the entries of maps are referred to using their index
+// or key within square brackets (ex. "people[3].address.city" or
+// `labels["name"]`). Map keys can optionally be quoted using Go syntax.
+func parseProofPath(path string) ([]pathElement, error) {
+	var elements []pathElement
+
+	rest := path
+	for rest != "" || len(elements) == 0 {
+		switch {
+		case len(elements) > 0 && rest[0] == '[':
+			var key string
+			if strings.HasPrefix(rest, `["`) {
+				quoted, err := strconv.QuotedPrefix(rest[1:])
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %q: bad quoted key: %v", path, err)
+				}
+				key, _ = strconv.Unquote(quoted)
+				rest = rest[1+len(quoted):]
+			} else {
+				end := strings.IndexByte(rest, ']')
+				if end < 0 {
+					return nil, fmt.Errorf("invalid path %q: missing ']'", path)
+				}
+				key = rest[1:end]
+				rest = rest[end:]
+			}
+			if !strings.HasPrefix(rest, "]") {
+				return nil, fmt.Errorf("invalid path %q: missing ']'", path)
+			}
+			rest = rest[1:]
+			elements = append(elements, pathElement{key: key, isKey: true})
+		default:
+			if len(elements) > 0 {
+				if rest[0] != '.' {
+					return nil, fmt.Errorf("invalid path %q: expected '.' or '[' before %q", path, rest)
+				}
+				rest = rest[1:]
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid path %q: missing field name", path)
+			}
+			elements = append(elements, pathElement{name: rest[:end]})
+			rest = rest[end:]
+		}
+	}
+	return elements, nil
+}
+
+// ProveField returns a Merkle inclusion proof for a value within a message,
+// which can be verified using VerifyFieldProof.
+//
+// The path of the value is made of field names separated by dots, where the
+// elements of repeated fields and the entries of maps are referred to using
+// their index or key within square brackets (ex. "people[3].address.city").
+// The fields of well-known types cannot be referred to. The proofs of the
+// elements of repeated fields hashed as sets (see RepeatedFieldsAsSets) only
+// show that the elements belong to the sets, whatever their index.
+//
+// The hasher must be one returned by NewHasher.
+func ProveField(hasher ProtoHasher, pb proto.Message, path string) (*FieldProof, error) {
+	oh, ok := hasher.(*objectHasher)
+	if !ok {
+		return nil, fmt.Errorf("field proofs are not supported by %T", hasher)
+	}
+	if pb == nil || !pb.ProtoReflect().IsValid() {
+		return nil, errors.New("cannot prove the fields of a nil message")
+	}
+
+	elements, err := parseProofPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure that the message can be hashed at all.
+	if _, err = oh.HashProto(pb); err != nil {
+		return nil, err
+	}
+	if pb, err = oh.withoutIgnoredFields(pb); err != nil {
+		return nil, err
+	}
+
+	proof := &FieldProof{Path: path}
+
+	// The current message, the current field, and whether the current value is
+	// an element (or map value) of the current field.
+	m := pb.ProtoReflect()
+	var fd protoreflect.FieldDescriptor
+	var v protoreflect.Value
+	var isElement bool
+
+	for i, e := range elements {
+		var step FieldProofStep
+		var valueHash []byte
+
+		switch {
+		case !e.isKey:
+			if m == nil {
+				return nil, fmt.Errorf("invalid path %q: %s is not a message", path, pathPrefix(elements[:i]))
+			}
+			if _, ok := CheckWellKnownType(m.Descriptor()); ok {
+				return nil, fmt.Errorf("invalid path %q: %s is a well-known type, whose fields cannot be referred to", path, pathPrefix(elements[:i]))
+			}
+
+			fd = m.Descriptor().Fields().ByName(protoreflect.Name(e.name))
+			if fd == nil {
+				return nil, fmt.Errorf("invalid path %q: %s does not have a field named %q", path, m.Descriptor().FullName(), e.name)
+			}
+			if oh.isUnset(m, fd) {
+				return nil, fmt.Errorf("invalid path %q: %s is unset", path, pathPrefix(elements[:i+1]))
+			}
+			if annotationsOf(fd).ignore {
+				return nil, fmt.Errorf("invalid path %q: %s is ignored", path, pathPrefix(elements[:i+1]))
+			}
+
+			entries, err := oh.structFieldEntries(m, nil)
+			if err != nil {
+				return nil, err
+			}
+			khash, err := oh.hashFieldKey(fd)
+			if err != nil {
+				return nil, err
+			}
+			step, valueHash, err = dictProofStep(oh.messageTypeIdentifier(), entries, khash)
+			if err != nil {
+				return nil, fmt.Errorf("could not prove %s: %v", pathPrefix(elements[:i+1]), err)
+			}
+
+			v = m.Get(fd)
+			isElement = false
+		case fd != nil && !isElement && fd.IsList():
+			list := v.List()
+			index, err := strconv.Atoi(e.key)
+			if err != nil || index < 0 || index >= list.Len() {
+				return nil, fmt.Errorf("invalid path %q: %s does not have an element with index %q", path, pathPrefix(elements[:i]), e.key)
+			}
+
+			hashes, err := oh.repeatedFieldHashes(fd, list)
+			if err != nil {
+				return nil, err
+			}
+			switch mode := oh.repeatedFieldMode(fd); mode {
+			case repeatedFieldAsSet, repeatedFieldAsMultiset:
+				step = setProofStep(hashes, hashes[index], mode == repeatedFieldAsSet)
+			default:
+				step = FieldProofStep{TypeIdentifier: listIdentifier, Before: hashes[:index], After: hashes[index+1:]}
+			}
+			valueHash = hashes[index]
+
+			v = list.Get(index)
+			isElement = true
+		case fd != nil && !isElement && fd.IsMap():
+			key, err := parseMapKey(fd.MapKey(), e.key)
+			if err != nil || !v.Map().Has(key) {
+				return nil, fmt.Errorf("invalid path %q: %s does not have an entry with key %q", path, pathPrefix(elements[:i]), e.key)
+			}
+
+			entries, err := oh.mapEntries(fd, v.Map())
+			if err != nil {
+				return nil, err
+			}
+			khash, err := oh.hashMapKey(fd.MapKey(), key)
+			if err != nil {
+				return nil, err
+			}
+			step, valueHash, err = dictProofStep(mapIdentifier, entries, khash)
+			if err != nil {
+				return nil, fmt.Errorf("could not prove %s: %v", pathPrefix(elements[:i+1]), err)
+			}
+
+			v = v.Map().Get(key)
+			isElement = true
+		default:
+			return nil, fmt.Errorf("invalid path %q: %s is not a repeated field or a map", path, pathPrefix(elements[:i]))
+		}
+
+		proof.Steps = append([]FieldProofStep{step}, proof.Steps...)
+		proof.ValueHash = valueHash
+
+		// Find out whether the value is a message whose fields can be referred to.
+		m = nil
+		switch {
+		case fd.IsMap():
+			if isElement && fd.MapValue().Message() != nil {
+				m = v.Message()
+			}
+		case fd.IsList():
+			if isElement && fd.Message() != nil {
+				m = v.Message()
+			}
+		case fd.Message() != nil:
+			m = v.Message()
+		}
+	}
+
+	return proof, nil
+}
+
+// pathPrefix returns the string representation of a prefix of a path.
+func pathPrefix(elements []pathElement) string {
+	b := new(strings.Builder)
+	for i, e := range elements {
+		if i > 0 && !e.isKey {
+			b.WriteString(".")
+		}
+		b.WriteString(e.String())
+	}
+	if b.Len() == 0 {
+		return "the message"
+	}
+	return b.String()
+}
+
+// parseMapKey parses the key of a map entry within a path.
+func parseMapKey(fd protoreflect.FieldDescriptor, s string) (protoreflect.MapKey, error) {
+	var v protoreflect.Value
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		v = protoreflect.ValueOfString(s)
+	case protoreflect.BoolKind:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return protoreflect.MapKey{}, err
+		}
+		v = protoreflect.ValueOfBool(b)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		i, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.MapKey{}, err
+		}
+		v = protoreflect.ValueOfInt32(int32(i))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return protoreflect.MapKey{}, err
+		}
+		v = protoreflect.ValueOfInt64(i)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		i, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return protoreflect.MapKey{}, err
+		}
+		v = protoreflect.ValueOfUint32(uint32(i))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		i, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return protoreflect.MapKey{}, err
+		}
+		v = protoreflect.ValueOfUint64(i)
+	default:
+		return protoreflect.MapKey{}, fmt.Errorf("unsupported map key kind: %v", fd.Kind())
+	}
+	return v.MapKey(), nil
+}
+
+// dictProofStep returns the proof step of the entry with the given key hash
+// within a dictionary, along with the hash of its value. It returns an error if
+// the dictionary does not have such an entry.
+func dictProofStep(t string, entries []hashEntry, khash []byte) (FieldProofStep, []byte, error) {
+	step := FieldProofStep{TypeIdentifier: t, Key: khash}
+
+	var vhash []byte
+	for _, e := range entries {
+		switch c := bytes.Compare(e.khash, khash); {
+		case c < 0:
+			step.Before = append(step.Before, e.khash, e.vhash)
+		case c > 0:
+			step.After = append(step.After, e.khash, e.vhash)
+		default:
+			vhash = e.vhash
+		}
+	}
+	if vhash == nil {
+		return FieldProofStep{}, nil, errors.New("its key is missing from the hashed entries")
+	}
+	return step, vhash, nil
+}
+
+// setProofStep returns the proof step of an element within a set (or a
+// multiset), given the hashes of all of its elements and the hash of the
+// element.
+func setProofStep(hashes [][]byte, vhash []byte, unique bool) FieldProofStep {
+	step := FieldProofStep{TypeIdentifier: setIdentifier}
+
+	found := false
+	for _, h := range sortedSetHashes(hashes, unique) {
+		switch c := bytes.Compare(h, vhash); {
+		case c < 0:
+			step.Before = append(step.Before, h)
+		case c == 0 && !found:
+			found = true
+		default:
+			step.After = append(step.After, h)
+		}
+	}
+	return step
+}
+
+// VerifyFieldProof checks that a value is part of a message of the type
+// described by md, given the message's ObjectHash (root) and a proof returned
+// by ProveField.
+//
+// The value is hashed like the values of the field that the path refers to,
+// and it can be a bool, an integer, a float, a string, a []byte or a proto
+// message, depending on the kind of the field. Enum values can be given as
+// numbers or as names. A nil value means that the proof's ValueHash should be
+// checked as is, which can be used for whole repeated fields and maps.
+//
+// The path of the proof is resolved using the message descriptor, and every
+// step of the proof must use the key of the field (ie. its tag number or its
+// name) or of the map entry that the path refers to. This ensures that the
+// proof of a field cannot pass for that of another field with the same value.
+//
+// The hasher must be one returned by NewHasher, with the same options as the
+// one used for creating the proof.
+func VerifyFieldProof(hasher ProtoHasher, md protoreflect.MessageDescriptor, root []byte, proof *FieldProof, value interface{}) error {
+	oh, ok := hasher.(*objectHasher)
+	if !ok {
+		return fmt.Errorf("field proofs are not supported by %T", hasher)
+	}
+	if proof == nil {
+		return errors.New("got a nil field proof")
+	}
+
+	elements, err := parseProofPath(proof.Path)
+	if err != nil {
+		return err
+	}
+	if len(elements) != len(proof.Steps) {
+		return fmt.Errorf("the field proof of %q has %d steps, instead of %d", proof.Path, len(proof.Steps), len(elements))
+	}
+	fds, err := resolveProofPath(md, elements)
+	if err != nil {
+		return err
+	}
+
+	// Every hash must have the size of the digests of the hasher, otherwise
+	// the boundaries between the hashes of a step could be moved around.
+	size := oh.newHash().Size()
+	if len(proof.ValueHash) != size {
+		return fmt.Errorf("the field proof of %q has a value hash of %d bytes, instead of %d", proof.Path, len(proof.ValueHash), size)
+	}
+
+	if value != nil {
+		last := len(elements) - 1
+		h, err := oh.hashProofValue(fds[last], elements[last].isKey, value)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(h, proof.ValueHash) {
+			return fmt.Errorf("the value does not match the field proof of %q", proof.Path)
+		}
+	}
+
+	h := proof.ValueHash
+	for i, step := range proof.Steps {
+		j := len(elements) - 1 - i
+		if err := oh.checkProofStep(step, elements[j], fds[j], h, size); err != nil {
+			return fmt.Errorf("the field proof of %q is invalid at %s: %v", proof.Path, pathPrefix(elements[:len(elements)-i]), err)
+		}
+
+		b := new(bytes.Buffer)
+		for _, sibling := range step.Before {
+			b.Write(sibling)
+		}
+		b.Write(step.Key)
+		b.Write(h)
+		for _, sibling := range step.After {
+			b.Write(sibling)
+		}
+
+		if h, err = oh.digest(step.TypeIdentifier, b.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if !bytes.Equal(h, root) {
+		return fmt.Errorf("the field proof of %q does not match the root hash", proof.Path)
+	}
+	return nil
+}
+
+// resolveProofPath returns the fields that the elements of the path of a
+// FieldProof refer to, given the descriptor of the message. The elements of
+// repeated fields and the entries of maps refer to their field.
+func resolveProofPath(md protoreflect.MessageDescriptor, elements []pathElement) ([]protoreflect.FieldDescriptor, error) {
+	fds := make([]protoreflect.FieldDescriptor, len(elements))
+
+	var fd protoreflect.FieldDescriptor
+	for i, e := range elements {
+		switch {
+		case !e.isKey:
+			if md == nil {
+				return nil, fmt.Errorf("invalid path: %s is not a message", pathPrefix(elements[:i]))
+			}
+			if _, ok := CheckWellKnownType(md); ok {
+				return nil, fmt.Errorf("invalid path: %s is a well-known type, whose fields cannot be referred to", pathPrefix(elements[:i]))
+			}
+			fd = md.Fields().ByName(protoreflect.Name(e.name))
+			if fd == nil {
+				return nil, fmt.Errorf("invalid path: %s does not have a field named %q", md.FullName(), e.name)
+			}
+		case i > 0 && !elements[i-1].isKey && (fd.IsList() || fd.IsMap()):
+		default:
+			return nil, fmt.Errorf("invalid path: %s is not a repeated field or a map", pathPrefix(elements[:i]))
+		}
+		fds[i] = fd
+
+		// Find out whether the value is a message whose fields can be referred to.
+		switch {
+		case fd.IsMap():
+			md = nil
+			if e.isKey {
+				md = fd.MapValue().Message()
+			}
+		case fd.IsList():
+			md = nil
+			if e.isKey {
+				md = fd.Message()
+			}
+		default:
+			md = fd.Message()
+		}
+	}
+	return fds, nil
+}
+
+// checkProofStep checks that a proof step is consistent with the path element
+// it corresponds to, and with the hash of the value within the container. The
+// field is the one that the path element refers to (see resolveProofPath), and
+// every hash of the step must have the given size.
+func (hasher *objectHasher) checkProofStep(step FieldProofStep, e pathElement, fd protoreflect.FieldDescriptor, vhash []byte, size int) error {
+	for _, siblings := range [][][]byte{step.Before, step.After} {
+		for _, h := range siblings {
+			if len(h) != size {
+				return fmt.Errorf("got a hash of %d bytes instead of %d", len(h), size)
+			}
+		}
+	}
+	if step.Key != nil && len(step.Key) != size {
+		return fmt.Errorf("got a key hash of %d bytes instead of %d", len(step.Key), size)
+	}
+
+	if e.isKey && fd.IsList() {
+		if step.Key != nil {
+			return errors.New("got a dictionary entry instead of an element")
+		}
+
+		switch mode := hasher.repeatedFieldMode(fd); mode {
+		case repeatedFieldAsSet, repeatedFieldAsMultiset:
+			if step.TypeIdentifier != setIdentifier {
+				return fmt.Errorf("got the type identifier %q instead of that of a set", step.TypeIdentifier)
+			}
+
+			// The elements of sets must be sorted by their hashes (without
+			// duplicates, unless they're multisets), so the index of the element
+			// in the path cannot be checked.
+			hashes := append(append(append([][]byte{}, step.Before...), vhash), step.After...)
+			if !isSorted(hashes, mode == repeatedFieldAsSet) {
+				return errors.New("got an unsorted set")
+			}
+		default:
+			if step.TypeIdentifier != listIdentifier {
+				return fmt.Errorf("got the type identifier %q instead of that of a list", step.TypeIdentifier)
+			}
+			if index, err := strconv.Atoi(e.key); err != nil || index != len(step.Before) {
+				return fmt.Errorf("got the element with index %d instead of %q", len(step.Before), e.key)
+			}
+		}
+		return nil
+	}
+
+	if step.Key == nil || len(step.Before)%2 != 0 || len(step.After)%2 != 0 {
+		return errors.New("got a malformed dictionary")
+	}
+
+	// Dictionaries must be sorted by the hashes of their keys, which are
+	// unique.
+	var keys [][]byte
+	for j := 0; j < len(step.Before); j += 2 {
+		keys = append(keys, step.Before[j])
+	}
+	keys = append(keys, step.Key)
+	for j := 0; j < len(step.After); j += 2 {
+		keys = append(keys, step.After[j])
+	}
+	if !isSorted(keys, true) {
+		return errors.New("got an unsorted dictionary")
+	}
+
+	if e.isKey {
+		if step.TypeIdentifier != mapIdentifier {
+			return fmt.Errorf("got the type identifier %q instead of that of a map", step.TypeIdentifier)
+		}
+		key, err := parseMapKey(fd.MapKey(), e.key)
+		if err != nil {
+			return fmt.Errorf("got an invalid key %q: %v", e.key, err)
+		}
+		khash, err := hasher.hashMapKey(fd.MapKey(), key)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(khash, step.Key) {
+			return fmt.Errorf("got the wrong key hash for key %q", e.key)
+		}
+		return nil
+	}
+
+	if step.TypeIdentifier != hasher.messageTypeIdentifier() {
+		return fmt.Errorf("got the type identifier %q instead of that of a message", step.TypeIdentifier)
+	}
+	khash, err := hasher.hashFieldKey(fd)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(khash, step.Key) {
+		return fmt.Errorf("got the wrong key hash for field %q", e.name)
+	}
+	return nil
+}
+
+// isSorted checks that hashes are sorted, and that they're unique if strict is
+// true.
+func isSorted(hashes [][]byte, strict bool) bool {
+	for j := 1; j < len(hashes); j++ {
+		if c := bytes.Compare(hashes[j-1], hashes[j]); c > 0 || (c == 0 && strict) {
+			return false
+		}
+	}
+	return true
+}
+
+// hashProofValue returns the hash of a Go value, which is hashed like the
+// value of a field (or like an element of a repeated field or map, if
+// isElement is true).
+func (hasher *objectHasher) hashProofValue(fd protoreflect.FieldDescriptor, isElement bool, value interface{}) ([]byte, error) {
+	if (fd.IsList() || fd.IsMap()) && !isElement {
+		return nil, fmt.Errorf("the value of the repeated field or map %s can only be checked using the hash of the proof", fd.FullName())
+	}
+	if fd.IsMap() {
+		fd = fd.MapValue()
+	}
+
+	v, err := protoValueOf(fd, value)
+	if err != nil {
+		return nil, err
+	}
+	return hasher.hashValue(fd, v)
+}
+
+// protoValueOf converts a Go value into a value of the kind of a field. Any Go
+// integer can be converted, as long as it is within the range of the field.
+func protoValueOf(fd protoreflect.FieldDescriptor, value interface{}) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if b, ok := value.(bool); ok {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case protoreflect.EnumKind:
+		if name, ok := value.(string); ok {
+			ev := fd.Enum().Values().ByName(protoreflect.Name(name))
+			if ev == nil {
+				return protoreflect.Value{}, fmt.Errorf("%s does not have a value named %q", fd.Enum().FullName(), name)
+			}
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		if i, ok := goInt(value, math.MinInt32, math.MaxInt32); ok {
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(i)), nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if i, ok := goInt(value, math.MinInt32, math.MaxInt32); ok {
+			return protoreflect.ValueOfInt32(int32(i)), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if i, ok := goInt(value, math.MinInt64, math.MaxInt64); ok {
+			return protoreflect.ValueOfInt64(i), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if u, ok := goUint(value, math.MaxUint32); ok {
+			return protoreflect.ValueOfUint32(uint32(u)), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if u, ok := goUint(value, math.MaxUint64); ok {
+			return protoreflect.ValueOfUint64(u), nil
+		}
+	case protoreflect.FloatKind:
+		switch f := value.(type) {
+		case float32:
+			return protoreflect.ValueOfFloat32(f), nil
+		case float64:
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+	case protoreflect.DoubleKind:
+		switch f := value.(type) {
+		case float32:
+			return protoreflect.ValueOfFloat64(float64(f)), nil
+		case float64:
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+	case protoreflect.StringKind:
+		if str, ok := value.(string); ok {
+			return protoreflect.ValueOfString(str), nil
+		}
+	case protoreflect.BytesKind:
+		if b, ok := value.([]byte); ok {
+			return protoreflect.ValueOfBytes(b), nil
+		}
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if pb, ok := value.(proto.Message); ok && pb.ProtoReflect().Descriptor().FullName() == fd.Message().FullName() {
+			return protoreflect.ValueOfMessage(pb.ProtoReflect()), nil
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("a value of type %T cannot be the value of %s, a %v field", value, fd.FullName(), fd.Kind())
+}
+
+// goInt returns the value of a Go integer, if it is within the given range.
+func goInt(value interface{}, min, max int64) (int64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), v.Int() >= min && v.Int() <= max
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint()), v.Uint() <= uint64(max)
+	default:
+		return 0, false
+	}
+}
+
+// goUint returns the value of a non-negative Go integer, if it is not above the
+// given maximum.
+func goUint(value interface{}, max uint64) (uint64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int()), v.Int() >= 0 && uint64(v.Int()) <= max
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint(), v.Uint() <= max
+	default:
+		return 0, false
+	}
+}
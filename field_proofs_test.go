@@ -0,0 +1,316 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	timestamp_pb "github.com/golang/protobuf/ptypes/timestamp"
+	"google.golang.org/protobuf/proto"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// TestFieldProofs checks that field proofs can be verified.
+func TestFieldProofs(t *testing.T) {
+	hashers := []ProtoHasher{
+		NewHasher(),
+		NewHasher(FieldNamesAsKeys(), EnumsAsStrings()),
+		NewHasher(MessageIdentifier(`m`)),
+		NewHasher(HMACKey([]byte("secret"))),
+		NewHasher(JSONNamesAsKeys()),
+		NewHasher(ProtoJSONCompatible()),
+	}
+
+	family := &pb3_latest.PersonV2{
+		Id:   1,
+		Name: "Alice",
+		Children: []*pb3_latest.PersonV2{
+			{Id: 2, Name: "Bob"},
+			{Id: 3, Name: "Carol", Children: []*pb3_latest.PersonV2{{Name: "Dave", Age: 1}}},
+		},
+	}
+	stringMaps := &pb3_latest.StringMaps{
+		StringToString: map[string]string{"foo": "bar", "baz": "qux", "a]b": "c"},
+		StringToSimple: map[string]*pb3_latest.Simple{"foo": {StringField: "bar"}, "": {BoolField: true}},
+	}
+	intMaps := &pb3_latest.IntMaps{
+		IntToString: map[int64]string{-1: "foo", 2: "bar"},
+		IntToInt64:  map[int64]int64{5: -5},
+		IntToUint64: map[int64]uint64{6: 6},
+	}
+	boolMaps := &pb3_latest.BoolMaps{BoolToString: map[bool]string{true: "yes", false: "no"}, BoolToInt32: map[bool]int32{true: 1}}
+	simple := &pb3_latest.Simple{Int64Field: 5, FloatField: 0.1, Uint64Field: 7, BytesField: []byte("foo")}
+	knownTypes := &pb3_latest.KnownTypes{TimestampField: &timestamp_pb.Timestamp{Seconds: 1}}
+
+	testCases := []struct {
+		message protoV1.Message
+		path    string
+		value   interface{}
+	}{
+		{message: family, path: "id", value: int32(1)},
+		{message: family, path: "name", value: "Alice"},
+		{message: family, path: "children[0]", value: protoV1.MessageV2(family.Children[0])},
+		{message: family, path: "children[1].name", value: "Carol"},
+		{message: family, path: "children[1].children[0].age", value: uint32(1)},
+		{message: family, path: "children", value: nil},
+		{message: stringMaps, path: "string_to_string[foo]", value: "bar"},
+		{message: stringMaps, path: `string_to_string["a]b"]`, value: "c"},
+		{message: stringMaps, path: `string_to_simple[""].bool_field`, value: true},
+		{message: stringMaps, path: "string_to_simple[foo].string_field", value: "bar"},
+		{message: intMaps, path: "int_to_string[-1]", value: "foo"},
+		{message: intMaps, path: "int_to_int64[5]", value: int64(-5)},
+		{message: intMaps, path: "int_to_uint64[6]", value: 6},
+		{message: boolMaps, path: "bool_to_string[true]", value: "yes"},
+		{message: boolMaps, path: "bool_to_string[false]", value: "no"},
+		{message: boolMaps, path: "bool_to_int32[true]", value: int32(1)},
+		{message: simple, path: "int64_field", value: int64(5)},
+		{message: simple, path: "float_field", value: float32(0.1)},
+		{message: simple, path: "uint64_field", value: uint64(7)},
+		{message: simple, path: "bytes_field", value: []byte("foo")},
+		{message: knownTypes, path: "timestamp_field", value: protoV1.MessageV2(knownTypes.TimestampField)},
+	}
+
+	for _, hasher := range hashers {
+		for _, tc := range testCases {
+			root, err := hasher.HashProto(protoV1.MessageV2(tc.message))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			proof, err := ProveField(hasher, protoV1.MessageV2(tc.message), tc.path)
+			if err != nil {
+				t.Fatalf("Proving %q of %v returned an error: %v", tc.path, tc.message, err)
+			}
+
+			md := protoV1.MessageV2(tc.message).ProtoReflect().Descriptor()
+			if err = VerifyFieldProof(hasher, md, root, proof, tc.value); err != nil {
+				t.Errorf("Verifying the proof of %q of %v returned an error: %v", tc.path, tc.message, err)
+			}
+
+			// The proof must not be valid for other roots.
+			if err = VerifyFieldProof(hasher, md, root[1:], proof, tc.value); err == nil {
+				t.Errorf("Verifying the proof of %q of %v with the wrong root hash should have returned an error.", tc.path, tc.message)
+			}
+		}
+	}
+}
+
+// TestFieldProofsWithBadInputs tests how ProveField and VerifyFieldProof
+// handle bad inputs.
+func TestFieldProofsWithBadInputs(t *testing.T) {
+	hasher := NewHasher(FieldNamesAsKeys())
+
+	family := protoV1.MessageV2(&pb3_latest.PersonV2{
+		Id:       1,
+		Name:     "Alice",
+		Children: []*pb3_latest.PersonV2{{Id: 2, Name: "Bob"}},
+	})
+	knownTypes := protoV1.MessageV2(&pb3_latest.KnownTypes{TimestampField: &timestamp_pb.Timestamp{Seconds: 1}})
+
+	badPaths := []struct {
+		message proto.Message
+		path    string
+	}{
+		{message: family, path: ""},
+		{message: family, path: "[0]"},
+		{message: family, path: "unknown"},
+		{message: family, path: "age"},
+		{message: family, path: "name.foo"},
+		{message: family, path: "name[0]"},
+		{message: family, path: "children.name"},
+		{message: family, path: "children[1]"},
+		{message: family, path: "children[-1]"},
+		{message: family, path: "children[0][0]"},
+		{message: family, path: "children[0"},
+		{message: family, path: "children[0]name"},
+		{message: family, path: "children..name"},
+		{message: knownTypes, path: "timestamp_field.seconds"},
+		{message: nil, path: "id"},
+	}
+	for _, tc := range badPaths {
+		if _, err := ProveField(hasher, tc.message, tc.path); err == nil {
+			t.Errorf("Proving %q should have returned an error.", tc.path)
+		}
+	}
+
+	root, err := hasher.HashProto(family)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := ProveField(hasher, family, "children[0].name")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	md := family.ProtoReflect().Descriptor()
+	if err = VerifyFieldProof(hasher, md, root, proof, "Carol"); err == nil {
+		t.Error("Verifying a proof with the wrong value should have returned an error.")
+	}
+	if err = VerifyFieldProof(hasher, md, root, proof, struct{}{}); err == nil {
+		t.Error("Verifying a proof with a value of an unsupported type should have returned an error.")
+	}
+	if err = VerifyFieldProof(hasher, md, root, proof, 5); err == nil {
+		t.Error("Verifying a proof with a value of the wrong type should have returned an error.")
+	}
+	if err = VerifyFieldProof(NewHasher(FieldNamesAsKeys(), HMACKey(nil)), md, root, proof, "Bob"); err == nil {
+		t.Error("Verifying a proof with the wrong hasher should have returned an error.")
+	}
+
+	// Entries that are missing from the hashed entries cannot be proven.
+	if _, _, err := dictProofStep(mapIdentifier, nil, []byte("key")); err == nil {
+		t.Error("Proving a missing dictionary entry should have returned an error.")
+	}
+
+	// Proofs which do not match their paths.
+	badPathProofs := []string{"children[0].id", "children[1].name", "id[0].name", "children[0]", "children[0].name.foo"}
+	for _, path := range badPathProofs {
+		badProof := *proof
+		badProof.Path = path
+		if err = VerifyFieldProof(hasher, md, root, &badProof, nil); err == nil {
+			t.Errorf("Verifying a proof of %q with the path %q should have returned an error.", proof.Path, path)
+		}
+	}
+}
+
+// TestFieldProofsOfOtherFields checks that the proof of a field cannot be used
+// as the proof of another field with the same value.
+func TestFieldProofsOfOtherFields(t *testing.T) {
+	person := protoV1.MessageV2(&pb3_latest.PersonV2{Id: 1, Name: "Alice", Age: 1, Profession: "Alice"})
+	md := person.ProtoReflect().Descriptor()
+
+	testCases := []struct {
+		path, otherPath string
+		value           interface{}
+	}{
+		{path: "profession", otherPath: "name", value: "Alice"},
+		{path: "age", otherPath: "id", value: uint32(1)},
+	}
+
+	for _, hasher := range []ProtoHasher{NewHasher(), NewHasher(FieldNamesAsKeys())} {
+		root, err := hasher.HashProto(person)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tc := range testCases {
+			proof, err := ProveField(hasher, person, tc.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := VerifyFieldProof(hasher, md, root, proof, tc.value); err != nil {
+				t.Errorf("Verifying the proof of %q returned an error: %v", tc.path, err)
+			}
+
+			otherProof := *proof
+			otherProof.Path = tc.otherPath
+			if err := VerifyFieldProof(hasher, md, root, &otherProof, tc.value); err == nil {
+				t.Errorf("The proof of %q should not be valid for %q.", tc.path, tc.otherPath)
+			}
+		}
+	}
+}
+
+// TestForgedFieldProofs checks that proofs whose hashes are rearranged do not
+// verify, even though the concatenation of their hashes is unchanged.
+func TestForgedFieldProofs(t *testing.T) {
+	hasher := NewHasher()
+
+	// The key hash of int32_field (whose tag number is 13) is also the hash
+	// of the value of int64_field.
+	simple := protoV1.MessageV2(&pb3_latest.Simple{Int32Field: 18, Int64Field: 13})
+	md := simple.ProtoReflect().Descriptor()
+	root, err := hasher.HashProto(simple)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := ProveField(hasher, simple, "int32_field")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFieldProof(hasher, md, root, proof, 18); err != nil {
+		t.Fatalf("Could not verify the proof of int32_field: %v", err)
+	}
+	step := proof.Steps[0]
+
+	h13, err := hasher.(*objectHasher).hashInt64(13)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := bytes.Join(append(append(append([][]byte{}, step.Before...), step.Key, proof.ValueHash), step.After...), nil)
+	i := bytes.Index(b, append(append([]byte{}, h13...), h13...))
+	if i < 0 {
+		t.Fatal("The hashes of the entries of the message are not in the expected order.")
+	}
+	before, after := b[:i], b[i+2*len(h13):]
+
+	split := func(hashes [][]byte) [][]byte {
+		var halves [][]byte
+		for _, h := range hashes {
+			halves = append(halves, h[:len(h)/2], h[len(h)/2:])
+		}
+		return halves
+	}
+
+	forgeries := []struct {
+		description string
+		valueHash   []byte
+		step        FieldProofStep
+		value       interface{}
+	}{
+		{
+			description: "truncated value hash",
+			valueHash:   proof.ValueHash[:16],
+			step:        step,
+		},
+		{
+			description: "truncated sibling",
+			valueHash:   proof.ValueHash,
+			step:        FieldProofStep{TypeIdentifier: step.TypeIdentifier, Before: [][]byte{step.Before[0][:16], step.Before[1]}, Key: step.Key, After: step.After},
+		},
+		{
+			description: "split siblings",
+			valueHash:   proof.ValueHash,
+			step:        FieldProofStep{TypeIdentifier: step.TypeIdentifier, Before: split(step.Before), Key: step.Key, After: split(step.After)},
+			value:       18,
+		},
+		{
+			description: "swapped keys and values, with an empty sibling",
+			valueHash:   h13,
+			step:        FieldProofStep{TypeIdentifier: step.TypeIdentifier, Before: [][]byte{{}, before}, Key: h13, After: [][]byte{after[:1], after[1:]}},
+			value:       13,
+		},
+		{
+			description: "swapped keys and values, with split siblings",
+			valueHash:   h13,
+			step:        FieldProofStep{TypeIdentifier: step.TypeIdentifier, Before: split([][]byte{before}), Key: h13, After: split([][]byte{after})},
+			value:       13,
+		},
+		{
+			description: "duplicate key",
+			valueHash:   proof.ValueHash,
+			step:        FieldProofStep{TypeIdentifier: step.TypeIdentifier, Before: [][]byte{step.Key, step.Before[1]}, Key: step.Key, After: step.After},
+		},
+	}
+
+	for _, f := range forgeries {
+		forged := &FieldProof{Path: proof.Path, ValueHash: f.valueHash, Steps: []FieldProofStep{f.step}}
+		if err := VerifyFieldProof(hasher, md, root, forged, f.value); err == nil {
+			t.Errorf("Verifying a forged proof (%s) should have returned an error.", f.description)
+		}
+	}
+}
@@ -17,32 +17,55 @@ package protohash
 import (
 	"testing"
 
+	protoV1 "github.com/golang/protobuf/proto"
+
 	oi "github.com/deepmind/objecthash-proto/internal"
 	"github.com/deepmind/objecthash-proto/tests"
 	wkt "github.com/deepmind/objecthash-proto/tests/well_known_types"
 )
 
+// v1Hasher adapts a ProtoHasher to the messages used by the functional tests,
+// which are generated by an older version of protoc-gen-go.
+type v1Hasher struct {
+	hasher ProtoHasher
+}
+
+func (h v1Hasher) HashProto(pb protoV1.Message) ([]byte, error) {
+	return h.hasher.HashProto(protoV1.MessageV2(pb))
+}
+
 func TestFunctional(t *testing.T) {
 	protoHashers := oi.ProtoHashers{
-		DefaultHasher:                 NewHasher(),
-		FieldNamesAsKeysHasher:        NewHasher(FieldNamesAsKeys()),
-		EnumsAsStringsHasher:          NewHasher(EnumsAsStrings()),
-		StringPreferringHasher:        NewHasher(FieldNamesAsKeys(), EnumsAsStrings()),
-		CustomMessageIdentifierHasher: NewHasher(MessageIdentifier(`m`)),
+		DefaultHasher:                 v1Hasher{NewHasher()},
+		FieldNamesAsKeysHasher:        v1Hasher{NewHasher(FieldNamesAsKeys())},
+		JSONNamesAsKeysHasher:         v1Hasher{NewHasher(JSONNamesAsKeys())},
+		ProtoJSONCompatibleHasher:     v1Hasher{NewHasher(ProtoJSONCompatible())},
+		EnumsAsStringsHasher:          v1Hasher{NewHasher(EnumsAsStrings())},
+		StringPreferringHasher:        v1Hasher{NewHasher(FieldNamesAsKeys(), EnumsAsStrings())},
+		CustomMessageIdentifierHasher: v1Hasher{NewHasher(MessageIdentifier(`m`))},
+		AnyAsEmbeddedMessageHasher:    v1Hasher{NewHasher(FieldNamesAsKeys(), AnyResolver(nil))},
+		AnyAsTypeURLAndBytesHasher:    v1Hasher{NewHasher(FieldNamesAsKeys(), AnyAsTypeURLAndBytes())},
 	}
 
 	t.Run("TestBadness", func(t *testing.T) { tests.TestBadness(t, protoHashers) })
 	t.Run("TestEmptyFields", func(t *testing.T) { tests.TestEmptyFields(t, protoHashers) })
 	t.Run("TestFloatFields", func(t *testing.T) { tests.TestFloatFields(t, protoHashers) })
 	t.Run("TestIntegerFields", func(t *testing.T) { tests.TestIntegerFields(t, protoHashers) })
+	t.Run("TestJSONNames", func(t *testing.T) { tests.TestJSONNames(t, protoHashers) })
 	t.Run("TestMaps", func(t *testing.T) { tests.TestMaps(t, protoHashers) })
 	t.Run("TestOneOfFields", func(t *testing.T) { tests.TestOneOfFields(t, protoHashers) })
 	t.Run("TestOtherTypes", func(t *testing.T) { tests.TestOtherTypes(t, protoHashers) })
+	t.Run("TestProtoJSONCompatible", func(t *testing.T) { tests.TestProtoJSONCompatible(t, protoHashers) })
 	t.Run("TestProto2DefaultFieldValues", func(t *testing.T) { tests.TestProto2DefaultFieldValues(t, protoHashers) })
 	t.Run("TestRepeatedFields", func(t *testing.T) { tests.TestRepeatedFields(t, protoHashers) })
 	t.Run("TestStringFields", func(t *testing.T) { tests.TestStringFields(t, protoHashers) })
 
 	// Well-known types.
+	t.Run("TestAnyAsEmbeddedMessage", func(t *testing.T) { wkt.TestAnyAsEmbeddedMessage(t, protoHashers) })
+	t.Run("TestAnyAsTypeURLAndBytes", func(t *testing.T) { wkt.TestAnyAsTypeURLAndBytes(t, protoHashers) })
+	t.Run("TestDurations", func(t *testing.T) { wkt.TestDurations(t, protoHashers) })
+	t.Run("TestStructs", func(t *testing.T) { wkt.TestStructs(t, protoHashers) })
 	t.Run("TestTimestamps", func(t *testing.T) { wkt.TestTimestamps(t, protoHashers) })
+	t.Run("TestWrappers", func(t *testing.T) { wkt.TestWrappers(t, protoHashers) })
 	t.Run("TestUnsupportedWellKnownTypes", func(t *testing.T) { wkt.TestUnsupportedWellKnownTypes(t, protoHashers) })
 }
@@ -0,0 +1,290 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// HashNode is a node of a hash tree, which explains how the ObjectHash of a
+// value was calculated (see HashProtoTree).
+//
+// The ObjectHash of every node is the hash of its type identifier followed by
+// its preimage. For lists, sets and dictionaries, the preimage is the
+// concatenation of the hashes of their children (and of the keys of their
+// entries).
+type HashNode struct {
+	// The path of the value within the message (ex. "people[3].address.city"),
+	// which is empty for the message itself. The keys of dictionary entries
+	// have the same path as their values.
+	Path string
+
+	// The type identifier of the value (ex. "d" for dictionaries, "u" for
+	// unicode strings or "f" for floats).
+	TypeIdentifier string
+
+	// The normalized representation of the value (ex. "+1:011" for the float
+	// 1.5), which gets hashed after the type identifier.
+	Preimage []byte
+
+	// The ObjectHash of the value.
+	Hash []byte
+
+	// The node of the key of the value, if the value is an entry of a
+	// dictionary (ie. a message field or a map value).
+	Key *HashNode
+
+	// The nodes of the elements of a list or a set, or of the values of the
+	// entries of a dictionary, in the order in which their hashes appear in the
+	// preimage (so the elements of sets are sorted by their hashes).
+	Children []*HashNode
+
+	// Whether the value is a list, a set or a dictionary.
+	container bool
+}
+
+// HashProtoTree returns the hash tree of a protocol buffer message, whose root
+// node contains the ObjectHash of the message (ie. the same hash returned by
+// the HashProto method of the hasher).
+//
+// The tree contains every intermediate hash calculated by the hasher, along
+// with what got hashed, which makes it possible to find where two
+// implementations of ObjectHash diverge.
+//
+// The hasher must be one returned by NewHasher.
+func HashProtoTree(hasher ProtoHasher, pb proto.Message) (*HashNode, error) {
+	oh, ok := hasher.(*objectHasher)
+	if !ok {
+		return nil, fmt.Errorf("hash trees are not supported by %T", hasher)
+	}
+
+	tracer := &hashTracer{}
+	traced := *oh
+	traced.tracer = tracer
+
+	h, err := traced.HashProto(pb)
+	if err != nil {
+		return nil, err
+	}
+	if tracer.err == nil && (len(tracer.pending) != 1 || !bytes.Equal(tracer.pending[0].Hash, h)) {
+		tracer.err = errors.New("the hash tree does not match the hash of the message")
+	}
+	if tracer.err != nil {
+		return nil, tracer.err
+	}
+
+	root := tracer.pending[0]
+	root.setPaths("")
+	return root, nil
+}
+
+// setPaths turns the labels stored in the paths of the node and its
+// descendants into full paths, given the path of the node's parent.
+func (n *HashNode) setPaths(parent string) {
+	n.Path = joinPath(parent, n.Path)
+
+	if n.Key != nil {
+		n.Key.Path = n.Path
+	}
+	for _, c := range n.Children {
+		c.setPaths(n.Path)
+	}
+}
+
+// String returns a human-readable dump of the tree rooted at the node, with
+// one line per node.
+//
+// Every line contains the path of the node, its type identifier, its preimage
+// and its hash (in hex). The keys of dictionary entries are listed right
+// before their values, so the hashes appear in the same order as in the
+// preimage of their parent.
+func (n *HashNode) String() string {
+	b := new(strings.Builder)
+	n.dump(b, 0)
+	return b.String()
+}
+
+func (n *HashNode) dump(b *strings.Builder, depth int) {
+	indent := strings.Repeat("  ", depth)
+	name := n.Path
+	if name == "" {
+		name = "(root)"
+	}
+
+	if n.Key != nil {
+		fmt.Fprintf(b, "%s(key) %s %s %x\n", indent, n.Key.TypeIdentifier, n.Key.describePreimage(), n.Key.Hash)
+	}
+	fmt.Fprintf(b, "%s%s %s %s %x\n", indent, name, n.TypeIdentifier, n.describePreimage(), n.Hash)
+
+	for _, c := range n.Children {
+		c.dump(b, depth+1)
+	}
+}
+
+// describePreimage returns a human-readable version of the preimage of a node.
+func (n *HashNode) describePreimage() string {
+	switch {
+	case n.container && n.TypeIdentifier == listIdentifier:
+		return fmt.Sprintf("[%d elements]", len(n.Children))
+	case n.container && n.TypeIdentifier == setIdentifier:
+		return fmt.Sprintf("{%d elements}", len(n.Children))
+	case n.container:
+		return fmt.Sprintf("{%d entries}", len(n.Children))
+	case n.TypeIdentifier == byteIdentifier && len(n.Preimage) > 0:
+		return fmt.Sprintf("0x%x", n.Preimage)
+	default:
+		return strconv.Quote(string(n.Preimage))
+	}
+}
+
+// hashTracer records the hashes calculated by a hasher as a hash tree.
+//
+// Since the hashes of the children of a list or a dictionary are always
+// calculated right before the hash of the container itself, the tracer keeps
+// a stack of the nodes that do not have a parent yet, and the nodes at the top
+// of the stack become the children of the next container.
+type hashTracer struct {
+	pending []*HashNode
+	err     error
+}
+
+// leaf records the hash of a value that is not a container.
+func (t *hashTracer) leaf(typeIdentifier string, preimage, h []byte) {
+	t.pending = append(t.pending, &HashNode{
+		TypeIdentifier: typeIdentifier,
+		Preimage:       append([]byte{}, preimage...),
+		Hash:           h,
+	})
+}
+
+// label names the last recorded value (ex. with the name of a field or the
+// index of a list element).
+func (t *hashTracer) label(label string) {
+	if len(t.pending) > 0 {
+		t.pending[len(t.pending)-1].Path = label
+	}
+}
+
+// list records the hash of a list with n elements.
+func (t *hashTracer) list(preimage, h []byte, n int) {
+	children := t.pop(n)
+	if children == nil && n > 0 {
+		return
+	}
+
+	t.container(listIdentifier, preimage, h, children)
+}
+
+// set records the hash of a set (or a multiset) with n elements, whose
+// children are sorted by their hashes (and deduplicated, when unique is true).
+func (t *hashTracer) set(preimage, h []byte, n int, unique bool) {
+	nodes := t.pop(n)
+	if nodes == nil && n > 0 {
+		return
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return bytes.Compare(nodes[i].Hash, nodes[j].Hash) < 0
+	})
+	children := nodes[:0]
+	for i, c := range nodes {
+		if !unique || i == 0 || !bytes.Equal(c.Hash, nodes[i-1].Hash) {
+			children = append(children, c)
+		}
+	}
+
+	t.container(setIdentifier, preimage, h, children)
+}
+
+// dict records the hash of a dictionary, given its entries sorted by the
+// hashes of their keys. The key of every entry must have been hashed right
+// before its value.
+func (t *hashTracer) dict(typeIdentifier string, preimage, h []byte, entries []hashEntry) {
+	nodes := t.pop(2 * len(entries))
+	if nodes == nil && len(entries) > 0 {
+		return
+	}
+
+	children := make([]*HashNode, len(entries))
+	for i := range children {
+		children[i] = nodes[2*i+1]
+		children[i].Key = nodes[2*i]
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return bytes.Compare(children[i].Key.Hash, children[j].Key.Hash) < 0
+	})
+
+	t.container(typeIdentifier, preimage, h, children)
+}
+
+func (t *hashTracer) container(typeIdentifier string, preimage, h []byte, children []*HashNode) {
+	b := new(bytes.Buffer)
+	for _, c := range children {
+		if c.Key != nil {
+			b.Write(c.Key.Hash)
+		}
+		b.Write(c.Hash)
+	}
+	if !bytes.Equal(b.Bytes(), preimage) {
+		t.fail()
+		return
+	}
+
+	t.pending = append(t.pending, &HashNode{
+		TypeIdentifier: typeIdentifier,
+		Preimage:       append([]byte{}, preimage...),
+		Hash:           h,
+		Children:       children,
+		container:      true,
+	})
+}
+
+// pop removes the top n nodes from the stack, and returns them in the order
+// in which they were recorded.
+func (t *hashTracer) pop(n int) []*HashNode {
+	if n > len(t.pending) {
+		t.fail()
+		return nil
+	}
+
+	nodes := append([]*HashNode{}, t.pending[len(t.pending)-n:]...)
+	t.pending = t.pending[:len(t.pending)-n]
+	return nodes
+}
+
+func (t *hashTracer) fail() {
+	if t.err == nil {
+		t.err = errors.New("could not reconstruct the hash tree of the message")
+	}
+}
+
+// mapKeyLabel returns the label of a map entry within a path, which is its
+// key within square brackets. String keys are quoted when they would be
+// ambiguous otherwise.
+func mapKeyLabel(key protoreflect.MapKey) string {
+	s := key.String()
+	if _, ok := key.Interface().(string); ok && (s == "" || strings.ContainsAny(s, `[]"`)) {
+		s = strconv.Quote(s)
+	}
+	return "[" + s + "]"
+}
@@ -0,0 +1,220 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	any_pb "github.com/golang/protobuf/ptypes/any"
+	_struct "github.com/golang/protobuf/ptypes/struct"
+	timestamp_pb "github.com/golang/protobuf/ptypes/timestamp"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// checkHashTree checks that the hashes within a hash tree are consistent with
+// their type identifiers and preimages, and returns the nodes by path.
+func checkHashTree(t *testing.T, hasher ProtoHasher, node *HashNode, nodes map[string]*HashNode) {
+	oh := hasher.(*objectHasher)
+
+	for _, n := range []*HashNode{node.Key, node} {
+		if n == nil {
+			continue
+		}
+		h, err := oh.digest(n.TypeIdentifier, n.Preimage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(h, n.Hash) {
+			t.Errorf("The hash of %q does not match its preimage.\nActual:   %x\nExpected: %x", n.Path, n.Hash, h)
+		}
+	}
+
+	b := new(bytes.Buffer)
+	for _, c := range node.Children {
+		if c.Key != nil {
+			b.Write(c.Key.Hash)
+		}
+		b.Write(c.Hash)
+		checkHashTree(t, hasher, c, nodes)
+	}
+	if len(node.Children) > 0 && !bytes.Equal(b.Bytes(), node.Preimage) {
+		t.Errorf("The preimage of %q does not match the hashes of its children", node.Path)
+	}
+
+	nodes[node.Path] = node
+}
+
+// TestHashProtoTree checks that hash trees contain every intermediate hash.
+func TestHashProtoTree(t *testing.T) {
+	hashers := []ProtoHasher{
+		NewHasher(AnyResolver(nil)),
+		NewHasher(FieldNamesAsKeys(), EnumsAsStrings(), AnyResolver(nil)),
+		NewHasher(MessageIdentifier(`m`), HMACKey([]byte("secret"))),
+	}
+
+	value, err := protoV1.Marshal(&pb3_latest.Simple{StringField: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	family := &pb3_latest.PersonV2{
+		Id:   1,
+		Name: "Alice",
+		Children: []*pb3_latest.PersonV2{
+			{Id: 2, Name: "Bob"},
+			{Id: 3, Name: "Carol", Children: []*pb3_latest.PersonV2{{Name: "Dave", Age: 1}}},
+		},
+	}
+	stringMaps := &pb3_latest.StringMaps{
+		StringToString: map[string]string{"foo": "bar", "baz": "bar", "a]b": "c"},
+		StringToSimple: map[string]*pb3_latest.Simple{"foo": {StringField: "bar"}, "": {BoolField: true}},
+	}
+	knownTypes := &pb3_latest.KnownTypes{
+		TimestampField: &timestamp_pb.Timestamp{Seconds: 1, Nanos: 2},
+		StructField: &_struct.Struct{Fields: map[string]*_struct.Value{
+			"list": {Kind: &_struct.Value_ListValue{ListValue: &_struct.ListValue{Values: []*_struct.Value{
+				{Kind: &_struct.Value_NumberValue{NumberValue: 1.5}},
+				{Kind: &_struct.Value_NullValue{}},
+			}}}},
+		}},
+	}
+	anyField := &pb3_latest.KnownTypes{
+		AnyField: &any_pb.Any{TypeUrl: "type.googleapis.com/schema.proto3.Simple", Value: value},
+	}
+
+	testCases := []struct {
+		message protoV1.Message
+		paths   []string
+	}{
+		{message: nil, paths: []string{""}},
+		{message: &pb3_latest.Simple{}, paths: []string{""}},
+		{message: family, paths: []string{"id", "name", "children[0].name", "children[1].children[0].age"}},
+		{message: stringMaps, paths: []string{"string_to_string[foo]", "string_to_string[baz]", `string_to_string["a]b"]`, `string_to_simple[""].bool_field`}},
+		{message: knownTypes, paths: []string{"timestamp_field.seconds", "timestamp_field.nanos", "struct_field[list][0]", "struct_field[list][1]"}},
+		{message: anyField, paths: []string{"any_field.type_url", "any_field.value.string_field"}},
+	}
+
+	for _, hasher := range hashers {
+		for _, tc := range testCases {
+			if tc.message == anyField && hasher.(*objectHasher).anyHashingMode != anyAsEmbeddedMessage {
+				continue
+			}
+
+			expected, err := hasher.HashProto(protoV1.MessageV2(tc.message))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			root, err := HashProtoTree(hasher, protoV1.MessageV2(tc.message))
+			if err != nil {
+				t.Fatalf("Building the hash tree of %v returned an error: %v", tc.message, err)
+			}
+			if !bytes.Equal(root.Hash, expected) {
+				t.Errorf("Got the wrong root hash for %v.\nActual:   %x\nExpected: %x", tc.message, root.Hash, expected)
+			}
+
+			nodes := make(map[string]*HashNode)
+			checkHashTree(t, hasher, root, nodes)
+			for _, path := range tc.paths {
+				if nodes[path] == nil {
+					t.Errorf("The hash tree of %v does not have a node for %q.\n%v", tc.message, path, root)
+				}
+			}
+		}
+	}
+}
+
+// TestHashProtoTreePreimages checks the preimages of the nodes of a hash tree.
+func TestHashProtoTreePreimages(t *testing.T) {
+	hasher := NewHasher()
+	root, err := HashProtoTree(hasher, protoV1.MessageV2(&pb3_latest.Simple{
+		DoubleField: 1.5,
+		BytesField:  []byte{0xca, 0xfe},
+		RepetitiveField: &pb3_latest.Repetitive{
+			StringField: []string{"foo", "bar"},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodes := make(map[string]*HashNode)
+	checkHashTree(t, hasher, root, nodes)
+
+	testCases := []struct {
+		path           string
+		typeIdentifier string
+		preimage       string
+		key            string
+	}{
+		{path: "double_field", typeIdentifier: "f", preimage: "+1:011", key: "5"},
+		{path: "bytes_field", typeIdentifier: "r", preimage: "\xca\xfe", key: "3"},
+		{path: "repetitive_field.string_field[0]", typeIdentifier: "u", preimage: "foo"},
+		{path: "repetitive_field.string_field[1]", typeIdentifier: "u", preimage: "bar"},
+	}
+
+	for _, tc := range testCases {
+		n := nodes[tc.path]
+		if n == nil {
+			t.Errorf("The hash tree does not have a node for %q.\n%v", tc.path, root)
+			continue
+		}
+		if n.TypeIdentifier != tc.typeIdentifier || string(n.Preimage) != tc.preimage {
+			t.Errorf("Got the wrong node for %q: %s %q. Expected: %s %q", tc.path, n.TypeIdentifier, n.Preimage, tc.typeIdentifier, tc.preimage)
+		}
+		if tc.key != "" && (n.Key == nil || n.Key.TypeIdentifier != "i" || string(n.Key.Preimage) != tc.key) {
+			t.Errorf("Got the wrong key for %q: %+v", tc.path, n.Key)
+		}
+	}
+
+	if list := nodes["repetitive_field.string_field"]; list == nil || list.TypeIdentifier != "l" || list.Key == nil {
+		t.Errorf("Got the wrong node for a repeated field: %+v", list)
+	}
+}
+
+// TestHashNodeString checks the human-readable dump of hash trees.
+func TestHashNodeString(t *testing.T) {
+	root, err := HashProtoTree(NewHasher(FieldNamesAsKeys()), protoV1.MessageV2(&pb3_latest.Repetitive{
+		BytesField:  [][]byte{{}},
+		StringField: []string{"foo"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(root.Children) != 2 {
+		t.Fatalf("Expected 2 fields, instead got: %+v", root.Children)
+	}
+
+	// The order of the fields depends on the hashes of their keys.
+	expected := fmt.Sprintf("(root) d {2 entries} %x\n", root.Hash)
+	for _, c := range root.Children {
+		expected += fmt.Sprintf("  (key) u %q %x\n", c.Path, c.Key.Hash)
+		expected += fmt.Sprintf("  %s l [1 elements] %x\n", c.Path, c.Hash)
+		if c.Path == "bytes_field" {
+			expected += fmt.Sprintf("    bytes_field[0] r \"\" %x\n", c.Children[0].Hash)
+		} else {
+			expected += fmt.Sprintf("    string_field[0] u \"foo\" %x\n", c.Children[0].Hash)
+		}
+	}
+
+	if actual := root.String(); actual != expected {
+		t.Errorf("Got the wrong dump of a hash tree.\nActual:\n%s\nExpected:\n%s", actual, expected)
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// withoutIgnoredFields returns a copy of a message whose ignored fields (see
+// IgnoreFields) are cleared, or the message itself if no fields are ignored.
+// The message is never modified.
+func (hasher *objectHasher) withoutIgnoredFields(pb proto.Message) (proto.Message, error) {
+	if len(hasher.ignoredFields) == 0 || pb == nil || !pb.ProtoReflect().IsValid() {
+		return pb, nil
+	}
+
+	cleared := proto.Clone(pb)
+	if err := hasher.clearIgnoredFields(cleared.ProtoReflect()); err != nil {
+		return nil, err
+	}
+	return cleared, nil
+}
+
+// clearIgnoredFields clears the ignored fields of a message (see
+// IgnoreFields).
+func (hasher *objectHasher) clearIgnoredFields(m protoreflect.Message) error {
+	if len(hasher.ignoredFields) == 0 {
+		return nil
+	}
+
+	tree, err := newFieldPathTree(m.Descriptor(), hasher.ignoredFields)
+	if err != nil {
+		return err
+	}
+	clearFields(m, tree)
+	return nil
+}
+
+// clearFields clears the fields of a message that are part of a field path
+// tree.
+func clearFields(m protoreflect.Message, tree *fieldPathTree) {
+	for name, child := range tree.children {
+		fd := m.Descriptor().Fields().ByName(name)
+		switch {
+		case child.isLeaf():
+			m.Clear(fd)
+		case m.Has(fd):
+			clearFields(m.Mutable(fd).Message(), child)
+		}
+	}
+}
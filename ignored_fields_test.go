@@ -0,0 +1,141 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// TestIgnoreFields checks that ignored fields are hashed as if they were
+// unset.
+func TestIgnoreFields(t *testing.T) {
+	person := &pb3_latest.PersonV3{
+		Id:         1,
+		Age:        42,
+		Profession: "Doctor",
+		Name: &pb3_latest.PersonV3_StructuredName{StructuredName: &pb3_latest.PersonV3_NameV3{
+			First: "Alice",
+			Last:  "Smith",
+		}},
+	}
+	withoutIgnoredFields := &pb3_latest.PersonV3{
+		Id:   1,
+		Name: &pb3_latest.PersonV3_StructuredName{StructuredName: &pb3_latest.PersonV3_NameV3{Last: "Smith"}},
+	}
+	original := protoV1.Clone(person)
+
+	expected, err := NewHasher().HashProto(protoV1.MessageV2(withoutIgnoredFields))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashers := []ProtoHasher{
+		NewHasher(IgnoreFields("age", "profession", "structured_name.first")),
+		NewHasher(IgnoreFieldMask(&fieldmaskpb.FieldMask{Paths: []string{"structured_name.first", "age"}}), IgnoreFields("profession")),
+	}
+	for _, hasher := range hashers {
+		h, err := hasher.HashProto(protoV1.MessageV2(person))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(h, expected) {
+			t.Errorf("Got the wrong hash with %v: %x, expected %x", hasher, h, expected)
+		}
+
+		// The ObjectHash of redacted messages accounts for the ignored fields.
+		redacted, fields, err := Redact(hasher, protoV1.MessageV2(person), []string{"id", "age"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fields) != 1 || fields[0].Path != "id" {
+			t.Errorf("Got the wrong redacted fields: %v", fields)
+		}
+		if h, err = HashRedacted(hasher, redacted, fields); err != nil || !bytes.Equal(h, expected) {
+			t.Errorf("Got the wrong hash for a redacted message with %v: %x (%v), expected %x", hasher, h, err, expected)
+		}
+
+		// Ignored fields cannot be proven.
+		if _, err := ProveField(hasher, protoV1.MessageV2(person), "age"); err == nil {
+			t.Errorf("Expected an error when proving an ignored field with %v", hasher)
+		}
+		proof, err := ProveField(hasher, protoV1.MessageV2(person), "structured_name.last")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyFieldProof(hasher, protoV1.MessageV2(person).ProtoReflect().Descriptor(), expected, proof, "Smith"); err != nil {
+			t.Errorf("Could not verify a field proof with %v: %v", hasher, err)
+		}
+	}
+
+	if !protoV1.Equal(person, original) {
+		t.Error("Hashing a message with ignored fields modified it.")
+	}
+
+	// The paths must be valid for the hashed messages.
+	if _, err := NewHasher(IgnoreFields("unknown")).HashProto(protoV1.MessageV2(person)); err == nil {
+		t.Error("Expected an error for an invalid path.")
+	}
+}
+
+// TestIgnoreAnnotations checks that the fields annotated with the
+// (objecthash.ignore) option are hashed as if they were unset.
+func TestIgnoreAnnotations(t *testing.T) {
+	m := annotatedMessage(t, optionsFile, ignoreOptionName.Name(), 1, "tags", "name")
+	m.Set(m.Descriptor().Fields().ByName("name"), protoreflect.ValueOfString("foo"))
+	m.Mutable(m.Descriptor().Fields().ByName("tags")).List().Append(protoreflect.ValueOfString("bar"))
+
+	h, err := NewHasher().HashProto(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := NewHasher().HashProto(m.New().Interface())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h, expected) {
+		t.Errorf("Got the wrong hash for a message whose fields are all ignored: %x, expected %x", h, expected)
+	}
+
+	// Annotations whose value is false do not change anything.
+	m = annotatedMessage(t, optionsFile, ignoreOptionName.Name(), 0, "tags", "name")
+	m.Set(m.Descriptor().Fields().ByName("name"), protoreflect.ValueOfString("foo"))
+	if h, err = NewHasher().HashProto(m); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(h, expected) {
+		t.Error("A field annotated with (objecthash.ignore) = false was ignored.")
+	}
+
+	// The same option declared within another package is not the
+	// (objecthash.ignore) option, even though it has the same number.
+	m = annotatedMessage(t, foreignOptionsFile(t), ignoreOptionName.Name(), 1, "tags", "name")
+	m.Set(m.Descriptor().Fields().ByName("name"), protoreflect.ValueOfString("foo"))
+	if h, err = NewHasher().HashProto(m); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(h, expected) {
+		t.Error("A field annotated with a foreign option was ignored.")
+	}
+	if err := Validate(NewHasher(), m); err != nil {
+		t.Errorf("Validating a message with a foreign option returned an error: %v", err)
+	}
+}
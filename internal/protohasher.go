@@ -20,6 +20,10 @@ import (
 
 // ProtoHasher is an interface for hashers that are capable of returning an
 // ObjectHash for protobufs.
+//
+// Unlike protohash.ProtoHasher, this accepts the messages of the older proto
+// API, since the test protos are generated by an older version of
+// protoc-gen-go.
 type ProtoHasher interface {
 	HashProto(pb proto.Message) ([]byte, error)
 }
@@ -34,6 +38,14 @@ type ProtoHashers struct {
 	// NewHasher(FieldNamesAsKeys())
 	FieldNamesAsKeysHasher ProtoHasher
 
+	// A ProtoHasher that uses the JSON names of fields as keys, returned by
+	// NewHasher(JSONNamesAsKeys())
+	JSONNamesAsKeysHasher ProtoHasher
+
+	// A ProtoHasher that hashes messages like their JSON format, returned by
+	// NewHasher(ProtoJSONCompatible())
+	ProtoJSONCompatibleHasher ProtoHasher
+
 	// A ProtoHasher that uses strings for enum values, returned by
 	// NewHasher(EnumsAsStrings())
 	EnumsAsStringsHasher ProtoHasher
@@ -45,4 +57,14 @@ type ProtoHashers struct {
 	// A ProtoHasher that uses a custom identifier for proto messages, returned
 	// by NewHasher(MessageIdentifier(`m`))
 	CustomMessageIdentifierHasher ProtoHasher
+
+	// A ProtoHasher that uses field names as keys and hashes Any messages using
+	// the messages they embed, returned by
+	// NewHasher(FieldNamesAsKeys(), AnyResolver(nil))
+	AnyAsEmbeddedMessageHasher ProtoHasher
+
+	// A ProtoHasher that uses field names as keys and hashes Any messages as
+	// regular messages, returned by
+	// NewHasher(FieldNamesAsKeys(), AnyAsTypeURLAndBytes())
+	AnyAsTypeURLAndBytesHasher ProtoHasher
 }
@@ -15,25 +15,19 @@
 package protohash
 
 import (
-	"errors"
-	"fmt"
-	"reflect"
-)
-
-// stringify returns a string representation of a reflect.Value object.
-func stringify(v reflect.Value) (string, error) {
-	if !v.IsValid() {
-		return "", errors.New("encountered a null pointer")
-	}
+	"strconv"
 
-	if !v.CanInterface() {
-		return "", errors.New("encountered an unexported struct field")
-	}
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
 
-	stringerValue, ok := v.Interface().(fmt.Stringer)
-	if ok {
-		return stringerValue.String(), nil
+// stringify returns the string representation of an enum value.
+//
+// This is the name of the enum value, or its number (as a decimal string) if
+// the value is not defined by the enum. This matches the String() method of
+// generated enum types.
+func stringify(ed protoreflect.EnumDescriptor, n protoreflect.EnumNumber) string {
+	if ev := ed.Values().ByNumber(n); ev != nil {
+		return string(ev.Name())
 	}
-
-	return "", fmt.Errorf("failed to represent value '%v' as a string because it does not have a 'String()' method", v)
+	return strconv.Itoa(int(n))
 }
@@ -16,12 +16,13 @@ package protohash
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
-	"reflect"
+	"hash"
 	"sort"
 
-	"github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // objectHasher is a configurable object for hashing protocol buffer objects.
@@ -34,281 +35,528 @@ type objectHasher struct {
 	// tag number as the key.
 	fieldNamesAsKeys bool
 
+	// Whether to use the JSON name of a field as its key (see JSONNamesAsKeys).
+	jsonNamesAsKeys bool
+
+	// Whether to hash values like they appear in the JSON format of messages
+	// (see ProtoJSONCompatible).
+	protoJSONCompatible bool
+
 	// Custom type identifier for hashing proto messages, as opposed to using
 	// the map identifier.
 	messageIdentifier string
+
+	// How to hash google.protobuf.Any messages. By default, they're not
+	// supported.
+	anyHashingMode anyHashingMode
+
+	// The resolver used for finding the types of the messages embedded in
+	// google.protobuf.Any messages.
+	anyResolver TypeResolver
+
+	// The resolver used for finding the extensions of extendable messages. If
+	// it is nil, extendable messages are not supported.
+	extensionResolver protoregistry.ExtensionTypeResolver
+
+	// How to hash the repeated fields with the given full names, which takes
+	// precedence over their annotations (see objecthash/options.proto).
+	repeatedFieldModes map[protoreflect.FullName]repeatedFieldMode
+
+	// The paths of the fields that are hashed as if they were unset, relative
+	// to the hashed messages (see IgnoreFields).
+	ignoredFields []string
+
+	// Whether to hash unknown fields based on their wire types, as opposed to
+	// rejecting messages with unknown fields.
+	hashUnknownFields bool
+
+	// How to hash proto2 required fields. By default, they're not supported.
+	requiredFieldPolicy requiredFieldPolicy
+
+	// How to hash proto2 fields with explicit default values. By default,
+	// they're not supported.
+	explicitDefaultPolicy explicitDefaultPolicy
+
+	// The hash function used for calculating all hashes. If it is nil, SHA-256
+	// is used.
+	hashFunction func() hash.Hash
+
+	// The key used for calculating all hashes as HMACs of the hash function.
+	// If it is nil, plain hashes are used.
+	hmacKey []byte
+
+	// The tracer recording every intermediate hash, which is only set on the
+	// copies of a hasher used for building hash trees (see HashProtoTree).
+	tracer *hashTracer
 }
 
+// fieldHook can change how the fields of a message get hashed. It is called
+// for every field of a message, before checking whether the field is set.
+//
+// If handled is false, then the field is hashed as usual. Otherwise, vhash is
+// used as the hash of the field's value, unless it is nil, in which case the
+// field is skipped.
+type fieldHook func(m protoreflect.Message, fd protoreflect.FieldDescriptor) (vhash []byte, handled bool, err error)
+
 // HashProto returns the object hash of a given protocol buffer message.
-func (hasher *objectHasher) HashProto(pb proto.Message) (h []byte, err error) {
+func (hasher *objectHasher) HashProto(pb proto.Message) ([]byte, error) {
+	return hasher.hashProto(pb, nil)
+}
+
+// hashProto returns the object hash of a given protocol buffer message, using
+// a hook for changing how the fields of the top-level message get hashed.
+func (hasher *objectHasher) hashProto(pb proto.Message, hook fieldHook) (h []byte, err error) {
 	// Ensure that we can recover if the proto library panics.
-	// See: https://github.com/golang/protobuf/issues/478
 	defer func() {
 		if r := recover(); r != nil {
-			err = r.(error)
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
 		}
 	}()
 
 	// Check if the value is nil.
 	if pb == nil {
-		return hashNil()
+		return hasher.hashNil()
+	}
+	m := pb.ProtoReflect()
+	if !m.IsValid() {
+		return hasher.hashNil()
 	}
 
-	// Expliclity set any custom default values. This is done in order to detect
-	// proto2 custom default values and return errors for them as soon as they're
-	// introduced to the schema.
-	proto.SetDefaults(pb)
+	if len(hasher.ignoredFields) > 0 {
+		pb, err = hasher.withoutIgnoredFields(pb)
+		if err != nil {
+			return nil, err
+		}
+		m = pb.ProtoReflect()
+	}
 
-	// Make sure the proto itself is actually valid (ie. can be marshalled).
-	// If this fails, it probably means there are unset required fields or invalid
-	// values.
-	if _, err = proto.Marshal(pb); err != nil {
-		return nil, err
+	// Make sure the proto itself is actually valid (ie. has all of its required
+	// fields set), unless required fields are hashed like optional fields.
+	if hasher.requiredFieldPolicy == requiredFieldsAsErrors {
+		if err = proto.CheckInitialized(pb); err != nil {
+			return nil, withMessageType(fmt.Errorf("%w: %v", ErrRequiredField, err), m.Descriptor())
+		}
 	}
 
-	val := reflect.ValueOf(pb)
+	return hasher.hashStructWithHook(m, hook)
+}
 
-	// Dereference the proto pointer and return its underlying struct.
-	v := reflect.Indirect(val)
+func (hasher *objectHasher) hashRepeatedField(fd protoreflect.FieldDescriptor, list protoreflect.List) ([]byte, error) {
+	hashes, err := hasher.repeatedFieldHashes(fd, list)
+	if err != nil {
+		return nil, err
+	}
 
-	return hasher.hashStruct(v)
+	switch hasher.repeatedFieldMode(fd) {
+	case repeatedFieldAsSet:
+		return hasher.hashSet(hashes, true)
+	case repeatedFieldAsMultiset:
+		return hasher.hashSet(hashes, false)
+	default:
+		return hasher.hashList(hashes)
+	}
 }
 
-func (hasher *objectHasher) hashRepeatedField(v reflect.Value, sf reflect.StructField, props *proto.Properties) ([]byte, error) {
-	b := new(bytes.Buffer)
-	for j := 0; j < v.Len(); j++ {
-		elem := v.Index(j)
-		if elem.Kind() == reflect.Ptr && elem.IsNil() {
-			return nil, errors.New("got a nil message in a repeated field, which is invalid")
+// repeatedFieldHashes returns the hashes of the elements of a repeated field,
+// in order.
+func (hasher *objectHasher) repeatedFieldHashes(fd protoreflect.FieldDescriptor, list protoreflect.List) ([][]byte, error) {
+	hashes := make([][]byte, list.Len())
+	for j := 0; j < list.Len(); j++ {
+		elem := list.Get(j)
+		if isNilMessage(elem) {
+			err := fmt.Errorf("%w in a repeated field, which is invalid", ErrNilMessage)
+			return nil, withPathElement(err, fmt.Sprintf("[%d]", j))
 		}
 
-		h, err := hasher.hashValue(elem, reflect.StructField{}, props)
+		h, err := hasher.hashValue(fd, elem)
 		if err != nil {
-			return nil, err
+			return nil, withPathElement(err, fmt.Sprintf("[%d]", j))
 		}
-		b.Write(h[:])
+		hasher.traceLabel(fmt.Sprintf("[%d]", j))
+		hashes[j] = h
 	}
-	return hash(listIdentifier, b.Bytes())
+	return hashes, nil
 }
 
-func (hasher *objectHasher) hashMap(v reflect.Value, sf reflect.StructField, props *proto.Properties) ([]byte, error) {
-	mapHashEntries := make([]hashEntry, v.Len())
-	n := 0
-
-	keyTag := sf.Tag.Get("protobuf_key")
-	keyProps := new(proto.Properties)
-	keyProps.Parse(keyTag)
+func (hasher *objectHasher) hashMap(fd protoreflect.FieldDescriptor, m protoreflect.Map) ([]byte, error) {
+	mapHashEntries, err := hasher.mapEntries(fd, m)
+	if err != nil {
+		return nil, err
+	}
+	return hasher.hashEntries(mapIdentifier, mapHashEntries)
+}
 
-	valTag := sf.Tag.Get("protobuf_val")
-	valProps := new(proto.Properties)
-	valProps.Parse(valTag)
+// mapEntries returns the hashes of the entries of a map field, sorted by the
+// hashes of their keys.
+func (hasher *objectHasher) mapEntries(fd protoreflect.FieldDescriptor, m protoreflect.Map) ([]hashEntry, error) {
+	mapHashEntries := make([]hashEntry, 0, m.Len())
 
-	keys := v.MapKeys()
-	for _, key := range keys {
-		val := v.MapIndex(key)
+	keyFd := fd.MapKey()
+	valFd := fd.MapValue()
 
-		if val.Kind() == reflect.Ptr && val.IsNil() {
-			return nil, errors.New("got a nil message in a map field, which is invalid")
+	var err error
+	m.Range(func(key protoreflect.MapKey, val protoreflect.Value) bool {
+		if isNilMessage(val) {
+			err = withPathElement(fmt.Errorf("%w in a map field, which is invalid", ErrNilMessage), mapKeyLabel(key))
+			return false
 		}
 
 		// Hash the key.
-		khash, err := hasher.hashValue(key, reflect.StructField{}, keyProps)
+		var khash []byte
+		khash, err = hasher.hashMapKey(keyFd, key)
 		if err != nil {
-			return nil, err
+			err = withPathElement(err, mapKeyLabel(key))
+			return false
 		}
-		mapHashEntries[n].khash = khash
 
 		// Hash the value.
-		vhash, err := hasher.hashValue(val, reflect.StructField{}, valProps)
+		var vhash []byte
+		vhash, err = hasher.hashValue(valFd, val)
 		if err != nil {
-			return nil, err
+			err = withPathElement(err, mapKeyLabel(key))
+			return false
 		}
-		mapHashEntries[n].vhash = vhash
+		hasher.traceLabel(mapKeyLabel(key))
 
-		n++
+		mapHashEntries = append(mapHashEntries, hashEntry{khash: khash, vhash: vhash})
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	sort.Sort(byKHash(mapHashEntries))
+	return mapHashEntries, nil
+}
+
+// hashMapKey returns the hash of the key of a map entry, which is a string
+// when messages are hashed like their JSON format.
+func (hasher *objectHasher) hashMapKey(fd protoreflect.FieldDescriptor, key protoreflect.MapKey) ([]byte, error) {
+	if hasher.protoJSONCompatible {
+		// The string representation of bool and integer keys is the one used by
+		// the JSON format (ex. "true" or "-5").
+		return hasher.hashJSONString(key.String())
+	}
+	return hasher.hashValue(fd, key.Value())
+}
+
+// hashEntries returns the hash of a dictionary, given the hashes of its
+// entries sorted by the hashes of their keys.
+func (hasher *objectHasher) hashEntries(t string, entries []hashEntry) ([]byte, error) {
 	h := new(bytes.Buffer)
-	for _, e := range mapHashEntries {
+	for _, e := range entries {
 		h.Write(e.khash[:])
 		h.Write(e.vhash[:])
 	}
-	return hash(mapIdentifier, h.Bytes())
+
+	d, err := hasher.digest(t, h.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	if hasher.tracer != nil {
+		hasher.tracer.dict(t, h.Bytes(), d, entries)
+	}
+	return d, nil
+}
+
+// hashList returns the hash of a list, given the hashes of its elements.
+func (hasher *objectHasher) hashList(hashes [][]byte) ([]byte, error) {
+	b := new(bytes.Buffer)
+	for _, h := range hashes {
+		b.Write(h[:])
+	}
+
+	d, err := hasher.digest(listIdentifier, b.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	if hasher.tracer != nil {
+		hasher.tracer.list(b.Bytes(), d, len(hashes))
+	}
+	return d, nil
+}
+
+// traceLabel names the last value hashed by the hasher in its hash tree, if
+// it is building one (see HashProtoTree).
+func (hasher *objectHasher) traceLabel(label string) {
+	if hasher.tracer != nil {
+		hasher.tracer.label(label)
+	}
 }
 
-// hashStruct hashes the struct objects of dereferenced proto messages.
+// hashStruct hashes proto messages.
 //
-// All proto messages are represented as pointers to structs. This method is
-// used to calculate a proto message's hash by passing it the reflect.Value of
-// the dererferenced message object.
-func (hasher *objectHasher) hashStruct(sv reflect.Value) ([]byte, error) {
-	name, ok := CheckWellKnownType(sv)
-	if ok {
-		return hasher.hashWellKnownType(name, sv)
+// Well-known types are given special treatment, while all other messages are
+// hashed as a dictionary of their fields.
+func (hasher *objectHasher) hashStruct(m protoreflect.Message) ([]byte, error) {
+	return hasher.hashStructWithHook(m, nil)
+}
+
+// hashStructWithHook hashes proto messages, using a hook for changing how
+// their fields get hashed (see fieldHook).
+//
+// Hooks cannot be used with well-known types, since their hashes do not
+// necessarily depend on the hashes of their fields.
+//
+// The errors are HashErrors, whose message type is that of the message if it
+// was not set by a nested message.
+func (hasher *objectHasher) hashStructWithHook(m protoreflect.Message, hook fieldHook) ([]byte, error) {
+	h, err := hasher.hashStructOrWellKnownType(m, hook)
+	if err != nil {
+		return nil, withMessageType(err, m.Descriptor())
 	}
+	return h, nil
+}
+
+func (hasher *objectHasher) hashStructOrWellKnownType(m protoreflect.Message, hook fieldHook) ([]byte, error) {
+	md := m.Descriptor()
 
-	if isExtendable(sv) {
-		return nil, errors.New("extendable messages cannot be hashed reliably")
+	name, ok := CheckWellKnownType(md)
+	if ok || hasher.isProtoJSONFieldMask(md) {
+		if hook != nil {
+			return nil, fmt.Errorf("the fields of well-known types cannot be hashed individually: %s", md.FullName())
+		}
+		if !ok {
+			return hasher.hashProtoJSONFieldMask(m)
+		}
+		return hasher.hashWellKnownType(name, m)
 	}
 
-	st := sv.Type()
-	sprops := proto.GetProperties(st)
+	return hasher.hashStructFields(m, hook)
+}
 
-	structHashEntries := make([]hashEntry, sv.NumField())
-	for i := 0; i < sv.NumField(); i++ {
-		var entry hashEntry
-		var err error
+// hashStructFields hashes the fields of proto messages, without giving any
+// special treatment to well-known types. The hook is optional (see
+// fieldHook).
+func (hasher *objectHasher) hashStructFields(m protoreflect.Message, hook fieldHook) ([]byte, error) {
+	structHashEntries, err := hasher.structFieldEntries(m, hook)
+	if err != nil {
+		return nil, err
+	}
+	return hasher.hashEntries(hasher.messageTypeIdentifier(), structHashEntries)
+}
 
-		v := sv.Field(i)
-		sf := st.Field(i)
+// structFieldEntries returns the hashes of the set fields of a proto message,
+// sorted by the hashes of their keys. The hook is optional (see fieldHook).
+//
+// The set extensions of extendable messages are hashed along with the other
+// fields when they are enabled (see ExtensionResolver), and so are unknown
+// fields (see HashUnknownFields).
+func (hasher *objectHasher) structFieldEntries(m protoreflect.Message, hook fieldHook) ([]hashEntry, error) {
+	m, extensions, err := hasher.messageExtensions(m)
+	if err != nil {
+		return nil, err
+	}
 
-		// Ignore content-independent "XXX_" fields.
-		if isContentIndependentField(v, sf) {
-			continue
+	if hasher.hashUnknownFields {
+		err = failIfMalformedOneOfs(m)
+	} else {
+		err = failIfUnsupported(m)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fields := m.Descriptor().Fields()
+	fds := make([]protoreflect.FieldDescriptor, 0, fields.Len()+len(extensions))
+	for i := 0; i < fields.Len(); i++ {
+		fds = append(fds, fields.Get(i))
+	}
+	fds = append(fds, extensions...)
+
+	structHashEntries := make([]hashEntry, 0, len(fds))
+	for _, fd := range fds {
+
+		// Fields with explicit defaults are rejected even when they're unset,
+		// since their value would otherwise be ambiguous. Oneof fields are the
+		// exception, because an unset oneof field does not have a default value.
+		if fd.HasDefault() && fd.ContainingOneof() == nil && hasher.explicitDefaultPolicy == explicitDefaultsAsErrors {
+			return nil, withPathElement(ErrExplicitDefault, fieldLabel(fd))
 		}
 
-		// Ignore unset fields (and empty proto3 scalar fields).
-		unset, err := isUnset(v, sf)
-		if err != nil {
-			return nil, err
+		// So are fields that cannot be hashed the way they're meant to be.
+		if err := hasher.failIfNotRepeatedField(fd); err != nil {
+			return nil, withPathElement(err, fieldLabel(fd))
+		}
+
+		if hook != nil {
+			vhash, handled, err := hasher.hookStructField(hook, m, fd)
+			if err != nil {
+				return nil, withPathElement(err, fieldLabel(fd))
+			}
+			if handled {
+				if vhash != nil {
+					khash, err := hasher.hashFieldKey(fd)
+					if err != nil {
+						return nil, withPathElement(err, fieldLabel(fd))
+					}
+					structHashEntries = append(structHashEntries, hashEntry{khash: khash, vhash: vhash})
+				}
+				continue
+			}
 		}
-		if unset {
+
+		// Ignore unset fields (and empty proto3 scalar fields), as well as the
+		// fields that are annotated to be ignored.
+		if hasher.isUnset(m, fd) || annotationsOf(fd).ignore {
 			continue
 		}
 
-		if err = failIfUnsupported(v, sf); err != nil {
+		entry, err := hasher.hashStructField(fd, m.Get(fd))
+		if err != nil {
 			return nil, err
 		}
 
-		if isAOneOfField(v, sf) {
-			entry, err = hasher.hashOneOf(v, sf, sprops.Prop[i])
-		} else {
-			entry, err = hasher.hashStructField(v, sf, sprops.Prop[i])
-		}
+		structHashEntries = append(structHashEntries, entry)
+	}
+
+	if hasher.hashUnknownFields {
+		unknownEntries, err := hasher.unknownFieldEntries(m)
 		if err != nil {
 			return nil, err
 		}
-
-		structHashEntries[i] = entry
+		structHashEntries = append(structHashEntries, unknownEntries...)
 	}
 
 	sort.Sort(byKHash(structHashEntries))
-	h := new(bytes.Buffer)
-	for _, e := range structHashEntries {
-		h.Write(e.khash[:])
-		h.Write(e.vhash[:])
-	}
+	return structHashEntries, nil
+}
 
-	identifier := mapIdentifier
+// messageTypeIdentifier returns the type identifier used for hashing proto
+// messages.
+func (hasher *objectHasher) messageTypeIdentifier() string {
 	if hasher.messageIdentifier != "" {
-		identifier = hasher.messageIdentifier
+		return hasher.messageIdentifier
 	}
-	return hash(identifier, h.Bytes())
+	return mapIdentifier
 }
 
 // hashValue returns the hash of an arbitrary proto field value.
 //
-// Note that the StructField argument is only used for types that can only
-// exist within structs (ie. repeated fields and maps). Therefore, when the
-// value does not exist within a struct, it is safe to call this function with
-// an empty StructField (ie. reflect.StructField{}).
-func (hasher *objectHasher) hashValue(v reflect.Value, sf reflect.StructField, props *proto.Properties) ([]byte, error) {
-	switch v.Kind() {
-	case reflect.Struct:
-		return hasher.hashStruct(v)
-	case reflect.Map:
-		return hasher.hashMap(v, sf, props)
-	case reflect.Slice:
-		if props.Repeated {
-			return hasher.hashRepeatedField(v, sf, props)
+// The field descriptor determines how the value is interpreted. For repeated
+// fields and maps, the value is the whole list or map when it is read directly
+// from the message, while it is a single element when it comes from within a
+// list or a map (in which case the element is hashed on its own).
+func (hasher *objectHasher) hashValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) ([]byte, error) {
+	switch {
+	case fd.IsMap():
+		if m, ok := v.Interface().(protoreflect.Map); ok {
+			return hasher.hashMap(fd, m)
 		}
+	case fd.IsList():
+		if list, ok := v.Interface().(protoreflect.List); ok {
+			return hasher.hashRepeatedField(fd, list)
+		}
+	}
 
-		// If it's not a repeated field, then it must be []byte.
-		return hashBytes(v.Bytes())
-	case reflect.String:
-		return hashUnicode(v.String())
-	case reflect.Float32, reflect.Float64:
-		return hashFloat(v.Float())
-	case reflect.Int32, reflect.Int64:
-		// This also includes enums, which are represented as integers.
-		if hasher.enumsAsStrings && props.Enum != "" {
-			str, err := stringify(v)
-			if err != nil {
-				return nil, err
-			}
-			return hashUnicode(str)
+	if k := fd.Kind(); hasher.protoJSONCompatible && k != protoreflect.MessageKind && k != protoreflect.GroupKind {
+		return hasher.hashProtoJSONScalar(fd, v)
+	}
+
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		// We know that this is not a nil message because unset values (incl. nil
+		// messages) get skipped and should not get hashed.
+		return hasher.hashStruct(v.Message())
+	case protoreflect.BytesKind:
+		return hasher.hashBytes(v.Bytes())
+	case protoreflect.StringKind:
+		return hasher.hashUnicode(v.String())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return hasher.hashFloat(v.Float())
+	case protoreflect.EnumKind:
+		if hasher.enumsAsStrings {
+			return hasher.hashUnicode(stringify(fd.Enum(), v.Enum()))
 		}
-		return hashInt64(v.Int())
-	case reflect.Uint32, reflect.Uint64:
-		return hashUint64(v.Uint())
-	case reflect.Bool:
-		return hashBool(v.Bool())
-	case reflect.Ptr:
-		// We know that this is not a null pointer because unset values (incl. null
-		// pointer) get skipped and should not get hashed.
-		return hasher.hashValue(reflect.Indirect(v), sf, props)
+		return hasher.hashInt64(int64(v.Enum()))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return hasher.hashInt64(v.Int())
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return hasher.hashUint64(v.Uint())
+	case protoreflect.BoolKind:
+		return hasher.hashBool(v.Bool())
 	default:
-		return nil, fmt.Errorf("Unsupported type: %T", v)
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedType, fd.Kind())
 	}
 }
 
-func (hasher *objectHasher) hashStructField(v reflect.Value, sf reflect.StructField, props *proto.Properties) (hashEntry, error) {
+func (hasher *objectHasher) hashStructField(fd protoreflect.FieldDescriptor, v protoreflect.Value) (hashEntry, error) {
+	entry, err := hasher.hashStructFieldValue(fd, v)
+	if err != nil {
+		return hashEntry{}, withPathElement(err, fieldLabel(fd))
+	}
+	return entry, nil
+}
+
+func (hasher *objectHasher) hashStructFieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) (hashEntry, error) {
 	var err error
 	var khash []byte
 	var vhash []byte
 
-	if props.Required {
-		return hashEntry{}, errors.New("required fields are not allowed because they're bad for backwards compatibility")
+	if err = hasher.failIfUnsupportedField(fd); err != nil {
+		return hashEntry{}, err
 	}
 
-	if props.HasDefault {
-		return hashEntry{}, errors.New("fields with explicit defaults are not allowed because they're bad for backwards compatibility")
+	// A set oneof field (or a set message field) must contain an actual
+	// message.
+	//
+	// Notice that a set oneof field is never considered unset, even if its
+	// value is a zero value.
+	if isNilMessage(v) {
+		return hashEntry{}, fmt.Errorf("%w as a value of a oneof field, which is invalid", ErrNilMessage)
 	}
 
 	// Hash the tag.
-	if hasher.fieldNamesAsKeys {
-		khash, err = hashUnicode(props.OrigName)
-	} else {
-		khash, err = hashInt64(int64(props.Tag))
-	}
+	khash, err = hasher.hashFieldKey(fd)
 	if err != nil {
 		return hashEntry{}, err
 	}
 
 	// Hash the value.
-	vhash, err = hasher.hashValue(v, sf, props)
+	vhash, err = hasher.hashValue(fd, v)
 	if err != nil {
 		return hashEntry{}, err
 	}
+	hasher.traceLabel(fieldLabel(fd))
 
 	return hashEntry{khash: khash, vhash: vhash}, nil
 }
 
-func (hasher *objectHasher) hashOneOf(v reflect.Value, sf reflect.StructField, props *proto.Properties) (hashEntry, error) {
-	// A oneof field is an interface which contains a pointer to an inner struct that contains the value.
-	fieldPointer := v.Elem()                      // Get the pointer to the inner struct.
-	innerStruct := reflect.Indirect(fieldPointer) // Get the inner struct.
-
-	// This check protects innerStruct.Field(0) from panicing.
-	if innerStruct.Kind() != reflect.Struct || innerStruct.NumField() != 1 {
-		return hashEntry{}, fmt.Errorf("unsupported interface type: %T. Expected it to be a oneof field", v)
+// hookStructField calls a hook for a field of a message (see fieldHook).
+//
+// The field is checked beforehand, just like it is done for fields that are
+// hashed as usual.
+func (hasher *objectHasher) hookStructField(hook fieldHook, m protoreflect.Message, fd protoreflect.FieldDescriptor) (vhash []byte, handled bool, err error) {
+	vhash, handled, err = hook(m, fd)
+	if err == nil && vhash != nil {
+		err = hasher.failIfUnsupportedField(fd)
 	}
-	innerValue := innerStruct.Field(0) // Get the inner value.
+	return vhash, handled, err
+}
 
-	// Check if the message is malformed.
-	if innerValue.Kind() == reflect.Ptr && innerValue.IsNil() {
-		return hashEntry{}, errors.New("got a nil message as a value of a oneof field, which is invalid")
+// hashFieldKey returns the hash of the key of a message field, which is
+// either its name (the full name for extensions), its JSON name or its tag
+// number.
+func (hasher *objectHasher) hashFieldKey(fd protoreflect.FieldDescriptor) ([]byte, error) {
+	if hasher.jsonNamesAsKeys {
+		if fd.IsExtension() {
+			return hasher.hashUnicode("[" + string(fd.FullName()) + "]")
+		}
+		return hasher.hashUnicode(fd.JSONName())
 	}
-
-	// Parse the field's properties.
-	// Oneof inner structs are defined to have a single field with the "protobuf" tag set.
-	innerFd := innerStruct.Type().Field(0)
-	innerTag := innerFd.Tag.Get("protobuf")
-	innerProps := new(proto.Properties)
-	innerProps.Parse(innerTag)
-
-	// The inner field (which is a struct field) should never be considered unset
-	// even if the value is a zero value.
-	return hasher.hashStructField(innerValue, innerFd, innerProps)
+	if hasher.fieldNamesAsKeys {
+		if fd.IsExtension() {
+			return hasher.hashUnicode(string(fd.FullName()))
+		}
+		return hasher.hashUnicode(string(fd.Name()))
+	}
+	return hasher.hashInt64(int64(fd.Number()))
 }
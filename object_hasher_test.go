@@ -0,0 +1,75 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// TestDynamicMessages checks that dynamic messages have the same hash as the
+// equivalent generated messages.
+func TestDynamicMessages(t *testing.T) {
+	hashers := []ProtoHasher{
+		NewHasher(),
+		NewHasher(FieldNamesAsKeys(), EnumsAsStrings()),
+		NewHasher(MessageIdentifier(`m`)),
+	}
+
+	testMessages := []protoV1.Message{
+		&pb2_latest.Simple{StringField: protoV1.String("foo"), Int64Field: protoV1.Int64(-5)},
+		&pb3_latest.Simple{StringField: "foo", BytesField: []byte("bar"), FloatField: 1.5},
+		&pb3_latest.PersonV2{Name: "Alice", Children: []*pb3_latest.PersonV2{{Name: "Bob", Age: 3}}},
+		&pb3_latest.MyFavoritePlanetsV1{Planets: []pb3_latest.PlanetV1{pb3_latest.PlanetV1_EARTH_V1}},
+		&pb3_latest.StringMaps{StringToString: map[string]string{"foo": "bar"}},
+		&pb3_latest.Singleton{Singleton: &pb3_latest.Singleton_TheString{TheString: ""}},
+	}
+
+	for _, msg := range testMessages {
+		generated := protoV1.MessageV2(msg)
+
+		b, err := proto.Marshal(generated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dynamic := dynamicpb.NewMessage(generated.ProtoReflect().Descriptor())
+		if err = proto.Unmarshal(b, dynamic); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, hasher := range hashers {
+			expected, err := hasher.HashProto(generated)
+			if err != nil {
+				t.Fatalf("Hashing %v returned an error: %v", msg, err)
+			}
+
+			h, err := hasher.HashProto(dynamic)
+			if err != nil {
+				t.Fatalf("Hashing the dynamic version of %v returned an error: %v", msg, err)
+			}
+
+			if !bytes.Equal(h, expected) {
+				t.Errorf("Got the wrong objecthash for the dynamic version of %v.\nActual:   %x\nExpected: %x\n", msg, h, expected)
+			}
+		}
+	}
+}
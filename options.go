@@ -16,6 +16,12 @@ package protohash
 
 import (
 	"fmt"
+	"hash"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 // Option modifies how ObjectHashes for protobufs is calculated.
@@ -54,12 +60,38 @@ type fieldNamesAsKeys struct{}
 
 func (x fieldNamesAsKeys) set(oh *objectHasher) {
 	oh.fieldNamesAsKeys = true
+	oh.jsonNamesAsKeys = false
 }
 
 func (x fieldNamesAsKeys) String() string {
 	return "FieldNamesAsKeys"
 }
 
+// JSONNamesAsKeys returns an Option to specify that the JSON names of fields
+// (ie. their json_name, which is lowerCamelCase by default) should be used as
+// their keys instead of using their tag number.
+//
+// These are the keys that the JSON format of protobuf messages uses (see
+// protojson), which makes it possible to match the ObjectHash of JSON
+// documents produced from protobuf messages. Extensions are keyed by their
+// full name within brackets (ex. "[example.special_number]"), like in the
+// JSON format.
+//
+// Only one of FieldNamesAsKeys and JSONNamesAsKeys applies: the last one
+// specified takes precedence.
+func JSONNamesAsKeys() Option { return jsonNamesAsKeys{} }
+
+type jsonNamesAsKeys struct{}
+
+func (x jsonNamesAsKeys) set(oh *objectHasher) {
+	oh.jsonNamesAsKeys = true
+	oh.fieldNamesAsKeys = false
+}
+
+func (x jsonNamesAsKeys) String() string {
+	return "JSONNamesAsKeys"
+}
+
 // MessageIdentifier returns an Option to specify that proto messages should
 // use the supplied argument as their type identifier. This will make messages
 // have a different hash from maps with equivalent contents.
@@ -79,3 +111,396 @@ func (x messageIdentifier) set(oh *objectHasher) {
 func (x messageIdentifier) String() string {
 	return fmt.Sprintf("MessageIdentifier(%v)", string(x))
 }
+
+// TypeResolver resolves the type URLs of google.protobuf.Any messages into
+// empty proto messages of the corresponding type.
+//
+// The returned message is only used as a destination for unmarshalling the
+// contents of the Any message, so it can be a dynamicpb message.
+type TypeResolver interface {
+	Resolve(typeURL string) (proto.Message, error)
+}
+
+// AnyResolver returns an Option to specify that google.protobuf.Any messages
+// should be unpacked and hashed as the messages they embed.
+//
+// An Any message is hashed as a message with two fields: its "type_url" field
+// (hashed as a string), and its "value" field (hashed as the ObjectHash of the
+// embedded message, rather than as bytes). This makes the hash independent of
+// how the embedded message was serialized.
+//
+// The supplied resolver is used for finding the type of the embedded message.
+// If it is nil, then the messages registered with the proto library (ie.
+// protoregistry.GlobalTypes) are used.
+// Type URLs that cannot be resolved result in an error.
+func AnyResolver(r TypeResolver) Option { return anyResolver{r} }
+
+type anyResolver struct {
+	resolver TypeResolver
+}
+
+func (x anyResolver) set(oh *objectHasher) {
+	oh.anyHashingMode = anyAsEmbeddedMessage
+	oh.anyResolver = x.resolver
+}
+
+func (x anyResolver) String() string {
+	return fmt.Sprintf("AnyResolver(%T)", x.resolver)
+}
+
+// AnyAsTypeURLAndBytes returns an Option to specify that google.protobuf.Any
+// messages should be hashed like any other message, using their "type_url"
+// field (hashed as a string) and their "value" field (hashed as bytes).
+//
+// This does not require resolving the type of the embedded message, but the
+// resulting hash depends on how the embedded message was serialized. Since the
+// proto serialization is not guaranteed to be deterministic, equal messages may
+// end up having different hashes.
+func AnyAsTypeURLAndBytes() Option { return anyAsTypeURLAndBytesOption{} }
+
+type anyAsTypeURLAndBytesOption struct{}
+
+func (x anyAsTypeURLAndBytesOption) set(oh *objectHasher) {
+	oh.anyHashingMode = anyAsTypeURLAndBytes
+	oh.anyResolver = nil
+}
+
+func (x anyAsTypeURLAndBytesOption) String() string {
+	return "AnyAsTypeURLAndBytes"
+}
+
+// ExtensionResolver returns an Option to specify that extendable messages
+// should be hashed, along with their extensions.
+//
+// Set extensions are hashed like any other field: their key is their tag
+// number, or their full name (ex. "example.special_number") when field names
+// are used as keys (see FieldNamesAsKeys). Since the full names of extensions
+// always contain a dot, they never clash with the names of ordinary fields.
+//
+// The supplied resolver is used for finding the extensions, including those
+// whose data is still among the unknown fields of a message (ex. when the
+// message was parsed without knowing about them). If it is nil, then the
+// extensions registered with the proto library (ie. protoregistry.GlobalTypes)
+// are used.
+// Extensions that cannot be found result in an error.
+func ExtensionResolver(r protoregistry.ExtensionTypeResolver) Option {
+	return extensionResolver{r}
+}
+
+type extensionResolver struct {
+	resolver protoregistry.ExtensionTypeResolver
+}
+
+func (x extensionResolver) set(oh *objectHasher) {
+	oh.extensionResolver = x.resolver
+	if oh.extensionResolver == nil {
+		oh.extensionResolver = protoregistry.GlobalTypes
+	}
+}
+
+func (x extensionResolver) String() string {
+	return fmt.Sprintf("ExtensionResolver(%T)", x.resolver)
+}
+
+// RepeatedFieldsAsSets returns an Option to specify that the repeated fields
+// with the given full names (ex. "example.Group.member_ids") should be hashed
+// as sets, whose hashes depend neither on the order of their elements nor on
+// their duplicates.
+//
+// Sets are hashed like in ObjectHash: their type identifier is "s", and the
+// hashes of their elements are sorted and deduplicated. Repeated fields can
+// also be hashed as sets by annotating them with the (objecthash.repeated)
+// field option (see proto/objecthash/options.proto), which this option takes
+// precedence over.
+func RepeatedFieldsAsSets(fields ...string) Option {
+	return repeatedFieldModes{fields: fields, mode: repeatedFieldAsSet}
+}
+
+// RepeatedFieldsAsMultisets returns an Option to specify that the repeated
+// fields with the given full names (ex. "example.Group.member_ids") should be
+// hashed as multisets, whose hashes do not depend on the order of their
+// elements, but depend on their duplicates.
+//
+// Multisets are hashed like sets (see RepeatedFieldsAsSets), except that the
+// duplicates are kept. Therefore, a multiset without duplicates has the same
+// hash as the equivalent set.
+func RepeatedFieldsAsMultisets(fields ...string) Option {
+	return repeatedFieldModes{fields: fields, mode: repeatedFieldAsMultiset}
+}
+
+type repeatedFieldModes struct {
+	fields []string
+	mode   repeatedFieldMode
+}
+
+func (x repeatedFieldModes) set(oh *objectHasher) {
+	modes := make(map[protoreflect.FullName]repeatedFieldMode, len(oh.repeatedFieldModes)+len(x.fields))
+	for name, mode := range oh.repeatedFieldModes {
+		modes[name] = mode
+	}
+	for _, name := range x.fields {
+		modes[protoreflect.FullName(name)] = x.mode
+	}
+	oh.repeatedFieldModes = modes
+}
+
+func (x repeatedFieldModes) String() string {
+	if x.mode == repeatedFieldAsMultiset {
+		return fmt.Sprintf("RepeatedFieldsAsMultisets(%q)", x.fields)
+	}
+	return fmt.Sprintf("RepeatedFieldsAsSets(%q)", x.fields)
+}
+
+// IgnoreFields returns an Option to specify that the fields with the given
+// paths should be hashed as if they were unset (ex. volatile fields such as
+// request IDs or server timestamps).
+//
+// The paths are relative to the hashed messages, and use the same format as
+// google.protobuf.FieldMask: they can refer to fields nested within singular
+// message fields (ex. "metadata.request_id"), but not to the elements of
+// repeated fields or maps, nor to the fields of well-known types. Hashing a
+// message for which the paths are not valid results in an error, so the
+// hasher can only be used for a single type of message.
+//
+// Since ignored fields are hashed as if they were unset, fields that cannot
+// be hashed even when they are unset (ex. required fields) still result in an
+// error. Fields can also be ignored by annotating them with the
+// (objecthash.ignore) field option (see proto/objecthash/options.proto), in
+// which case they are ignored within messages of any type.
+func IgnoreFields(paths ...string) Option {
+	return ignoreFields(append([]string{}, paths...))
+}
+
+// IgnoreFieldMask is like IgnoreFields, but it takes the paths of the fields to
+// be ignored from a google.protobuf.FieldMask.
+func IgnoreFieldMask(mask *fieldmaskpb.FieldMask) Option {
+	return IgnoreFields(mask.GetPaths()...)
+}
+
+type ignoreFields []string
+
+func (x ignoreFields) set(oh *objectHasher) {
+	oh.ignoredFields = append(append([]string{}, oh.ignoredFields...), x...)
+}
+
+func (x ignoreFields) String() string {
+	return fmt.Sprintf("IgnoreFields(%q)", []string(x))
+}
+
+// HashUnknownFields returns an Option to specify that the unknown fields of
+// messages (ex. fields added by a newer version of their schema) should be
+// hashed, rather than resulting in an error.
+//
+// Unknown fields are hashed like other fields, keyed by their tag number, but
+// their values only depend on their wire types: varints are hashed as signed
+// integers, fixed32 and fixed64 values as unsigned integers, and
+// length-delimited values as bytes. A tag number that appears several times is
+// hashed as the list of its values. Groups, as well as values whose tag number
+// is that of a known field (ie. with the wrong wire type), result in an error.
+//
+// The hash of a message with unknown fields is the same as the hash it would
+// have with the newer schema when the unknown fields are (in the newer schema):
+//   - int32, int64, uint32 and enum fields (as long as enums are not hashed
+//     as strings, see EnumsAsStrings), as well as uint64 fields below 2^63.
+//   - fixed32 and fixed64 fields, as well as non-negative sfixed32 and
+//     sfixed64 fields.
+//   - bytes fields.
+//   - non-packed repeated fields of the above types, with more than one
+//     element (repeated scalar fields are packed by default in proto3).
+//
+// It is different in every other case, notably when the unknown fields are
+// string fields (hashed as bytes rather than as strings), message fields
+// (hashed as the bytes of the embedded message), packed repeated fields
+// (hashed as bytes), repeated fields with a single element (hashed as that
+// element), map fields, bool, sint32, sint64, float and double fields, or
+// when field names are used as keys (see FieldNamesAsKeys). So, the hash of a
+// message is only stable across schema changes for the above field types.
+func HashUnknownFields() Option { return hashUnknownFields{} }
+
+type hashUnknownFields struct{}
+
+func (x hashUnknownFields) set(oh *objectHasher) {
+	oh.hashUnknownFields = true
+}
+
+func (x hashUnknownFields) String() string {
+	return "HashUnknownFields"
+}
+
+// RequiredFieldsAsOrdinaryFields returns an Option to specify that proto2
+// required fields should be hashed like optional fields, rather than resulting
+// in an error.
+//
+// Required fields are bad for backwards compatibility, but schemas cannot
+// always be changed. With this option, a set required field is hashed like any
+// other set field, while an unset required field is ignored (ie. messages
+// with missing required fields are not rejected), so a required field has the
+// same hash as the optional field it would become.
+func RequiredFieldsAsOrdinaryFields() Option { return requiredFieldsOption{} }
+
+type requiredFieldsOption struct{}
+
+func (x requiredFieldsOption) set(oh *objectHasher) {
+	oh.requiredFieldPolicy = requiredFieldsAsOrdinaryFields
+}
+
+func (x requiredFieldsOption) String() string {
+	return "RequiredFieldsAsOrdinaryFields"
+}
+
+// ExplicitDefaultsAsOrdinaryFields returns an Option to specify that proto2
+// fields with explicit default values should be hashed like other fields,
+// rather than resulting in an error.
+//
+// A set field is hashed using its value, even when it is equal to its default
+// value, while an unset field is ignored (ie. its default value is not hashed).
+// So, a field set to its default value and an unset field have different
+// hashes, even though they have the same value according to their getters.
+//
+// Only one of ExplicitDefaultsAsOrdinaryFields and ExplicitDefaultsAsUnset
+// applies: the last one specified takes precedence.
+func ExplicitDefaultsAsOrdinaryFields() Option {
+	return explicitDefaultsOption(explicitDefaultsAsOrdinaryFields)
+}
+
+// ExplicitDefaultsAsUnset returns an Option to specify that proto2 fields with
+// explicit default values should be hashed as if they were unset when they're
+// set to their default value, rather than resulting in an error. Otherwise,
+// they're hashed like other fields.
+//
+// A field set to its default value and an unset field then have the same hash,
+// which is that of the messages where the field is unset, like proto3 scalar
+// fields set to zero values. Oneof fields are the exception: they're hashed
+// whenever they're set, like proto3 oneof fields.
+//
+// Only one of ExplicitDefaultsAsOrdinaryFields and ExplicitDefaultsAsUnset
+// applies: the last one specified takes precedence.
+func ExplicitDefaultsAsUnset() Option {
+	return explicitDefaultsOption(explicitDefaultsAsUnset)
+}
+
+type explicitDefaultsOption explicitDefaultPolicy
+
+func (x explicitDefaultsOption) set(oh *objectHasher) {
+	oh.explicitDefaultPolicy = explicitDefaultPolicy(x)
+}
+
+func (x explicitDefaultsOption) String() string {
+	if explicitDefaultPolicy(x) == explicitDefaultsAsUnset {
+		return "ExplicitDefaultsAsUnset"
+	}
+	return "ExplicitDefaultsAsOrdinaryFields"
+}
+
+// ProtoJSONCompatible returns an Option to specify that messages should have
+// the same ObjectHash as their JSON format, as produced by protojson.Marshal
+// with the default options. That is, the hash of a message is the ObjectHash
+// of the JSON document (ex. as calculated by ObjectHash's CommonJSONHash),
+// where:
+//   - Fields are keyed by their JSON names (see JSONNamesAsKeys).
+//   - Enum values are hashed as their names, or as numbers when they're
+//     unknown (google.protobuf.NullValue is hashed as nil).
+//   - 64-bit integers are hashed as strings, while other integers are hashed
+//     as floats (like all JSON numbers).
+//   - Floats are hashed as floats, except for NaN and infinities, which are
+//     hashed as strings (ex. "NaN"). 32-bit floats are hashed as the 64-bit
+//     float of their shortest representation (ex. 0.1).
+//   - Bytes are hashed as base64-encoded strings.
+//   - The keys of maps are hashed as strings (ex. "true" or "5").
+//   - Negative zero is hashed as zero, rather than being considered unset, for
+//     proto3 scalar fields.
+//   - Timestamps and durations are hashed as strings (ex.
+//     "1972-01-01T10:00:20.021Z" and "1.5s"), and so are field masks (ex.
+//     "user.displayName,photo").
+//   - Any messages are hashed as a dictionary of the fields of the message they
+//     embed, along with its type URL under the "@type" key (see AnyResolver).
+//     Well-known types are under the "value" key instead.
+//   - Repeated fields are hashed as lists, regardless of their annotations
+//     (see objecthash/options.proto).
+//
+// Values that have no JSON equivalent result in an error (ex. strings with
+// invalid UTF-8, out of range timestamps, or google.protobuf.Value protos with
+// a NaN number_value). Other than that, the messages that can be hashed are
+// the same (ex. required fields are still rejected, even though they can be
+// marshaled, unless RequiredFieldsAsOrdinaryFields is specified).
+//
+// This is a preset, which replaces the options specified before it that
+// affect the above (MessageIdentifier, FieldNamesAsKeys,
+// AnyAsTypeURLAndBytes, RepeatedFieldsAsSets and HashUnknownFields), and which
+// makes fields set to their explicit default get hashed rather than ignored
+// (see ExplicitDefaultsAsUnset), like in the JSON format. Any
+// messages are resolved using the supplied AnyResolver, if any, or the
+// messages registered with the proto library. The hashes only match those of
+// the JSON format with the default hash function and without HMACs, and when
+// the options specified after it do not change how values are hashed (ex.
+// MessageIdentifier). Ignored fields (see IgnoreFields) are hashed as if they
+// were absent from the JSON format.
+func ProtoJSONCompatible() Option { return protoJSONCompatible{} }
+
+type protoJSONCompatible struct{}
+
+func (x protoJSONCompatible) set(oh *objectHasher) {
+	oh.protoJSONCompatible = true
+	oh.jsonNamesAsKeys = true
+	oh.fieldNamesAsKeys = false
+	oh.messageIdentifier = ""
+	oh.repeatedFieldModes = nil
+	oh.hashUnknownFields = false
+	if oh.explicitDefaultPolicy == explicitDefaultsAsUnset {
+		oh.explicitDefaultPolicy = explicitDefaultsAsOrdinaryFields
+	}
+	if oh.anyHashingMode != anyAsEmbeddedMessage {
+		oh.anyHashingMode = anyAsEmbeddedMessage
+		oh.anyResolver = nil
+	}
+}
+
+func (x protoJSONCompatible) String() string {
+	return "ProtoJSONCompatible"
+}
+
+// HashFunction returns an Option to specify the hash function used for
+// calculating ObjectHashes, instead of SHA-256 (ex. sha512.New512_256).
+//
+// The hash function is used for every hashed value, including the values
+// nested within lists, maps and messages. Hashes calculated using different
+// hash functions are never equal.
+func HashFunction(f func() hash.Hash) Option { return hashFunction(f) }
+
+type hashFunction func() hash.Hash
+
+func (x hashFunction) set(oh *objectHasher) {
+	oh.hashFunction = x
+}
+
+func (x hashFunction) String() string {
+	if x == nil {
+		return "HashFunction(nil)"
+	}
+	return fmt.Sprintf("HashFunction(%T)", x())
+}
+
+// HMACKey returns an Option to specify that all hashes should be calculated as
+// HMACs with the supplied key, using the hash function of the hasher (see
+// HashFunction).
+//
+// This applies to every hashed value, including the values nested within
+// lists, maps and messages, which makes it impractical to find the values
+// that result in a given hash without knowing the key (even for values with
+// very few possibilities, such as booleans or enums). As a result, hashes are
+// only comparable when they're calculated with the same key.
+//
+// The key is copied, so it is safe to modify it after calling this function.
+// Note that an empty key still results in HMACs being used.
+func HMACKey(key []byte) Option { return hmacKey(append([]byte{}, key...)) }
+
+type hmacKey []byte
+
+func (x hmacKey) set(oh *objectHasher) {
+	oh.hmacKey = x
+}
+
+func (x hmacKey) String() string {
+	// The key itself is never included, since it is secret.
+	return fmt.Sprintf("HMACKey(%d bytes)", len(x))
+}
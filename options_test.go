@@ -0,0 +1,146 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// TestHMACKey checks the hashes calculated using HMAC keys against
+// independently calculated test vectors.
+func TestHMACKey(t *testing.T) {
+	// Hashed as {1: true}.
+	simple := protoV1.MessageV2(&pb3_latest.Simple{BoolField: true})
+
+	// Hashed as {"planets": ["EARTH_V1"]} with the `m` message identifier.
+	planets := protoV1.MessageV2(&pb3_latest.MyFavoritePlanetsV1{Planets: []pb3_latest.PlanetV1{pb3_latest.PlanetV1_EARTH_V1}})
+
+	testCases := []struct {
+		hasher   ProtoHasher
+		message  proto.Message
+		expected string
+	}{
+		{
+			hasher:   NewHasher(HMACKey([]byte("secret"))),
+			message:  simple,
+			expected: "0dd08529178ae5df6f379159db203ef03ce0802d4f0af51ff1fa3a43cc1f3c70",
+		},
+		{
+			hasher:   NewHasher(HMACKey([]byte("secret"))),
+			message:  nil,
+			expected: "c9e95d4707a7545486e9fbf58334671c8e265b0f93c88c80826b6e879b60008c",
+		},
+		{
+			hasher:   NewHasher(HMACKey([]byte("secret")), EnumsAsStrings(), FieldNamesAsKeys(), MessageIdentifier(`m`)),
+			message:  planets,
+			expected: "db8eab014727292bb5c4ae0fea9321302583ff97593cd095f38dbacceb14cfe5",
+		},
+
+		// Different keys result in different hashes.
+		{
+			hasher:   NewHasher(HMACKey([]byte("another secret"))),
+			message:  simple,
+			expected: "7af9ad7bde75bec355621215d176223c252aee83a822f9eedbae800f0c88d919",
+		},
+		{
+			hasher:   NewHasher(MessageIdentifier(`m`), HMACKey([]byte("another secret")), FieldNamesAsKeys(), EnumsAsStrings()),
+			message:  planets,
+			expected: "f03a5af33dc8043482dd8e192b24b7e3ed1a54892cd7aef3c4f9c74233f0d182",
+		},
+
+		// An empty key still results in HMACs.
+		{
+			hasher:   NewHasher(HMACKey(nil)),
+			message:  simple,
+			expected: "ba7541b3ff1efeac1d1bbee1ba40b23a926be75abe444aee7b5e5dcb41354bf0",
+		},
+
+		// HMACs use the hash function of the hasher.
+		{
+			hasher:   NewHasher(HMACKey([]byte("secret")), HashFunction(sha512.New512_256)),
+			message:  simple,
+			expected: "0770d0311e34d9891e2863809627100cdaa90b46e9029f94ac0f3fcadb33718e",
+		},
+	}
+
+	for _, tc := range testCases {
+		h, err := tc.hasher.HashProto(tc.message)
+		if err != nil {
+			t.Fatalf("Hashing %v returned an error: %v", tc.message, err)
+		}
+
+		if actual := hex.EncodeToString(h); actual != tc.expected {
+			t.Errorf("Got the wrong objecthash for %v.\nActual:   %s\nExpected: %s\n", tc.message, actual, tc.expected)
+		}
+	}
+}
+
+// TestHMACKeyIsCopied checks that modifying the key passed to HMACKey does not
+// affect the hasher.
+func TestHMACKeyIsCopied(t *testing.T) {
+	key := []byte("secret")
+	hasher := NewHasher(HMACKey(key))
+	copy(key, "public")
+
+	h, err := hasher.HashProto(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "c9e95d4707a7545486e9fbf58334671c8e265b0f93c88c80826b6e879b60008c"
+	if actual := hex.EncodeToString(h); actual != expected {
+		t.Errorf("Got the wrong objecthash after modifying the HMAC key.\nActual:   %s\nExpected: %s\n", actual, expected)
+	}
+
+	if s := HMACKey(key).String(); s != "HMACKey(6 bytes)" {
+		t.Errorf("Expected the description of an HMACKey option to not include the key. Instead got %q.", s)
+	}
+}
+
+// TestNamesAsKeysPrecedence checks that the last of FieldNamesAsKeys and
+// JSONNamesAsKeys takes precedence.
+func TestNamesAsKeysPrecedence(t *testing.T) {
+	simple := protoV1.MessageV2(&pb3_latest.Simple{StringField: "foo"})
+
+	testCases := []struct {
+		options  []Option
+		expected Option
+	}{
+		{options: []Option{FieldNamesAsKeys(), JSONNamesAsKeys()}, expected: JSONNamesAsKeys()},
+		{options: []Option{JSONNamesAsKeys(), FieldNamesAsKeys()}, expected: FieldNamesAsKeys()},
+	}
+
+	for _, tc := range testCases {
+		h, err := NewHasher(tc.options...).HashProto(simple)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := NewHasher(tc.expected).HashProto(simple)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(h, expected) {
+			t.Errorf("Got the hash %x with the options %v, expected the hash %x of %v", h, tc.options, expected, tc.expected)
+		}
+	}
+}
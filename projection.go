@@ -0,0 +1,207 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// HashProtoMasked returns the ObjectHash of the projection of a message onto a
+// field mask, where the fields that are not selected by the mask are treated as
+// unset. The hash is the same as that of a message where only the selected
+// fields are populated, which makes it possible to hash the subset of a
+// message that identifies it (ex. for deduplication).
+//
+// The paths of the mask can refer to fields nested within message fields (ex.
+// "user.id"), and use "*" as a wildcard: it selects all the fields of a
+// message (ex. "spec.*", which is the same as "spec"), or all the elements of a
+// repeated field or all the values of a map, which makes it possible to refer
+// to their fields (ex. "members.*.id" or "labels_to_users.*.id"). The fields
+// of well-known types cannot be referred to. The unknown fields and the
+// extensions of the messages whose fields are only partially selected are
+// never selected.
+//
+// The message is not modified, and any ProtoHasher can be used.
+func HashProtoMasked(hasher ProtoHasher, pb proto.Message, mask *fieldmaskpb.FieldMask) ([]byte, error) {
+	if pb == nil || !pb.ProtoReflect().IsValid() {
+		return hasher.HashProto(pb)
+	}
+
+	p, err := newProjection(pb.ProtoReflect().Descriptor(), mask.GetPaths())
+	if err != nil {
+		return nil, err
+	}
+
+	projected := proto.Clone(pb)
+	p.apply(projected.ProtoReflect())
+	return hasher.HashProto(projected)
+}
+
+// projection is a tree of the values selected by a field mask (see
+// HashProtoMasked).
+//
+// The children of a node are either the selected fields of a message, or the
+// selection that applies to all the elements of a repeated field (or the
+// values of a map). A whole node means that the value is selected as a whole,
+// including all of its descendants.
+type projection struct {
+	whole    bool
+	fields   map[protoreflect.Name]*projection
+	elements *projection
+}
+
+// newProjection parses the paths of a field mask of a message.
+func newProjection(md protoreflect.MessageDescriptor, paths []string) (*projection, error) {
+	root := &projection{}
+	for _, path := range paths {
+		if err := root.add(md, path); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// add adds a path to the projection. Paths that are covered by other paths
+// (ex. "a.b" is covered by "a") are ignored.
+func (p *projection) add(md protoreflect.MessageDescriptor, path string) error {
+	if path == "" {
+		return fmt.Errorf("invalid field path %q: it is empty", path)
+	}
+
+	// The current node, along with the repeated field or map that it refers to
+	// (if any), or the type of message that it refers to (if any).
+	node := p
+	var collection protoreflect.FieldDescriptor
+	reason := ""
+
+	segments := strings.Split(path, ".")
+	for i, s := range segments {
+		if node.whole {
+			return nil
+		}
+		prefix := strings.Join(segments[:i], ".")
+
+		switch {
+		case collection != nil:
+			if s != "*" {
+				return fmt.Errorf("invalid field path %q: the elements of %s can only be referred to with a wildcard (ie. %s.*)", path, prefix, prefix)
+			}
+			if node.elements == nil {
+				node.elements = &projection{}
+			}
+			node = node.elements
+
+			md, reason = fieldMessage(collection), "is not a message"
+			collection = nil
+		case md == nil:
+			return fmt.Errorf("invalid field path %q: %s %s", path, prefix, reason)
+		case s == "*":
+			if i != len(segments)-1 {
+				return fmt.Errorf("invalid field path %q: the wildcard selecting all the fields of %s must end the path", path, prefix)
+			}
+		default:
+			fd := md.Fields().ByName(protoreflect.Name(s))
+			if fd == nil {
+				return fmt.Errorf("invalid field path %q: %s does not have a field named %q", path, md.FullName(), s)
+			}
+
+			if node.fields == nil {
+				node.fields = make(map[protoreflect.Name]*projection)
+			}
+			child, ok := node.fields[fd.Name()]
+			if !ok {
+				child = &projection{}
+				node.fields[fd.Name()] = child
+			}
+			node = child
+
+			md, reason = nil, "is not a message field"
+			if fd.IsList() || fd.IsMap() {
+				collection = fd
+			} else {
+				md = fieldMessage(fd)
+			}
+		}
+
+		if md != nil {
+			if _, ok := CheckWellKnownType(md); ok {
+				md, reason = nil, "is a well-known type, whose fields cannot be referred to"
+			}
+		}
+	}
+
+	// The whole value is selected by this path, so any longer paths are
+	// ignored.
+	node.whole = true
+	node.fields = nil
+	node.elements = nil
+	return nil
+}
+
+// fieldMessage returns the type of the messages that a field contains (the
+// values of maps, or the elements of repeated fields), or nil if it does not
+// contain messages.
+func fieldMessage(fd protoreflect.FieldDescriptor) protoreflect.MessageDescriptor {
+	if fd.IsMap() {
+		fd = fd.MapValue()
+	}
+	if k := fd.Kind(); k != protoreflect.MessageKind && k != protoreflect.GroupKind {
+		return nil
+	}
+	return fd.Message()
+}
+
+// apply clears the values of a message that are not selected by the
+// projection.
+func (p *projection) apply(m protoreflect.Message) {
+	if p.whole || !m.IsValid() {
+		return
+	}
+
+	m.SetUnknown(nil)
+
+	var fds []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fds = append(fds, fd)
+		return true
+	})
+
+	for _, fd := range fds {
+		child := p.fields[fd.Name()]
+		switch {
+		case child == nil || fd.IsExtension():
+			m.Clear(fd)
+		case child.whole || (child.elements != nil && child.elements.whole):
+			// All of the value is selected (ex. "labels" or "labels.*").
+		case fd.IsList():
+			list := m.Mutable(fd).List()
+			for j := 0; j < list.Len(); j++ {
+				child.elements.apply(list.Get(j).Message())
+			}
+		case fd.IsMap():
+			m.Mutable(fd).Map().Range(func(_ protoreflect.MapKey, v protoreflect.Value) bool {
+				child.elements.apply(v.Message())
+				return true
+			})
+		default:
+			child.apply(m.Mutable(fd).Message())
+		}
+	}
+}
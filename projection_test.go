@@ -0,0 +1,114 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// TestHashProtoMasked checks that the hash of the projection of a message onto
+// a field mask is that of a message where only the selected fields are set.
+func TestHashProtoMasked(t *testing.T) {
+	hasher := NewHasher()
+
+	simple := &pb3_latest.Simple{
+		BoolField:   true,
+		StringField: "foo",
+		Int64Field:  5,
+		SimpleField: &pb3_latest.Simple{StringField: "bar", Int64Field: 6},
+		RepetitiveField: &pb3_latest.Repetitive{
+			StringField: []string{"a", "b"},
+			SimpleField: []*pb3_latest.Simple{{StringField: "c", BoolField: true}, {Int64Field: 7}},
+		},
+	}
+	maps := &pb3_latest.StringMaps{
+		StringToString: map[string]string{"a": "b"},
+		StringToSimple: map[string]*pb3_latest.Simple{"x": {StringField: "y", BoolField: true}},
+	}
+
+	testCases := []struct {
+		message  protoV1.Message
+		paths    []string
+		expected protoV1.Message
+	}{
+		{message: simple, paths: nil, expected: &pb3_latest.Simple{}},
+		{message: simple, paths: []string{"*"}, expected: simple},
+		{message: simple, paths: []string{"string_field", "int64_field"}, expected: &pb3_latest.Simple{StringField: "foo", Int64Field: 5}},
+		{message: simple, paths: []string{"simple_field.string_field"}, expected: &pb3_latest.Simple{SimpleField: &pb3_latest.Simple{StringField: "bar"}}},
+		{message: simple, paths: []string{"simple_field.*"}, expected: &pb3_latest.Simple{SimpleField: simple.SimpleField}},
+		{message: simple, paths: []string{"simple_field", "simple_field.string_field"}, expected: &pb3_latest.Simple{SimpleField: simple.SimpleField}},
+		{message: simple, paths: []string{"repetitive_field.string_field"}, expected: &pb3_latest.Simple{RepetitiveField: &pb3_latest.Repetitive{StringField: []string{"a", "b"}}}},
+		{
+			message: simple,
+			paths:   []string{"repetitive_field.simple_field.*.string_field"},
+			expected: &pb3_latest.Simple{RepetitiveField: &pb3_latest.Repetitive{
+				SimpleField: []*pb3_latest.Simple{{StringField: "c"}, {}},
+			}},
+		},
+		{message: maps, paths: []string{"string_to_string.*"}, expected: &pb3_latest.StringMaps{StringToString: maps.StringToString}},
+		{
+			message:  maps,
+			paths:    []string{"string_to_simple.*.bool_field"},
+			expected: &pb3_latest.StringMaps{StringToSimple: map[string]*pb3_latest.Simple{"x": {BoolField: true}}},
+		},
+	}
+
+	for _, tc := range testCases {
+		original := protoV1.Clone(tc.message)
+
+		h, err := HashProtoMasked(hasher, protoV1.MessageV2(tc.message), &fieldmaskpb.FieldMask{Paths: tc.paths})
+		if err != nil {
+			t.Errorf("Got an error when hashing %T with the paths %q: %v", tc.message, tc.paths, err)
+			continue
+		}
+		expected, err := hasher.HashProto(protoV1.MessageV2(tc.expected))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(h, expected) {
+			t.Errorf("Got the wrong hash for %T with the paths %q: %x, expected the hash of %v: %x", tc.message, tc.paths, h, tc.expected, expected)
+		}
+
+		if !protoV1.Equal(tc.message, original) {
+			t.Errorf("Hashing %T with the paths %q modified it.", tc.message, tc.paths)
+		}
+	}
+}
+
+// TestHashProtoMaskedWithBadPaths checks that invalid paths are rejected.
+func TestHashProtoMaskedWithBadPaths(t *testing.T) {
+	badPaths := []string{
+		"",
+		"unknown_field",
+		"string_field.length",
+		"simple_field.*.string_field",
+		"repetitive_field.simple_field.string_field",
+		"repetitive_field.string_field.*.length",
+		"*.string_field",
+	}
+
+	for _, path := range badPaths {
+		mask := &fieldmaskpb.FieldMask{Paths: []string{path}}
+		if _, err := HashProtoMasked(NewHasher(), protoV1.MessageV2(&pb3_latest.Simple{}), mask); err == nil {
+			t.Errorf("Expected an error for the path %q", path)
+		}
+	}
+}
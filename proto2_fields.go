@@ -0,0 +1,76 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// requiredFieldPolicy specifies how proto2 required fields get hashed.
+type requiredFieldPolicy int
+
+const (
+	// Required fields result in an error, whether they're set or not.
+	requiredFieldsAsErrors requiredFieldPolicy = iota
+
+	// Required fields are hashed like optional fields.
+	// See RequiredFieldsAsOrdinaryFields.
+	requiredFieldsAsOrdinaryFields
+)
+
+// explicitDefaultPolicy specifies how proto2 fields with explicit default
+// values get hashed.
+type explicitDefaultPolicy int
+
+const (
+	// Fields with explicit defaults result in an error, whether they're set or
+	// not (except for unset oneof fields).
+	explicitDefaultsAsErrors explicitDefaultPolicy = iota
+
+	// Fields with explicit defaults are hashed like other fields.
+	// See ExplicitDefaultsAsOrdinaryFields.
+	explicitDefaultsAsOrdinaryFields
+
+	// Fields set to their explicit default are hashed as if they were unset.
+	// See ExplicitDefaultsAsUnset.
+	explicitDefaultsAsUnset
+)
+
+// failIfUnsupportedField returns an error if the values of the provided field
+// cannot be hashed reliably.
+func (hasher *objectHasher) failIfUnsupportedField(fd protoreflect.FieldDescriptor) error {
+	if fd.Cardinality() == protoreflect.Required && hasher.requiredFieldPolicy == requiredFieldsAsErrors {
+		return ErrRequiredField
+	}
+
+	if fd.HasDefault() && hasher.explicitDefaultPolicy == explicitDefaultsAsErrors {
+		return ErrExplicitDefault
+	}
+
+	return nil
+}
+
+// isSetToExplicitDefault checks if a field is set to its explicit default
+// value, and must therefore be hashed as if it was unset (see
+// ExplicitDefaultsAsUnset).
+//
+// Oneof fields are never considered unset once they're set, since which of the
+// fields of a oneof is set matters regardless of its value.
+func (hasher *objectHasher) isSetToExplicitDefault(m protoreflect.Message, fd protoreflect.FieldDescriptor) bool {
+	if hasher.explicitDefaultPolicy != explicitDefaultsAsUnset || !fd.HasDefault() || fd.ContainingOneof() != nil {
+		return false
+	}
+	return m.Has(fd) && m.Get(fd).Equal(fd.Default())
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// asOrdinaryFields returns a copy of a proto2 message whose type has no
+// required fields nor explicit defaults, which makes it possible to compare its
+// hash with that of the original message.
+func asOrdinaryFields(t *testing.T, msg proto.Message) proto.Message {
+	t.Helper()
+
+	md := msg.ProtoReflect().Descriptor()
+	file := protodesc.ToFileDescriptorProto(md.ParentFile())
+	for _, m := range file.GetMessageType() {
+		if m.GetName() != string(md.Name()) {
+			continue
+		}
+		for _, f := range m.GetField() {
+			f.Label = nil
+			f.DefaultValue = nil
+		}
+	}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ordinary := dynamicpb.NewMessage(fd.Messages().ByName(md.Name()))
+
+	b, err := proto.MarshalOptions{AllowPartial: true}.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := proto.Unmarshal(b, ordinary); err != nil {
+		t.Fatal(err)
+	}
+	return ordinary
+}
+
+// checkHashedAs checks that a message hashed with the given options has the
+// same hash as another message hashed with the default options.
+func checkHashedAs(t *testing.T, opts []Option, msg, expected proto.Message) {
+	t.Helper()
+
+	hasher := NewHasher(opts...)
+	h, err := hasher.HashProto(msg)
+	if err != nil {
+		t.Errorf("Got an error when hashing %T{ %[1]v } with %v: %v", msg, opts, err)
+		return
+	}
+	expectedHash, err := NewHasher().HashProto(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h, expectedHash) {
+		t.Errorf("Got the wrong hash for %T{ %[1]v } with %v: %x, expected the hash of { %v }: %x", msg, opts, h, expected, expectedHash)
+	}
+
+	if err := Validate(hasher, msg); err != nil {
+		t.Errorf("Validating %T{ %[1]v } with %v returned an error: %v", msg, opts, err)
+	}
+}
+
+// TestRequiredFieldsAsOrdinaryFields checks that required fields get hashed
+// like optional fields, whether they're set or not.
+func TestRequiredFieldsAsOrdinaryFields(t *testing.T) {
+	opts := []Option{RequiredFieldsAsOrdinaryFields()}
+
+	for _, msg := range []protoV1.Message{
+		&pb2_latest.BadWithRequirements{},
+		&pb2_latest.BadWithRequirements{Text: protoV1.String("foo")},
+	} {
+		m := protoV1.MessageV2(msg)
+		checkHashedAs(t, opts, m, asOrdinaryFields(t, m))
+
+		if _, err := NewHasher().HashProto(m); !errors.Is(err, ErrRequiredField) {
+			t.Errorf("Expected an ErrRequiredField error by default for %T{ %[1]v }, instead got: %v", msg, err)
+		}
+	}
+
+	// Missing required fields are allowed within Any messages too.
+	withAny := protoV1.MessageV2(&pb3_latest.KnownTypes{
+		AnyField: &anypb.Any{TypeUrl: "type.googleapis.com/schema.proto2.BadWithRequirements"},
+	})
+	if _, err := NewHasher(AnyResolver(nil), RequiredFieldsAsOrdinaryFields()).HashProto(withAny); err != nil {
+		t.Errorf("Got an error when hashing an Any message with a missing required field: %v", err)
+	}
+	if _, err := NewHasher(AnyResolver(nil)).HashProto(withAny); err == nil {
+		t.Error("Expected an error by default for an Any message with a missing required field")
+	}
+}
+
+// TestExplicitDefaults checks how fields with explicit defaults get hashed,
+// depending on the policy of the hasher.
+func TestExplicitDefaults(t *testing.T) {
+	unset := protoV1.MessageV2(&pb2_latest.BadWithDefaults{})
+	setToDefault := protoV1.MessageV2(&pb2_latest.BadWithDefaults{Text: protoV1.String("N/A")})
+	set := protoV1.MessageV2(&pb2_latest.BadWithDefaults{Text: protoV1.String("foo")})
+
+	// The last policy specified takes precedence.
+	for _, opts := range [][]Option{
+		{ExplicitDefaultsAsOrdinaryFields()},
+		{ExplicitDefaultsAsUnset(), ExplicitDefaultsAsOrdinaryFields()},
+	} {
+		checkHashedAs(t, opts, unset, asOrdinaryFields(t, unset))
+		checkHashedAs(t, opts, setToDefault, asOrdinaryFields(t, setToDefault))
+		checkHashedAs(t, opts, set, asOrdinaryFields(t, set))
+	}
+
+	for _, opts := range [][]Option{
+		{ExplicitDefaultsAsUnset()},
+		{ExplicitDefaultsAsOrdinaryFields(), ExplicitDefaultsAsUnset()},
+	} {
+		checkHashedAs(t, opts, unset, asOrdinaryFields(t, unset))
+		checkHashedAs(t, opts, setToDefault, asOrdinaryFields(t, unset))
+		checkHashedAs(t, opts, set, asOrdinaryFields(t, set))
+	}
+
+	for _, msg := range []proto.Message{unset, setToDefault, set} {
+		if _, err := NewHasher().HashProto(msg); !errors.Is(err, ErrExplicitDefault) {
+			t.Errorf("Expected an ErrExplicitDefault error by default for %T{ %[1]v }, instead got: %v", msg, err)
+		}
+	}
+}
+
+// TestHashingDoesNotModifyMessages checks that hashing messages with required
+// fields and explicit defaults does not populate their fields.
+func TestHashingDoesNotModifyMessages(t *testing.T) {
+	options := [][]Option{
+		{RequiredFieldsAsOrdinaryFields()},
+		{ExplicitDefaultsAsOrdinaryFields()},
+		{ExplicitDefaultsAsUnset()},
+		{ExplicitDefaultsAsUnset(), ProtoJSONCompatible(), RequiredFieldsAsOrdinaryFields()},
+	}
+	messages := []protoV1.Message{
+		&pb2_latest.BadWithRequirements{},
+		&pb2_latest.BadWithDefaults{},
+		&pb2_latest.BadWithDefaults{Text: protoV1.String("N/A")},
+	}
+
+	for _, opts := range options {
+		hasher := NewHasher(opts...)
+		for _, msg := range messages {
+			m := protoV1.MessageV2(msg)
+			original := proto.Clone(m)
+
+			hasher.HashProto(m)
+			Validate(hasher, m)
+
+			if !proto.Equal(m, original) {
+				t.Errorf("Hashing %T{ %[1]v } with %v modified it.", msg, opts)
+			}
+			fd := m.ProtoReflect().Descriptor().Fields().ByName("text")
+			if m.ProtoReflect().Has(fd) != original.ProtoReflect().Has(fd) {
+				t.Errorf("Hashing %T{ %[1]v } with %v changed whether its text field is set.", msg, opts)
+			}
+		}
+	}
+}
@@ -15,11 +15,20 @@
 package protohash
 
 import (
-	"github.com/golang/protobuf/proto"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
 )
 
 // ProtoHasher is an interface for hashers that are capable of returning an
 // ObjectHash for protobufs.
+//
+// Messages generated by older versions of protoc-gen-go (which do not have a
+// ProtoReflect method) can be converted using the MessageV2 function of the
+// github.com/golang/protobuf/proto package.
+//
+// When the hashers created by NewHasher fail to hash a message because of its
+// contents, they return a HashError.
 type ProtoHasher interface {
 	HashProto(pb proto.Message) ([]byte, error)
 }
@@ -32,3 +41,17 @@ func NewHasher(opts ...Option) ProtoHasher {
 	}
 	return &hasher
 }
+
+// HashList returns the ObjectHash of a list, given the ObjectHashes of its
+// elements. This is how the elements of repeated fields are combined, so it
+// can be used for hashing collections of messages (ex. the records of a file)
+// as if they were the elements of a repeated field.
+//
+// The hasher must be one returned by NewHasher.
+func HashList(hasher ProtoHasher, hashes [][]byte) ([]byte, error) {
+	oh, ok := hasher.(*objectHasher)
+	if !ok {
+		return nil, fmt.Errorf("list hashing is not supported by %T", hasher)
+	}
+	return oh.hashList(hashes)
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// TestHashList checks that HashList combines hashes like repeated fields do.
+func TestHashList(t *testing.T) {
+	hasher := NewHasher(HMACKey([]byte("secret")))
+	elements := []*pb3_latest.Simple{{StringField: "foo"}, {Int32Field: 3}}
+
+	tree, err := HashProtoTree(hasher, protoV1.MessageV2(&pb3_latest.Repetitive{SimpleField: elements}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := tree.Children[0].Hash
+
+	var hashes [][]byte
+	for _, e := range elements {
+		h, err := hasher.HashProto(protoV1.MessageV2(e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, h)
+	}
+
+	h, err := HashList(hasher, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h, expected) {
+		t.Errorf("Got the wrong hash for a list.\nActual:   %x\nExpected: %x", h, expected)
+	}
+
+	// The ObjectHash of an empty list.
+	h, err = HashList(NewHasher(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "acac86c0e609ca906f632b0e2dacccb2b77d22b0621f20ebece1a4835b93f6f0"; hex.EncodeToString(h) != expected {
+		t.Errorf("Got the wrong hash for an empty list.\nActual:   %x\nExpected: %s", h, expected)
+	}
+
+	if _, err := HashList(struct{ ProtoHasher }{hasher}, hashes); err == nil {
+		t.Error("Expected an error for an unsupported hasher")
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// This file hashes values the way they appear in the JSON format of protobuf
+// messages, as produced by protojson.Marshal (see ProtoJSONCompatible).
+
+// Valid range of google.protobuf.Timestamp seconds, as documented in
+// google/protobuf/timestamp.proto (ie. 0001-01-01T00:00:00Z to
+// 9999-12-31T23:59:59Z), and of its nanos, which are non-negative fractions of
+// a second.
+const (
+	minTimestampSeconds int64 = -62135596800
+	maxTimestampSeconds int64 = 253402300799
+	maxTimestampNanos   int64 = 999999999
+)
+
+// Full names of the types whose JSON format is special, but which are
+// otherwise hashed like any other type.
+const (
+	fieldMaskFullName protoreflect.FullName = "google.protobuf.FieldMask"
+	nullValueFullName protoreflect.FullName = "google.protobuf.NullValue"
+)
+
+// anyTypeKey is the key of the type URL of google.protobuf.Any messages in the
+// JSON format.
+const anyTypeKey = "@type"
+
+// hashProtoJSONScalar returns the hash of a scalar value (or enum value), as
+// it appears in the JSON format:
+//   - 64-bit integers are strings, while other integers are numbers (ie.
+//     floats).
+//   - Floats are numbers, except for NaN and infinities which are strings.
+//   - Bytes are base64-encoded strings.
+//   - Enum values are their names, or numbers when they're unknown, except for
+//     google.protobuf.NullValue which is null.
+func (hasher *objectHasher) hashProtoJSONScalar(fd protoreflect.FieldDescriptor, v protoreflect.Value) ([]byte, error) {
+	switch fd.Kind() {
+	case protoreflect.BytesKind:
+		return hasher.hashUnicode(base64.StdEncoding.EncodeToString(v.Bytes()))
+	case protoreflect.StringKind:
+		return hasher.hashJSONString(v.String())
+	case protoreflect.FloatKind:
+		return hasher.hashJSONNumber(v.Float(), 32)
+	case protoreflect.DoubleKind:
+		return hasher.hashJSONNumber(v.Float(), 64)
+	case protoreflect.EnumKind:
+		if fd.Enum().FullName() == nullValueFullName {
+			return hasher.hashNil()
+		}
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return hasher.hashUnicode(string(ev.Name()))
+		}
+		return hasher.hashFloat(float64(v.Enum()))
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return hasher.hashFloat(float64(v.Int()))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return hasher.hashFloat(float64(v.Uint()))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return hasher.hashUnicode(strconv.FormatInt(v.Int(), 10))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return hasher.hashUnicode(strconv.FormatUint(v.Uint(), 10))
+	case protoreflect.BoolKind:
+		return hasher.hashBool(v.Bool())
+	default:
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedType, fd.Kind())
+	}
+}
+
+// hashJSONNumber returns the hash of a float of the given bit size, as it
+// appears in the JSON format.
+func (hasher *objectHasher) hashJSONNumber(f float64, bitSize int) ([]byte, error) {
+	switch {
+	case math.IsNaN(f):
+		return hasher.hashUnicode("NaN")
+	case math.IsInf(f, 1):
+		return hasher.hashUnicode("Infinity")
+	case math.IsInf(f, -1):
+		return hasher.hashUnicode("-Infinity")
+	}
+
+	// The JSON format uses the shortest representation of 32-bit floats (ex.
+	// 0.1 rather than 0.10000000149011612), which is read back as a 64-bit
+	// float.
+	if bitSize == 32 {
+		var err error
+		if f, err = strconv.ParseFloat(strconv.FormatFloat(f, 'g', -1, 32), 64); err != nil {
+			return nil, err
+		}
+	}
+	return hasher.hashFloat(f)
+}
+
+// hashJSONString returns the hash of a string, which must be valid UTF-8 when
+// messages are hashed like their JSON format.
+func (hasher *objectHasher) hashJSONString(s string) ([]byte, error) {
+	if hasher.protoJSONCompatible && !utf8.ValidString(s) {
+		return nil, fmt.Errorf("got a string with invalid UTF-8, which has no JSON equivalent: %q", s)
+	}
+	return hasher.hashUnicode(s)
+}
+
+// hashProtoJSONTimestamp returns the hash of a google.protobuf.Timestamp as it
+// appears in the JSON format, which is an RFC 3339 string in UTC with 0, 3, 6
+// or 9 fractional digits (ex. "1972-01-01T10:00:20.021Z").
+func (hasher *objectHasher) hashProtoJSONTimestamp(seconds, nanos int64) ([]byte, error) {
+	if seconds < minTimestampSeconds || seconds > maxTimestampSeconds {
+		return nil, fmt.Errorf("%w: a google.protobuf.Timestamp proto with out of range seconds: %d", ErrInvalidWellKnownType, seconds)
+	}
+	if nanos < 0 || nanos > maxTimestampNanos {
+		return nil, fmt.Errorf("%w: a google.protobuf.Timestamp proto with out of range nanos: %d", ErrInvalidWellKnownType, nanos)
+	}
+
+	s := time.Unix(seconds, nanos).UTC().Format("2006-01-02T15:04:05.000000000")
+	return hasher.hashUnicode(trimFractionalDigits(s) + "Z")
+}
+
+// hashProtoJSONDuration returns the hash of a valid google.protobuf.Duration
+// as it appears in the JSON format, which is a number of seconds with 0, 3, 6
+// or 9 fractional digits, followed by "s" (ex. "-1.500s").
+func (hasher *objectHasher) hashProtoJSONDuration(seconds, nanos int64) ([]byte, error) {
+	sign := ""
+	if seconds < 0 || nanos < 0 {
+		sign, seconds, nanos = "-", -seconds, -nanos
+	}
+
+	s := fmt.Sprintf("%s%d.%09d", sign, seconds, nanos)
+	return hasher.hashUnicode(trimFractionalDigits(s) + "s")
+}
+
+// trimFractionalDigits removes the trailing groups of three zeros from a
+// number with 9 fractional digits, along with the decimal point if there are
+// no fractional digits left.
+func trimFractionalDigits(s string) string {
+	s = strings.TrimSuffix(s, "000")
+	s = strings.TrimSuffix(s, "000")
+	return strings.TrimSuffix(s, ".000")
+}
+
+// isProtoJSONFieldMask checks if a message is a google.protobuf.FieldMask
+// that must be hashed like its JSON format. Otherwise, field masks are hashed
+// like any other message.
+func (hasher *objectHasher) isProtoJSONFieldMask(md protoreflect.MessageDescriptor) bool {
+	return hasher.protoJSONCompatible && md.FullName() == fieldMaskFullName
+}
+
+// hashProtoJSONFieldMask returns the hash of a google.protobuf.FieldMask as it
+// appears in the JSON format, which is a string of comma-separated paths whose
+// field names are converted to lowerCamelCase (ex. "user.displayName,photo").
+func (hasher *objectHasher) hashProtoJSONFieldMask(m protoreflect.Message) ([]byte, error) {
+	fd, err := wellKnownTypeField(m, "paths", protoreflect.StringKind)
+	if err != nil {
+		return nil, err
+	}
+	if !fd.IsList() {
+		return nil, fmt.Errorf("%w: a google.protobuf.FieldMask proto with a bad 'paths' field. Expected a repeated field, instead got %v", ErrInvalidWellKnownType, fd.Cardinality())
+	}
+
+	list := m.Get(fd).List()
+	paths := make([]string, list.Len())
+	for i := range paths {
+		path := list.Get(i).String()
+
+		// Only the paths that can be converted back from lowerCamelCase have a
+		// JSON equivalent.
+		camelCase := jsonCamelCase(path)
+		if !protoreflect.FullName(path).IsValid() || jsonSnakeCase(camelCase) != path {
+			return nil, fmt.Errorf("%w: a google.protobuf.FieldMask proto with a path that has no JSON equivalent: %q", ErrInvalidWellKnownType, path)
+		}
+		paths[i] = camelCase
+	}
+	return hasher.hashUnicode(strings.Join(paths, ","))
+}
+
+// jsonCamelCase converts a snake_case path to lowerCamelCase, like the JSON
+// names of fields.
+func jsonCamelCase(s string) string {
+	b := new(strings.Builder)
+	wasUnderscore := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '_' {
+			if wasUnderscore && 'a' <= c && c <= 'z' {
+				c -= 'a' - 'A'
+			}
+			b.WriteByte(c)
+		}
+		wasUnderscore = c == '_'
+	}
+	return b.String()
+}
+
+// jsonSnakeCase converts a lowerCamelCase path back to snake_case.
+func jsonSnakeCase(s string) string {
+	b := new(strings.Builder)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			b.WriteByte('_')
+			c += 'a' - 'A'
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// hashProtoJSONAny returns the hash of a google.protobuf.Any as it appears in
+// the JSON format, given the message it embeds: its type URL is under the
+// "@type" key, along with the fields of the embedded message, or along with
+// the JSON format of the embedded message under the "value" key when it is a
+// well-known type (ex. {"@type": "type.googleapis.com/google.protobuf.Duration",
+// "value": "1s"}).
+func (hasher *objectHasher) hashProtoJSONAny(typeURL string, embedded protoreflect.Message) ([]byte, error) {
+	var entries []hashEntry
+
+	typeKey, err := hasher.hashUnicode(anyTypeKey)
+	if err != nil {
+		return nil, err
+	}
+	typeHash, err := hasher.hashJSONString(typeURL)
+	if err != nil {
+		return nil, err
+	}
+	hasher.traceLabel(anyTypeKey)
+	entries = append(entries, hashEntry{khash: typeKey, vhash: typeHash})
+
+	md := embedded.Descriptor()
+	if _, ok := CheckWellKnownType(md); ok || hasher.isProtoJSONFieldMask(md) {
+		valueKey, err := hasher.hashUnicode("value")
+		if err != nil {
+			return nil, err
+		}
+		valueHash, err := hasher.hashStruct(embedded)
+		if err != nil {
+			return nil, withPathElement(err, "value")
+		}
+		hasher.traceLabel("value")
+		entries = append(entries, hashEntry{khash: valueKey, vhash: valueHash})
+	} else {
+		fieldEntries, err := hasher.structFieldEntries(embedded, nil)
+		if err != nil {
+			return nil, withPathElement(withMessageType(err, md), "value")
+		}
+		entries = append(entries, fieldEntries...)
+	}
+
+	sort.Sort(byKHash(entries))
+	return hasher.hashEntries(hasher.messageTypeIdentifier(), entries)
+}
@@ -0,0 +1,346 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/benlaurie/objecthash/go/objecthash"
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// testProtoMessages returns the descriptors of all the messages of the test
+// protos, except for those that cannot be hashed (see bad.proto).
+func testProtoMessages() []protoreflect.MessageDescriptor {
+	samples := []protoV1.Message{
+		&pb2_latest.Simple{}, &pb3_latest.Simple{},
+		&pb2_latest.FloatMessage{}, &pb3_latest.FloatMessage{},
+		&pb2_latest.Int32Message{}, &pb3_latest.Int32Message{},
+		&pb2_latest.IntMaps{}, &pb3_latest.IntMaps{},
+		&pb2_latest.PersonV1{}, &pb3_latest.PersonV1{},
+		&pb2_latest.MyFavoritePlanetsV1{}, &pb3_latest.MyFavoritePlanetsV1{},
+		&pb2_latest.KnownTypes{}, &pb3_latest.KnownTypes{},
+	}
+
+	var mds []protoreflect.MessageDescriptor
+	var add func(messages protoreflect.MessageDescriptors)
+	add = func(messages protoreflect.MessageDescriptors) {
+		for i := 0; i < messages.Len(); i++ {
+			if md := messages.Get(i); !md.IsMapEntry() {
+				mds = append(mds, md)
+				add(md.Messages())
+			}
+		}
+	}
+	for _, sample := range samples {
+		add(protoV1.MessageV2(sample).ProtoReflect().Descriptor().ParentFile().Messages())
+	}
+	return mds
+}
+
+// messageGenerator populates messages with random values, favoring the edge
+// cases of the JSON format (ex. 64-bit integers, NaN or negative zero).
+type messageGenerator struct {
+	rand *rand.Rand
+}
+
+// message returns a random message of the given type. Messages are only
+// nested up to the given depth.
+func (g *messageGenerator) message(md protoreflect.MessageDescriptor, depth int) protoreflect.Message {
+	switch md.FullName() {
+	case "google.protobuf.Any":
+		return g.any(depth).ProtoReflect()
+	case "google.protobuf.Duration":
+		seconds := g.rand.Int63n(2*maxDurationSeconds+1) - maxDurationSeconds
+		nanos := g.pick(0, g.rand.Int63n(maxDurationNanos+1), 500000000)
+		if seconds < 0 {
+			nanos = -nanos
+		}
+		return (&durationpb.Duration{Seconds: seconds, Nanos: int32(nanos)}).ProtoReflect()
+	case "google.protobuf.Timestamp":
+		seconds := g.rand.Int63n(maxTimestampSeconds-minTimestampSeconds+1) + minTimestampSeconds
+		nanos := g.pick(0, g.rand.Int63n(maxDurationNanos+1), 21000000)
+		return (&timestamppb.Timestamp{Seconds: seconds, Nanos: int32(nanos)}).ProtoReflect()
+	case "google.protobuf.Struct":
+		return g.jsonValue(depth).GetStructValue().ProtoReflect()
+	case "google.protobuf.ListValue":
+		return g.jsonValue(depth).GetListValue().ProtoReflect()
+	case "google.protobuf.Value":
+		return g.jsonValue(depth).ProtoReflect()
+	}
+
+	m := dynamicpb.NewMessage(md)
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+
+		// Only a single field of a oneof can be set, and messages are not
+		// nested any further past the maximum depth.
+		if od := fd.ContainingOneof(); od != nil && m.WhichOneof(od) != nil {
+			continue
+		}
+		if k := fd.Kind(); depth <= 0 && (k == protoreflect.MessageKind || (fd.IsMap() && fd.MapValue().Kind() == protoreflect.MessageKind)) {
+			continue
+		}
+		if g.rand.Intn(2) == 0 {
+			continue
+		}
+
+		switch {
+		case fd.IsList():
+			list := m.Mutable(fd).List()
+			for j := g.rand.Intn(4); j > 0; j-- {
+				list.Append(g.value(fd, m, depth))
+			}
+		case fd.IsMap():
+			mp := m.Mutable(fd).Map()
+			for j := g.rand.Intn(4); j > 0; j-- {
+				mp.Set(g.value(fd.MapKey(), m, depth).MapKey(), g.value(fd.MapValue(), m, depth))
+			}
+		default:
+			m.Set(fd, g.value(fd, m, depth))
+		}
+	}
+	return m
+}
+
+// value returns a random value of a singular field (or of an element of a
+// repeated field or a map) of the given message.
+func (g *messageGenerator) value(fd protoreflect.FieldDescriptor, m protoreflect.Message, depth int) protoreflect.Value {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		nested := g.message(fd.Message(), depth-1)
+		if _, ok := nested.(*dynamicpb.Message); !ok {
+			// Convert the generated well-known types to the type of the field.
+			b, err := proto.Marshal(nested.Interface())
+			if err != nil {
+				panic(err)
+			}
+			nested = m.NewField(fd).Message()
+			if err := proto.Unmarshal(b, nested.Interface()); err != nil {
+				panic(err)
+			}
+		}
+		return protoreflect.ValueOfMessage(nested)
+	case protoreflect.BoolKind:
+		return protoreflect.ValueOfBool(g.rand.Intn(2) == 0)
+	case protoreflect.EnumKind:
+		values := fd.Enum().Values()
+		if g.rand.Intn(5) == 0 {
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(g.rand.Int31n(100) + 100))
+		}
+		return protoreflect.ValueOfEnum(values.Get(g.rand.Intn(values.Len())).Number())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		return protoreflect.ValueOfInt32(int32(g.pick(0, -1, math.MinInt32, math.MaxInt32, int64(g.rand.Int31()))))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return protoreflect.ValueOfInt64(g.pick(0, -1, math.MinInt64, math.MaxInt64, 1<<53+1, g.rand.Int63()))
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		return protoreflect.ValueOfUint32(uint32(g.pick(0, math.MaxUint32, int64(g.rand.Uint32()))))
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		values := []uint64{0, math.MaxUint64, 1<<53 + 1, g.rand.Uint64()}
+		return protoreflect.ValueOfUint64(values[g.rand.Intn(len(values))])
+	case protoreflect.FloatKind:
+		values := []float32{0, float32(math.Copysign(0, -1)), 0.1, -1e-7, 3.4e38, float32(math.NaN()), float32(math.Inf(1)), float32(math.Inf(-1)), g.rand.Float32()}
+		return protoreflect.ValueOfFloat32(values[g.rand.Intn(len(values))])
+	case protoreflect.DoubleKind:
+		values := []float64{0, math.Copysign(0, -1), 0.1, -1e-7, 1e21, math.MaxFloat64, math.NaN(), math.Inf(1), math.Inf(-1), g.rand.NormFloat64()}
+		return protoreflect.ValueOfFloat64(values[g.rand.Intn(len(values))])
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(g.string())
+	case protoreflect.BytesKind:
+		b := make([]byte, g.rand.Intn(8))
+		g.rand.Read(b)
+		return protoreflect.ValueOfBytes(b)
+	default:
+		panic("unexpected kind: " + fd.Kind().String())
+	}
+}
+
+// pick returns one of the given values at random.
+func (g *messageGenerator) pick(values ...int64) int64 {
+	return values[g.rand.Intn(len(values))]
+}
+
+// string returns a random string, which may need escaping in JSON.
+func (g *messageGenerator) string() string {
+	values := []string{"", "foo", "snake_case", "你好", "ϓ", "\"quoted\"\n", " \x00", "@type"}
+	return values[g.rand.Intn(len(values))]
+}
+
+// jsonValue returns a random google.protobuf.Value, with finite numbers.
+func (g *messageGenerator) jsonValue(depth int) *structpb.Value {
+	switch n := g.rand.Intn(6); {
+	case n == 0:
+		return structpb.NewNullValue()
+	case n == 1:
+		return structpb.NewNumberValue(g.rand.NormFloat64())
+	case n == 2:
+		return structpb.NewStringValue(g.string())
+	case n == 3 || depth <= 0:
+		return structpb.NewBoolValue(g.rand.Intn(2) == 0)
+	case n == 4:
+		list := &structpb.ListValue{}
+		for i := g.rand.Intn(4); i > 0; i-- {
+			list.Values = append(list.Values, g.jsonValue(depth-1))
+		}
+		return structpb.NewListValue(list)
+	default:
+		s := &structpb.Struct{Fields: make(map[string]*structpb.Value)}
+		for i := g.rand.Intn(4); i > 0; i-- {
+			s.Fields[g.string()] = g.jsonValue(depth - 1)
+		}
+		return structpb.NewStructValue(s)
+	}
+}
+
+// any returns a random google.protobuf.Any, which embeds either a regular
+// message or a well-known type (including google.protobuf.FieldMask, whose JSON
+// format is special).
+func (g *messageGenerator) any(depth int) *anypb.Any {
+	var embedded proto.Message
+	switch g.rand.Intn(5) {
+	case 0:
+		return &anypb.Any{}
+	case 1:
+		embedded = &fieldmaskpb.FieldMask{Paths: []string{"string_field", "simple_field.int64_field"}}
+	case 2:
+		embedded = g.message((&timestamppb.Timestamp{}).ProtoReflect().Descriptor(), depth).Interface()
+	default:
+		// Dynamic messages are converted to the generated type, which can be
+		// resolved using the type URL.
+		b, err := proto.Marshal(g.message(protoV1.MessageV2(&pb3_latest.Simple{}).ProtoReflect().Descriptor(), depth).Interface())
+		if err != nil {
+			panic(err)
+		}
+		simple := protoV1.MessageV2(&pb3_latest.Simple{})
+		if err := proto.Unmarshal(b, simple); err != nil {
+			panic(err)
+		}
+		embedded = simple
+	}
+
+	a, err := anypb.New(embedded)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// TestProtoJSONCompatible checks that random messages of all the test protos
+// have the same hash as the ObjectHash of their JSON format.
+func TestProtoJSONCompatible(t *testing.T) {
+	hasher := NewHasher(ProtoJSONCompatible())
+	g := &messageGenerator{rand: rand.New(rand.NewSource(1))}
+
+	for _, md := range testProtoMessages() {
+		for i := 0; i < 50; i++ {
+			m := g.message(md, 3)
+
+			json, err := protojson.Marshal(m.Interface())
+			if err != nil {
+				t.Fatalf("Could not marshal %s to JSON: %v", md.FullName(), err)
+			}
+			expected, err := objecthash.CommonJSONHash(string(json))
+			if err != nil {
+				t.Fatalf("Could not hash %s: %v", json, err)
+			}
+
+			h, err := hasher.HashProto(m.Interface())
+			if err != nil {
+				t.Errorf("Got an error when hashing %s: %v", json, err)
+				continue
+			}
+			if !bytes.Equal(h, expected[:]) {
+				t.Errorf("Got the hash %x for %s, expected the ObjectHash of its JSON format: %x", h, json, expected)
+			}
+		}
+	}
+}
+
+// TestProtoJSONCompatibleWithoutJSONEquivalents checks that values without a
+// JSON equivalent are rejected.
+func TestProtoJSONCompatibleWithoutJSONEquivalents(t *testing.T) {
+	hasher := NewHasher(ProtoJSONCompatible())
+
+	testCases := []struct {
+		message  proto.Message
+		expected error
+	}{
+		{message: protoV1.MessageV2(&pb3_latest.Simple{StringField: "\xff"})},
+		{message: protoV1.MessageV2(&pb3_latest.StringMaps{StringToString: map[string]string{"\xff": "foo"}})},
+		{
+			message:  protoV1.MessageV2(&pb3_latest.KnownTypes{TimestampField: &timestamppb.Timestamp{Seconds: maxTimestampSeconds + 1}}),
+			expected: ErrInvalidWellKnownType,
+		},
+		{
+			message:  protoV1.MessageV2(&pb3_latest.KnownTypes{TimestampField: &timestamppb.Timestamp{Nanos: -1}}),
+			expected: ErrInvalidWellKnownType,
+		},
+		{
+			message:  protoV1.MessageV2(&pb3_latest.KnownTypes{ValueField: structpb.NewNumberValue(math.NaN())}),
+			expected: ErrInvalidWellKnownType,
+		},
+		{
+			message:  protoV1.MessageV2(&pb3_latest.KnownTypes{AnyField: mustAny(t, &fieldmaskpb.FieldMask{Paths: []string{"Bad_path"}})}),
+			expected: ErrInvalidWellKnownType,
+		},
+	}
+
+	for _, tc := range testCases {
+		if _, err := protojson.Marshal(tc.message); err == nil {
+			t.Errorf("Expected %v to not have a JSON format", tc.message)
+		}
+
+		_, err := hasher.HashProto(tc.message)
+		if err == nil {
+			t.Errorf("Expected an error when hashing %v", tc.message)
+			continue
+		}
+		if tc.expected != nil && !errors.Is(err, tc.expected) {
+			t.Errorf("Got the error %v when hashing %v, expected %v", err, tc.message, tc.expected)
+		}
+
+		if err := Validate(hasher, tc.message); err == nil {
+			t.Errorf("Expected %v to not be valid", tc.message)
+		}
+	}
+}
+
+// mustAny returns a google.protobuf.Any that embeds a message.
+func mustAny(t *testing.T, m proto.Message) *anypb.Any {
+	t.Helper()
+
+	a, err := anypb.New(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
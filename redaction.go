@@ -0,0 +1,207 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// redactedMarker is the prefix used by ObjectHash for representing redacted
+// values.
+const redactedMarker = "**REDACTED**"
+
+// RedactedField is the proof material for a field that has been redacted from
+// a message. It contains the ObjectHash of the value of the field, which
+// stands in for the value itself when calculating the ObjectHash of the
+// message.
+//
+// This is the equivalent of ObjectHash's redaction scheme, where a redacted
+// value is replaced by its hash (prefixed with "**REDACTED**").
+type RedactedField struct {
+	// The path of the field (ex. "a.b.c").
+	Path string
+
+	// The ObjectHash of the value of the field.
+	Hash []byte
+}
+
+func (f RedactedField) String() string {
+	return fmt.Sprintf("%s: %s%x", f.Path, redactedMarker, f.Hash)
+}
+
+// Redact returns a copy of a message whose fields at the given paths are
+// cleared, along with the proof material needed for calculating the ObjectHash
+// of the original message from the redacted one (see HashRedacted).
+//
+// Paths are made of field names separated by dots, like the paths of a
+// google.protobuf.FieldMask. They can refer to fields nested within singular
+// message fields, but not to the elements of repeated fields or maps, nor to
+// the fields of well-known types. Fields that are already unset are left as
+// they are, and do not have any proof material.
+//
+// The hasher must be one returned by NewHasher, and the same hasher options
+// must be used for calculating the ObjectHash of the redacted message.
+func Redact(hasher ProtoHasher, pb proto.Message, paths []string) (proto.Message, []RedactedField, error) {
+	oh, ok := hasher.(*objectHasher)
+	if !ok {
+		return nil, nil, fmt.Errorf("redaction is not supported by %T", hasher)
+	}
+	if pb == nil || !pb.ProtoReflect().IsValid() {
+		return nil, nil, errors.New("cannot redact the fields of a nil message")
+	}
+
+	// Make sure that the message can be hashed at all.
+	if _, err := oh.HashProto(pb); err != nil {
+		return nil, nil, err
+	}
+
+	redacted := proto.Clone(pb)
+	if err := oh.clearIgnoredFields(redacted.ProtoReflect()); err != nil {
+		return nil, nil, err
+	}
+	tree, err := newFieldPathTree(redacted.ProtoReflect().Descriptor(), paths)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields []RedactedField
+	if err = oh.redactFields(redacted.ProtoReflect(), tree, "", &fields); err != nil {
+		return nil, nil, err
+	}
+	return redacted, fields, nil
+}
+
+// RedactFieldMask is like Redact, but it takes the paths of the fields to be
+// redacted from a google.protobuf.FieldMask.
+func RedactFieldMask(hasher ProtoHasher, pb proto.Message, mask *fieldmaskpb.FieldMask) (proto.Message, []RedactedField, error) {
+	return Redact(hasher, pb, mask.GetPaths())
+}
+
+// redactFields clears the fields of a message that are part of a field path
+// tree, and appends their proof material to fields.
+func (hasher *objectHasher) redactFields(m protoreflect.Message, tree *fieldPathTree, prefix string, fields *[]RedactedField) error {
+	fds := m.Descriptor().Fields()
+	for i := 0; i < fds.Len(); i++ {
+		fd := fds.Get(i)
+
+		child := tree.child(fd)
+		if child == nil || hasher.isUnset(m, fd) {
+			continue
+		}
+		path := prefix + string(fd.Name())
+
+		// Ignored fields do not affect the ObjectHash, so they do not need any
+		// proof material.
+		if annotationsOf(fd).ignore {
+			m.Clear(fd)
+			continue
+		}
+
+		if !child.isLeaf() {
+			if err := hasher.redactFields(m.Mutable(fd).Message(), child, path+".", fields); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry, err := hasher.hashStructField(fd, m.Get(fd))
+		if err != nil {
+			return err
+		}
+		*fields = append(*fields, RedactedField{Path: path, Hash: entry.vhash})
+		m.Clear(fd)
+	}
+	return nil
+}
+
+// HashRedacted returns the ObjectHash of the original message of a redacted
+// message (see Redact), given the proof material of its redacted fields.
+//
+// This makes it possible to verify the ObjectHash of a message without seeing
+// the values of its redacted fields. The redacted fields must be unset in the
+// redacted message.
+//
+// The hasher must be one returned by NewHasher, with the same options as the
+// one used for redacting the message.
+func HashRedacted(hasher ProtoHasher, redacted proto.Message, fields []RedactedField) ([]byte, error) {
+	oh, ok := hasher.(*objectHasher)
+	if !ok {
+		return nil, fmt.Errorf("redaction is not supported by %T", hasher)
+	}
+	if redacted == nil || !redacted.ProtoReflect().IsValid() {
+		return nil, errors.New("cannot calculate the hash of a nil redacted message")
+	}
+
+	hashes := make(map[string][]byte, len(fields))
+	paths := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if _, ok := hashes[f.Path]; ok {
+			return nil, fmt.Errorf("got multiple redacted fields with the path %q", f.Path)
+		}
+		if f.Hash == nil {
+			return nil, fmt.Errorf("got a redacted field without a hash: %q", f.Path)
+		}
+		hashes[f.Path] = f.Hash
+		paths = append(paths, f.Path)
+	}
+
+	// The values of redacted fields are unknown, so there cannot be any other
+	// redacted fields within them.
+	for _, path := range paths {
+		for i := strings.LastIndex(path, "."); i >= 0; i = strings.LastIndex(path[:i], ".") {
+			if _, ok := hashes[path[:i]]; ok {
+				return nil, fmt.Errorf("got a redacted field within another redacted field: %q", path)
+			}
+		}
+	}
+
+	tree, err := newFieldPathTree(redacted.ProtoReflect().Descriptor(), paths)
+	if err != nil {
+		return nil, err
+	}
+
+	return oh.hashProto(redacted, oh.redactedFieldsHook(tree, hashes, ""))
+}
+
+// redactedFieldsHook returns a hook that uses the hashes of redacted fields in
+// place of their values (see fieldHook).
+func (hasher *objectHasher) redactedFieldsHook(tree *fieldPathTree, hashes map[string][]byte, prefix string) fieldHook {
+	return func(m protoreflect.Message, fd protoreflect.FieldDescriptor) ([]byte, bool, error) {
+		child := tree.child(fd)
+		if child == nil {
+			return nil, false, nil
+		}
+		path := prefix + string(fd.Name())
+
+		if child.isLeaf() {
+			if !hasher.isUnset(m, fd) {
+				return nil, false, fmt.Errorf("got a redacted field which is set: %q", path)
+			}
+			return hashes[path], true, nil
+		}
+
+		if hasher.isUnset(m, fd) {
+			return nil, false, fmt.Errorf("got a redacted field within an unset field: %q", path)
+		}
+		h, err := hasher.hashStructWithHook(m.Get(fd).Message(), hasher.redactedFieldsHook(child, hashes, path+"."))
+		return h, true, err
+	}
+}
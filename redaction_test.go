@@ -0,0 +1,196 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	timestamp_pb "github.com/golang/protobuf/ptypes/timestamp"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// TestRedaction checks that the ObjectHash of a message can be calculated
+// from its redacted version.
+func TestRedaction(t *testing.T) {
+	hashers := []ProtoHasher{
+		NewHasher(),
+		NewHasher(FieldNamesAsKeys(), EnumsAsStrings()),
+		NewHasher(MessageIdentifier(`m`)),
+		NewHasher(HMACKey([]byte("secret"))),
+	}
+
+	person := &pb3_latest.PersonV3{
+		Id:         1,
+		Age:        42,
+		Profession: "Doctor",
+		Name: &pb3_latest.PersonV3_StructuredName{StructuredName: &pb3_latest.PersonV3_NameV3{
+			First: "Alice",
+			Last:  "Smith",
+		}},
+		Children: []*pb3_latest.PersonV3{{Id: 2}},
+	}
+
+	testCases := []struct {
+		message               protoV1.Message
+		paths                 []string
+		expectedRedactedPaths []string
+	}{
+		{message: person, paths: nil, expectedRedactedPaths: nil},
+		{message: person, paths: []string{"age"}, expectedRedactedPaths: []string{"age"}},
+		{message: person, paths: []string{"profession", "age"}, expectedRedactedPaths: []string{"age", "profession"}},
+		{message: person, paths: []string{"children"}, expectedRedactedPaths: []string{"children"}},
+		{message: person, paths: []string{"structured_name.last"}, expectedRedactedPaths: []string{"structured_name.last"}},
+		{message: person, paths: []string{"structured_name.last", "structured_name.first"}, expectedRedactedPaths: []string{"structured_name.first", "structured_name.last"}},
+		{message: person, paths: []string{"structured_name.last", "structured_name"}, expectedRedactedPaths: []string{"structured_name"}},
+
+		// Unset fields are not redacted.
+		{message: person, paths: []string{"full_name", "age"}, expectedRedactedPaths: []string{"age"}},
+		{message: &pb3_latest.PersonV3{Id: 1}, paths: []string{"structured_name.first"}, expectedRedactedPaths: nil},
+
+		// Well-known types are redacted as a whole.
+		{
+			message:               &pb3_latest.KnownTypes{TimestampField: &timestamp_pb.Timestamp{Seconds: 1}},
+			paths:                 []string{"timestamp_field"},
+			expectedRedactedPaths: []string{"timestamp_field"},
+		},
+	}
+
+	for _, hasher := range hashers {
+		for _, tc := range testCases {
+			original := protoV1.MessageV2(tc.message)
+			expected, err := hasher.HashProto(original)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			redacted, fields, err := Redact(hasher, original, tc.paths)
+			if err != nil {
+				t.Fatalf("Redacting %v from %v returned an error: %v", tc.paths, tc.message, err)
+			}
+
+			if len(fields) != len(tc.expectedRedactedPaths) {
+				t.Fatalf("Redacting %v from %v returned %v. Expected the redacted paths to be %v.", tc.paths, tc.message, fields, tc.expectedRedactedPaths)
+			}
+			for i, f := range fields {
+				if f.Path != tc.expectedRedactedPaths[i] {
+					t.Errorf("Redacting %v from %v returned %v. Expected the redacted paths to be %v.", tc.paths, tc.message, fields, tc.expectedRedactedPaths)
+				}
+			}
+
+			// The original message must not be modified.
+			if !proto.Equal(original, protoV1.MessageV2(tc.message)) || proto.Equal(original, redacted) != (len(fields) == 0) {
+				t.Errorf("Redacting %v from %v returned the wrong redacted message: %v", tc.paths, tc.message, redacted)
+			}
+
+			h, err := HashRedacted(hasher, redacted, fields)
+			if err != nil {
+				t.Fatalf("Hashing the redacted version of %v returned an error: %v", tc.message, err)
+			}
+			if !bytes.Equal(h, expected) {
+				t.Errorf("Got the wrong objecthash for the redacted version of %v (%v).\nActual:   %x\nExpected: %x\n", tc.message, fields, h, expected)
+			}
+		}
+	}
+}
+
+// TestRedactFieldMask checks that fields can be redacted using a FieldMask.
+func TestRedactFieldMask(t *testing.T) {
+	hasher := NewHasher()
+	person := protoV1.MessageV2(&pb3_latest.PersonV2{Id: 1, Name: "Alice", Age: 42})
+
+	redacted, fields, err := RedactFieldMask(hasher, person, &fieldmaskpb.FieldMask{Paths: []string{"name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := protoV1.MessageV2(&pb3_latest.PersonV2{Id: 1, Age: 42})
+	if !proto.Equal(redacted, expected) {
+		t.Errorf("Got the wrong redacted message. Actual: %v, Expected: %v", redacted, expected)
+	}
+
+	nameHash, _ := hasher.(*objectHasher).hashUnicode("Alice")
+	if len(fields) != 1 || fields[0].Path != "name" || !bytes.Equal(fields[0].Hash, nameHash) {
+		t.Errorf("Got the wrong redacted fields: %v", fields)
+	}
+
+	expectedString := "name: **REDACTED**" + hex.EncodeToString(nameHash)
+	if s := fields[0].String(); s != expectedString {
+		t.Errorf("Got the wrong string representation of a redacted field. Actual: %q, Expected: %q", s, expectedString)
+	}
+}
+
+// TestRedactionWithBadInputs tests how Redact and HashRedacted handle bad
+// inputs.
+func TestRedactionWithBadInputs(t *testing.T) {
+	hasher := NewHasher()
+	person := protoV1.MessageV2(&pb3_latest.PersonV3{
+		Id:       1,
+		Name:     &pb3_latest.PersonV3_StructuredName{StructuredName: &pb3_latest.PersonV3_NameV3{First: "Alice"}},
+		Children: []*pb3_latest.PersonV3{{Id: 2}},
+	})
+	knownTypes := protoV1.MessageV2(&pb3_latest.KnownTypes{TimestampField: &timestamp_pb.Timestamp{Seconds: 1}})
+
+	badPaths := []struct {
+		message proto.Message
+		path    string
+	}{
+		{message: person, path: ""},
+		{message: person, path: "unknown"},
+		{message: person, path: "id.value"},
+		{message: person, path: "children.id"},
+		{message: person, path: "structured_name..first"},
+		{message: knownTypes, path: "timestamp_field.seconds"},
+	}
+	for _, tc := range badPaths {
+		if _, _, err := Redact(hasher, tc.message, []string{tc.path}); err == nil {
+			t.Errorf("Redacting %q should have returned an error.", tc.path)
+		}
+	}
+
+	if _, _, err := Redact(hasher, nil, []string{"id"}); err == nil {
+		t.Error("Redacting the fields of a nil message should have returned an error.")
+	}
+	if _, _, err := Redact(struct{ ProtoHasher }{hasher}, person, []string{"id"}); err == nil {
+		t.Error("Redacting using an unsupported ProtoHasher should have returned an error.")
+	}
+
+	redacted, fields, err := Redact(hasher, person, []string{"id", "structured_name.first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	badFields := map[string][]RedactedField{
+		"Duplicate fields":            append(fields, fields[0]),
+		"Field within redacted field": append(fields, RedactedField{Path: "structured_name.first.foo", Hash: fields[0].Hash}),
+		"Missing hash":                {{Path: "id"}},
+		"Set field":                   {{Path: "children", Hash: fields[0].Hash}, fields[0], fields[1]},
+	}
+	for name, bad := range badFields {
+		if _, err := HashRedacted(hasher, redacted, bad); err == nil {
+			t.Errorf("%s: HashRedacted should have returned an error for %v.", name, bad)
+		}
+	}
+
+	unset := protoV1.MessageV2(&pb3_latest.PersonV3{Id: 1})
+	if _, err := HashRedacted(hasher, unset, []RedactedField{{Path: "structured_name.last", Hash: fields[0].Hash}}); err == nil {
+		t.Error("HashRedacted should have returned an error for a redacted field within an unset field.")
+	}
+}
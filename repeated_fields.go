@@ -0,0 +1,94 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"sort"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// repeatedFieldMode is how the elements of a repeated field get hashed. The
+// values match those of the objecthash.RepeatedFieldHashing enum.
+type repeatedFieldMode int
+
+const (
+	repeatedFieldAsList repeatedFieldMode = iota
+	repeatedFieldAsSet
+	repeatedFieldAsMultiset
+)
+
+// repeatedFieldMode returns how the elements of a repeated field get hashed.
+// The options of the hasher take precedence over the field's annotations.
+func (hasher *objectHasher) repeatedFieldMode(fd protoreflect.FieldDescriptor) repeatedFieldMode {
+	if mode, ok := hasher.repeatedFieldModes[fd.FullName()]; ok {
+		return mode
+	}
+
+	// Sets and multisets do not have a JSON equivalent.
+	if hasher.protoJSONCompatible {
+		return repeatedFieldAsList
+	}
+	return annotationsOf(fd).repeated
+}
+
+// failIfNotRepeatedField returns an error if a field that is not a repeated
+// field is meant to be hashed as a set or a multiset.
+func (hasher *objectHasher) failIfNotRepeatedField(fd protoreflect.FieldDescriptor) error {
+	if !fd.IsList() && hasher.repeatedFieldMode(fd) != repeatedFieldAsList {
+		return ErrNotRepeatedField
+	}
+	return nil
+}
+
+// hashSet returns the hash of a set, or of a multiset when duplicates are
+// kept, given the hashes of its elements in any order.
+//
+// Sets are hashed like ObjectHash sets: the hashes of their elements are
+// sorted and deduplicated. Multisets are hashed the same way, except that the
+// duplicates are kept, so a multiset without duplicates has the same hash as
+// the equivalent set.
+func (hasher *objectHasher) hashSet(hashes [][]byte, unique bool) ([]byte, error) {
+	b := bytes.Join(sortedSetHashes(hashes, unique), nil)
+
+	d, err := hasher.digest(setIdentifier, b)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasher.tracer != nil {
+		hasher.tracer.set(b, d, len(hashes), unique)
+	}
+	return d, nil
+}
+
+// sortedSetHashes returns a sorted copy of the hashes of the elements of a
+// set, without duplicates when unique is true.
+func sortedSetHashes(hashes [][]byte, unique bool) [][]byte {
+	sorted := append([][]byte{}, hashes...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	if !unique {
+		return sorted
+	}
+
+	deduplicated := sorted[:0]
+	for i, h := range sorted {
+		if i == 0 || !bytes.Equal(h, sorted[i-1]) {
+			deduplicated = append(deduplicated, h)
+		}
+	}
+	return deduplicated
+}
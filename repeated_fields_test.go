@@ -0,0 +1,296 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// setHash returns the expected hash of a set of strings, whose element hashes
+// must be given in sorted order.
+func setHash(strings ...string) []byte {
+	b := new(bytes.Buffer)
+	for _, s := range strings {
+		h := sha256.Sum256([]byte(unicodeIndentifier + s))
+		b.Write(h[:])
+	}
+	h := sha256.Sum256(append([]byte(setIdentifier), b.Bytes()...))
+	return h[:]
+}
+
+// repetitiveHash returns the expected hash of a Repetitive message whose only
+// field is string_field, given the hash of the field's value.
+func repetitiveHash(vhash []byte) []byte {
+	khash := sha256.Sum256([]byte(intIdentifier + "25"))
+	h := sha256.Sum256(append(append([]byte(mapIdentifier), khash[:]...), vhash...))
+	return h[:]
+}
+
+// sortedByHash sorts strings by the hashes of their unicode values.
+func sortedByHash(strings ...string) []string {
+	hash := func(s string) []byte {
+		h := sha256.Sum256([]byte(unicodeIndentifier + s))
+		return h[:]
+	}
+	sorted := append([]string{}, strings...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(hash(sorted[i]), hash(sorted[j])) < 0 })
+	return sorted
+}
+
+// TestRepeatedFieldsAsSets checks the hashes of repeated fields hashed as sets
+// and multisets.
+func TestRepeatedFieldsAsSets(t *testing.T) {
+	sets := NewHasher(RepeatedFieldsAsSets("schema.proto3.Repetitive.string_field"))
+	multisets := NewHasher(RepeatedFieldsAsMultisets("schema.proto3.Repetitive.string_field"))
+
+	testCases := []struct {
+		hasher   ProtoHasher
+		values   []string
+		expected []byte
+	}{
+		{hasher: sets, values: []string{"foo", "bar"}, expected: setHash(sortedByHash("foo", "bar")...)},
+		{hasher: sets, values: []string{"bar", "foo", "bar"}, expected: setHash(sortedByHash("foo", "bar")...)},
+		{hasher: multisets, values: []string{"foo", "bar"}, expected: setHash(sortedByHash("foo", "bar")...)},
+		{hasher: multisets, values: []string{"bar", "foo", "bar"}, expected: setHash(sortedByHash("foo", "bar", "bar")...)},
+		{hasher: multisets, values: []string{"bar", "bar", "foo"}, expected: setHash(sortedByHash("foo", "bar", "bar")...)},
+	}
+
+	for _, tc := range testCases {
+		h, err := tc.hasher.HashProto(protoV1.MessageV2(&pb3_latest.Repetitive{StringField: tc.values}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := repetitiveHash(tc.expected); !bytes.Equal(h, expected) {
+			t.Errorf("Got the wrong hash for %q with %v: %x, expected %x", tc.values, tc.hasher, h, expected)
+		}
+	}
+
+	// Other fields are still hashed as lists.
+	m := protoV1.MessageV2(&pb3_latest.Repetitive{Int32Field: []int32{1, 2}})
+	h1, err := sets.HashProto(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := NewHasher().HashProto(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h1, h2) {
+		t.Error("A repeated field that is not hashed as a set got a different hash.")
+	}
+}
+
+// TestRepeatedFieldsAsSetsWithTreesDiffsAndProofs checks that the hash trees,
+// the diffs and the field proofs of messages account for sets.
+func TestRepeatedFieldsAsSetsWithTreesDiffsAndProofs(t *testing.T) {
+	hasher := NewHasher(RepeatedFieldsAsMultisets("schema.proto3.Repetitive.string_field"))
+	a := protoV1.MessageV2(&pb3_latest.Repetitive{StringField: []string{"foo", "bar", "bar"}})
+	b := protoV1.MessageV2(&pb3_latest.Repetitive{StringField: []string{"baz", "bar", "foo"}})
+
+	tree, err := HashProtoTree(hasher, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set := tree.Children[0]; set.TypeIdentifier != setIdentifier || len(set.Children) != 3 {
+		t.Errorf("Got the wrong hash tree:\n%s", tree)
+	}
+
+	paths, err := Diff(hasher, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"string_field[0]", "string_field[2]"}; !reflect.DeepEqual(paths, expected) {
+		t.Errorf("Got the wrong diff: %q, expected %q", paths, expected)
+	}
+
+	root, err := hasher.HashProto(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := ProveField(hasher, b, "string_field[2]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFieldProof(hasher, b.ProtoReflect().Descriptor(), root, proof, "foo"); err != nil {
+		t.Errorf("Could not verify the proof of an element of a set: %v", err)
+	}
+	if err := VerifyFieldProof(hasher, b.ProtoReflect().Descriptor(), root, proof, "qux"); err == nil {
+		t.Error("Verified the proof of an element of a set with the wrong value.")
+	}
+}
+
+// annotatedMessage returns an empty message with a repeated field ("tags") and
+// a singular field ("name"), where the given fields are annotated with one of
+// the field options declared within a file (ex. objecthash/options.proto),
+// which the message's file imports.
+func annotatedMessage(t *testing.T, options protoreflect.FileDescriptor, option protoreflect.Name, value uint64, fields ...string) *dynamicpb.Message {
+	t.Helper()
+
+	xd := options.Extensions().ByName(option)
+	annotated := func(name string) *descriptorpb.FieldOptions {
+		opts := new(descriptorpb.FieldOptions)
+		for _, f := range fields {
+			if f == name {
+				opts.ProtoReflect().SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, xd.Number(), protowire.VarintType), value))
+			}
+		}
+		return opts
+	}
+
+	files := new(protoregistry.Files)
+	for _, f := range []protoreflect.FileDescriptor{descriptorpb.File_google_protobuf_descriptor_proto, options} {
+		if err := files.RegisterFile(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       proto.String("annotated.proto"),
+		Package:    proto.String("test"),
+		Dependency: []string{options.Path()},
+		Syntax:     proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Annotated"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{Name: proto.String("tags"), Number: proto.Int32(1), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(), Options: annotated("tags")},
+				{Name: proto.String("name"), Number: proto.Int32(2), Type: descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(), Label: descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(), Options: annotated("name")},
+			},
+		}},
+	}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dynamicpb.NewMessage(fd.Messages().Get(0))
+}
+
+// foreignOptionsFile returns a copy of objecthash/options.proto within another
+// package, whose options have the same numbers as the original ones.
+func foreignOptionsFile(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+
+	file := protodesc.ToFileDescriptorProto(optionsFile)
+	file.Name = proto.String("foreign/options.proto")
+	file.Package = proto.String("foreign")
+	file.Options = nil
+	for _, x := range file.GetExtension() {
+		if x.TypeName != nil {
+			x.TypeName = proto.String(".foreign.RepeatedFieldHashing")
+		}
+	}
+
+	fd, err := protodesc.NewFile(file, protoregistry.GlobalFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd
+}
+
+// TestRepeatedFieldAnnotations checks that the (objecthash.repeated) option of
+// fields is used, unless it is overridden by the hasher.
+func TestRepeatedFieldAnnotations(t *testing.T) {
+	tags := func(m *dynamicpb.Message, values ...string) *dynamicpb.Message {
+		m = m.New().(*dynamicpb.Message)
+		list := m.Mutable(m.Descriptor().Fields().ByName("tags")).List()
+		for _, v := range values {
+			list.Append(protoreflect.ValueOfString(v))
+		}
+		return m
+	}
+	m := annotatedMessage(t, optionsFile, repeatedOptionName.Name(), uint64(repeatedFieldAsSet), "tags")
+
+	h1, err := NewHasher().HashProto(tags(m, "foo", "bar", "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := NewHasher().HashProto(tags(m, "bar", "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h1, h2) {
+		t.Error("An annotated repeated field was not hashed as a set.")
+	}
+
+	multisets := NewHasher(RepeatedFieldsAsMultisets("test.Annotated.tags"))
+	h1, err = multisets.HashProto(tags(m, "foo", "bar", "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err = multisets.HashProto(tags(m, "bar", "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(h1, h2) {
+		t.Error("The option of the hasher did not take precedence over the annotation of a field.")
+	}
+
+	// The same options declared within another package are not the
+	// (objecthash.repeated) option.
+	foreign := annotatedMessage(t, foreignOptionsFile(t), repeatedOptionName.Name(), uint64(repeatedFieldAsSet), "tags")
+	h1, err = NewHasher().HashProto(tags(foreign, "foo", "bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err = NewHasher().HashProto(tags(foreign, "bar", "foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(h1, h2) {
+		t.Error("A repeated field annotated with a foreign option was hashed as a set.")
+	}
+}
+
+// TestRepeatedFieldAnnotationsOfSingularFields checks that singular fields
+// annotated with the (objecthash.repeated) option cannot be hashed, whether
+// they're set or not.
+func TestRepeatedFieldAnnotationsOfSingularFields(t *testing.T) {
+	m := annotatedMessage(t, optionsFile, repeatedOptionName.Name(), uint64(repeatedFieldAsSet), "tags", "name")
+
+	if _, err := NewHasher().HashProto(m); !errors.Is(err, ErrNotRepeatedField) {
+		t.Errorf("Expected an ErrNotRepeatedField error for an annotated singular field, instead got: %v", err)
+	}
+	if err := Validate(NewHasher(), m); !errors.Is(err, ErrNotRepeatedField) {
+		t.Errorf("Expected an ErrNotRepeatedField error when validating an annotated singular field, instead got: %v", err)
+	}
+
+	problems, err := LintMessage(NewHasher(), m.Descriptor())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSchemaProblems(t, problems, map[protoreflect.FullName]error{
+		"test.Annotated.name": ErrNotRepeatedField,
+	}, "test.Annotated.name")
+
+	// Lists are allowed to be annotated as lists.
+	m = annotatedMessage(t, optionsFile, repeatedOptionName.Name(), uint64(repeatedFieldAsList), "tags", "name")
+	if _, err := NewHasher().HashProto(m); err != nil {
+		t.Errorf("Got an error when hashing a singular field annotated as a list: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// SchemaProblem is a problem with a message or a field of a proto schema,
+// which makes some of the messages of the schema impossible to hash reliably.
+type SchemaProblem struct {
+	// The full name of the message or field with the problem (ex.
+	// "example.Person" or "example.Person.name").
+	Name protoreflect.FullName
+
+	// The path of the file that defines the message or field.
+	File string
+
+	// The reason, which wraps one of the errors returned when hashing the
+	// messages of the schema (ex. ErrRequiredField).
+	Reason error
+}
+
+func (p SchemaProblem) String() string {
+	return fmt.Sprintf("%s: %s: %v", p.File, p.Name, p.Reason)
+}
+
+// LintSchema returns the problems with the messages defined in a
+// FileDescriptorSet (and with the messages they can contain), which would
+// make hashing some of their values fail.
+//
+// The set must be self-contained, meaning that it must include all of the
+// files imported by its files (ie. as produced by protoc's --include_imports
+// flag). Messages defined within the google.protobuf package are only checked
+// when they can be contained by other messages.
+//
+// The hasher must be one returned by NewHasher, since the options of the
+// hasher affect which messages can be hashed (ex. AnyResolver).
+//
+// The extensions of extendable messages are checked along with their other
+// fields when the hasher has an extension resolver that can list them, like a
+// protoregistry.Types (see ExtensionResolver). Extensions are not checked when
+// the resolver can only find them one by one.
+func LintSchema(hasher ProtoHasher, set *descriptorpb.FileDescriptorSet) ([]SchemaProblem, error) {
+	oh, ok := hasher.(*objectHasher)
+	if !ok {
+		return nil, fmt.Errorf("schema linting is not supported by %T", hasher)
+	}
+
+	files, err := protodesc.NewFiles(set)
+	if err != nil {
+		return nil, fmt.Errorf("could not build the descriptors of a FileDescriptorSet: %v", err)
+	}
+
+	l := linter{hasher: oh, seen: make(map[protoreflect.FullName]bool)}
+	for _, f := range set.GetFile() {
+		fd, err := files.FindFileByPath(f.GetName())
+		if err != nil {
+			return nil, err
+		}
+		if fd.Package() == wellKnownTypesPackage {
+			continue
+		}
+		l.lintMessages(fd.Messages())
+	}
+	return l.problems, nil
+}
+
+// LintMessage returns the problems with a message and with the messages it can
+// contain, which would make hashing some of its values fail (see LintSchema).
+//
+// The hasher must be one returned by NewHasher.
+func LintMessage(hasher ProtoHasher, md protoreflect.MessageDescriptor) ([]SchemaProblem, error) {
+	oh, ok := hasher.(*objectHasher)
+	if !ok {
+		return nil, fmt.Errorf("schema linting is not supported by %T", hasher)
+	}
+
+	l := linter{hasher: oh, seen: make(map[protoreflect.FullName]bool)}
+	l.lintMessage(md)
+	return l.problems, nil
+}
+
+// linter collects the problems of the messages of a schema (see LintSchema).
+type linter struct {
+	hasher   *objectHasher
+	seen     map[protoreflect.FullName]bool
+	problems []SchemaProblem
+}
+
+func (l *linter) report(d protoreflect.Descriptor, reason error) {
+	file := ""
+	if f := d.ParentFile(); f != nil {
+		file = f.Path()
+	}
+	l.problems = append(l.problems, SchemaProblem{Name: d.FullName(), File: file, Reason: reason})
+}
+
+// lintMessages lints messages along with their nested messages.
+func (l *linter) lintMessages(messages protoreflect.MessageDescriptors) {
+	for i := 0; i < messages.Len(); i++ {
+		md := messages.Get(i)
+		if md.IsMapEntry() {
+			continue
+		}
+		l.lintMessage(md)
+		l.lintMessages(md.Messages())
+	}
+}
+
+// lintMessage lints a message, followed by the types of its message fields
+// (including its extensions, see extensionFields).
+func (l *linter) lintMessage(md protoreflect.MessageDescriptor) {
+	if l.seen[md.FullName()] {
+		return
+	}
+	l.seen[md.FullName()] = true
+
+	// Well-known types are either supported or not as a whole.
+	if name, ok := CheckWellKnownType(md); ok {
+		if err := l.hasher.failIfUnsupportedWellKnownType(name); err != nil {
+			l.report(md, err)
+		}
+		return
+	}
+
+	extensions := l.extensionFields(md)
+	fields := md.Fields()
+	fds := make([]protoreflect.FieldDescriptor, 0, fields.Len()+len(extensions))
+	for i := 0; i < fields.Len(); i++ {
+		fds = append(fds, fields.Get(i))
+	}
+	fds = append(fds, extensions...)
+
+	var nested []protoreflect.MessageDescriptor
+	for _, fd := range fds {
+		if err := l.hasher.failIfUnsupportedField(fd); err != nil {
+			l.report(fd, err)
+		}
+		if err := l.hasher.failIfNotRepeatedField(fd); err != nil {
+			l.report(fd, err)
+		}
+
+		if fd.IsMap() {
+			fd = fd.MapValue()
+		}
+		if fd.Message() != nil {
+			nested = append(nested, fd.Message())
+		}
+	}
+
+	for _, m := range nested {
+		l.lintMessage(m)
+	}
+}
+
+// extensionRanger is implemented by the extension resolvers that can list the
+// extensions of a message, like protoregistry.Types.
+type extensionRanger interface {
+	RangeExtensionsByMessage(message protoreflect.FullName, f func(protoreflect.ExtensionType) bool)
+}
+
+// extensionFields returns the extensions of an extendable message which are
+// known to the extension resolver of the hasher, sorted by tag number. An
+// extendable message is reported as a problem when extensions are not
+// enabled.
+func (l *linter) extensionFields(md protoreflect.MessageDescriptor) []protoreflect.FieldDescriptor {
+	if !isExtendable(md) {
+		return nil
+	}
+	if l.hasher.extensionResolver == nil {
+		l.report(md, ErrExtendableMessage)
+		return nil
+	}
+
+	r, ok := l.hasher.extensionResolver.(extensionRanger)
+	if !ok {
+		return nil
+	}
+	var extensions []protoreflect.FieldDescriptor
+	r.RangeExtensionsByMessage(md.FullName(), func(xt protoreflect.ExtensionType) bool {
+		extensions = append(extensions, xt.TypeDescriptor())
+		return true
+	})
+	sort.Slice(extensions, func(i, j int) bool { return extensions[i].Number() < extensions[j].Number() })
+	return extensions
+}
@@ -0,0 +1,244 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"errors"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	empty_pb "github.com/golang/protobuf/ptypes/empty"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// checkSchemaProblems checks that the expected problems were found, in order.
+func checkSchemaProblems(t *testing.T, problems []SchemaProblem, expected map[protoreflect.FullName]error, order ...protoreflect.FullName) {
+	t.Helper()
+
+	if len(problems) != len(order) {
+		t.Fatalf("Got the wrong number of problems: %v", problems)
+	}
+	for i, p := range problems {
+		if p.Name != order[i] || !errors.Is(p.Reason, expected[p.Name]) {
+			t.Errorf("Got the wrong problem: %v. Expected %s: %v", p, order[i], expected[order[i]])
+		}
+	}
+}
+
+// TestLintSchema checks that LintSchema finds the problems of the messages of
+// a FileDescriptorSet.
+func TestLintSchema(t *testing.T) {
+	hasher := NewHasher()
+
+	problems, err := LintSchema(hasher, fileDescriptorSet(protoV1.MessageV2(&pb2_latest.BadWithDefaults{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSchemaProblems(t, problems, map[protoreflect.FullName]error{
+		"schema.proto2.BadWithDefaults.text":     ErrExplicitDefault,
+		"schema.proto2.BadWithRequirements.text": ErrRequiredField,
+		"schema.proto2.BadWithExtensions":        ErrExtendableMessage,
+	}, "schema.proto2.BadWithDefaults.text", "schema.proto2.BadWithRequirements.text", "schema.proto2.BadWithExtensions")
+
+	for _, p := range problems {
+		if p.File != "bad.proto" {
+			t.Errorf("Got the wrong file for %v", p)
+		}
+	}
+
+	// Extendable messages are fine when extensions are enabled.
+	problems, err = LintSchema(NewHasher(ExtensionResolver(nil)), fileDescriptorSet(protoV1.MessageV2(&pb2_latest.BadWithDefaults{})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSchemaProblems(t, problems, map[protoreflect.FullName]error{
+		"schema.proto2.BadWithDefaults.text":     ErrExplicitDefault,
+		"schema.proto2.BadWithRequirements.text": ErrRequiredField,
+	}, "schema.proto2.BadWithDefaults.text", "schema.proto2.BadWithRequirements.text")
+
+	// Messages from the google.protobuf package are only checked when they are
+	// used by other messages.
+	emptyFile := protodesc.ToFileDescriptorProto(protoV1.MessageV2(&empty_pb.Empty{}).ProtoReflect().Descriptor().ParentFile())
+	descriptorFile := protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto)
+	set := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{emptyFile, descriptorFile}}
+
+	problems, err = LintSchema(hasher, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems with the files of the google.protobuf package, instead got: %v", problems)
+	}
+
+	set.File = append(set.File, &descriptorpb.FileDescriptorProto{
+		Name:       protoV1.String("uses_google_protobuf.proto"),
+		Package:    protoV1.String("example"),
+		Dependency: []string{emptyFile.GetName(), descriptorFile.GetName()},
+		Syntax:     protoV1.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: protoV1.String("UsesGoogleProtobuf"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				{
+					Name:     protoV1.String("empty"),
+					Number:   protoV1.Int32(1),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: protoV1.String(".google.protobuf.Empty"),
+				},
+				{
+					Name:     protoV1.String("options"),
+					Number:   protoV1.Int32(2),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+					TypeName: protoV1.String(".google.protobuf.EnumValueOptions"),
+				},
+			},
+		}},
+	})
+
+	problems, err = LintSchema(hasher, set)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The problems of descriptor.proto depend on the version of the proto
+	// library, so only some of them are checked.
+	expected := map[protoreflect.FullName]error{
+		"google.protobuf.Empty":            ErrUnsupportedType,
+		"google.protobuf.EnumValueOptions": ErrExtendableMessage,
+	}
+	for _, p := range problems {
+		if reason, ok := expected[p.Name]; ok && errors.Is(p.Reason, reason) {
+			delete(expected, p.Name)
+		}
+	}
+	if len(expected) > 0 {
+		t.Errorf("Expected problems with %v, instead got: %v", expected, problems)
+	}
+}
+
+// TestLintMessage checks that LintMessage finds the problems of a message and
+// of the messages it can contain.
+func TestLintMessage(t *testing.T) {
+	testCases := []struct {
+		hasher   ProtoHasher
+		message  protoV1.Message
+		expected []protoreflect.FullName
+		reasons  map[protoreflect.FullName]error
+	}{
+		{hasher: NewHasher(), message: &pb3_latest.Simple{}},
+		{hasher: NewHasher(), message: &pb3_latest.StringMaps{}},
+		{hasher: NewHasher(AnyResolver(nil)), message: &pb3_latest.KnownTypes{}},
+		{hasher: NewHasher(AnyAsTypeURLAndBytes()), message: &pb3_latest.KnownTypes{}},
+		{
+			hasher:   NewHasher(),
+			message:  &pb3_latest.KnownTypes{},
+			expected: []protoreflect.FullName{"google.protobuf.Any"},
+			reasons:  map[protoreflect.FullName]error{"google.protobuf.Any": ErrUnsupportedType},
+		},
+		{
+			hasher:   NewHasher(),
+			message:  &pb2_latest.BadWithRequirements{},
+			expected: []protoreflect.FullName{"schema.proto2.BadWithRequirements.text"},
+			reasons:  map[protoreflect.FullName]error{"schema.proto2.BadWithRequirements.text": ErrRequiredField},
+		},
+		{hasher: NewHasher(RequiredFieldsAsOrdinaryFields()), message: &pb2_latest.BadWithRequirements{}},
+		{hasher: NewHasher(ExplicitDefaultsAsUnset()), message: &pb2_latest.BadWithDefaults{}},
+	}
+
+	for _, tc := range testCases {
+		problems, err := LintMessage(tc.hasher, protoV1.MessageV2(tc.message).ProtoReflect().Descriptor())
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSchemaProblems(t, problems, tc.reasons, tc.expected...)
+	}
+
+	if _, err := LintMessage(struct{ ProtoHasher }{NewHasher()}, protoV1.MessageV2(&empty_pb.Empty{}).ProtoReflect().Descriptor()); err == nil {
+		t.Error("Expected an error for an unsupported hasher")
+	}
+}
+
+// TestLintExtensions checks that the extensions of extendable messages are
+// linted like their other fields when the extension resolver can list them.
+func TestLintExtensions(t *testing.T) {
+	files := new(protoregistry.Files)
+	md := protoV1.MessageV2(&pb2_latest.BadWithExtensions{}).ProtoReflect().Descriptor()
+	if err := files.RegisterFile(md.ParentFile()); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:       protoV1.String("lint_extensions.proto"),
+		Package:    protoV1.String("test"),
+		Dependency: []string{md.ParentFile().Path()},
+		Extension: []*descriptorpb.FieldDescriptorProto{
+			{
+				Name:     protoV1.String("requirements"),
+				Number:   protoV1.Int32(150),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum(),
+				TypeName: protoV1.String(".schema.proto2.BadWithRequirements"),
+				Extendee: protoV1.String(".schema.proto2.BadWithExtensions"),
+			},
+			{
+				Name:         protoV1.String("with_default"),
+				Number:       protoV1.Int32(151),
+				Label:        descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				Type:         descriptorpb.FieldDescriptorProto_TYPE_INT32.Enum(),
+				DefaultValue: protoV1.String("5"),
+				Extendee:     protoV1.String(".schema.proto2.BadWithExtensions"),
+			},
+		},
+	}, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := new(protoregistry.Types)
+	for i := 0; i < fd.Extensions().Len(); i++ {
+		if err := registry.RegisterExtension(dynamicpb.NewExtensionType(fd.Extensions().Get(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	problems, err := LintMessage(NewHasher(ExtensionResolver(registry)), md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSchemaProblems(t, problems, map[protoreflect.FullName]error{
+		"test.with_default":                      ErrExplicitDefault,
+		"schema.proto2.BadWithRequirements.text": ErrRequiredField,
+	}, "test.with_default", "schema.proto2.BadWithRequirements.text")
+	if problems[0].File != "lint_extensions.proto" {
+		t.Errorf("Got the wrong file for %v", problems[0])
+	}
+
+	// Extensions are not linted when the resolver cannot list them.
+	problems, err = LintMessage(NewHasher(ExtensionResolver(struct{ protoregistry.ExtensionTypeResolver }{registry})), md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("Expected no problems when the extensions cannot be listed, instead got: %v", problems)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+
+	oi "github.com/deepmind/objecthash-proto/internal"
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+	ti "github.com/deepmind/objecthash-proto/tests/internal"
+)
+
+// TestJSONNames checks that the JSON names of fields can be used as keys, so
+// that messages have the same hash as their JSON format.
+func TestJSONNames(t *testing.T, hashers oi.ProtoHashers) {
+	hasher := hashers.JSONNamesAsKeysHasher
+
+	testCases := []ti.TestCase{
+		{
+			Protos: []proto.Message{
+				&pb2_latest.Simple{StringField: proto.String("foo"), BoolField: proto.Bool(true)},
+				&pb3_latest.Simple{StringField: "foo", BoolField: true},
+			},
+			EquivalentObject:     map[string]interface{}{"stringField": "foo", "boolField": true},
+			EquivalentJSONString: "{\"stringField\":\"foo\",\"boolField\":true}",
+			ExpectedHashString:   "25b8b99988ccc2d67681dc106ce1caf6238520c8653b842b91dd7a19a9d02713",
+		},
+
+		// Nested messages.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.Simple{SimpleField: &pb2_latest.Simple{StringField: proto.String("bar")}},
+				&pb3_latest.Simple{SimpleField: &pb3_latest.Simple{StringField: "bar"}},
+			},
+			EquivalentObject:     map[string]map[string]string{"simpleField": {"stringField": "bar"}},
+			EquivalentJSONString: "{\"simpleField\":{\"stringField\":\"bar\"}}",
+			ExpectedHashString:   "d9b06bce75575696ea912632ef9e8cc16842f2bb30686dd1e7a503f47f659af4",
+		},
+
+		// Repeated fields.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.Repetitive{StringField: []string{"a", "b"}},
+				&pb3_latest.Repetitive{StringField: []string{"a", "b"}},
+			},
+			EquivalentObject:     map[string][]string{"stringField": {"a", "b"}},
+			EquivalentJSONString: "{\"stringField\":[\"a\",\"b\"]}",
+			ExpectedHashString:   "d974e6c36f9372c311425a0e36d1a141236bdcafbb14561a4378c7846ce63555",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.Repetitive{SimpleField: []*pb2_latest.Simple{{BoolField: proto.Bool(true)}, {}}},
+				&pb3_latest.Repetitive{SimpleField: []*pb3_latest.Simple{{BoolField: true}, {}}},
+			},
+			EquivalentJSONString: "{\"simpleField\":[{\"boolField\":true},{}]}",
+			ExpectedHashString:   "151048b1144dc0abedf4e6b0a005915e999b2dd40ef8c8ee2c43e9286a17cab5",
+		},
+
+		// The keys of maps are not affected.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.StringMaps{StringToString: map[string]string{"snake_key": "value"}},
+				&pb3_latest.StringMaps{StringToString: map[string]string{"snake_key": "value"}},
+			},
+			EquivalentObject:     map[string]map[string]string{"stringToString": {"snake_key": "value"}},
+			EquivalentJSONString: "{\"stringToString\":{\"snake_key\":\"value\"}}",
+			ExpectedHashString:   "febd1096e975450cac4967d3e6bfcdbe2960c029b2a1f8466ce7633d83ba51c3",
+		},
+
+		// Oneof fields.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.Singleton{Singleton: &pb2_latest.Singleton_TheString{TheString: "TEST!"}},
+				&pb3_latest.Singleton{Singleton: &pb3_latest.Singleton_TheString{TheString: "TEST!"}},
+			},
+			EquivalentObject:     map[string]string{"theString": "TEST!"},
+			EquivalentJSONString: "{\"theString\":\"TEST!\"}",
+			ExpectedHashString:   "468eeedc2a9d132d653c6ff0c45a88e8cc8e9e2a29bd46cb48c10cdc6080d24d",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc.Check(t, hasher)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tests
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	duration_pb "github.com/golang/protobuf/ptypes/duration"
+	timestamp_pb "github.com/golang/protobuf/ptypes/timestamp"
+	wrappers_pb "github.com/golang/protobuf/ptypes/wrappers"
+
+	oi "github.com/deepmind/objecthash-proto/internal"
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+	ti "github.com/deepmind/objecthash-proto/tests/internal"
+)
+
+// TestProtoJSONCompatible checks that messages can have the same hash as
+// their JSON format, in which some values are represented differently (ex.
+// 64-bit integers are strings).
+func TestProtoJSONCompatible(t *testing.T, hashers oi.ProtoHashers) {
+	hasher := hashers.ProtoJSONCompatibleHasher
+
+	testCases := []ti.TestCase{
+		// 64-bit integers are strings, while other integers are numbers.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.Simple{Int64Field: proto.Int64(5), Int32Field: proto.Int32(5), StringField: proto.String("foo")},
+				&pb3_latest.Simple{Int64Field: 5, Int32Field: 5, StringField: "foo"},
+			},
+			EquivalentObject:     map[string]interface{}{"int64Field": "5", "int32Field": 5.0, "stringField": "foo"},
+			EquivalentJSONString: "{\"int64Field\":\"5\",\"int32Field\":5,\"stringField\":\"foo\"}",
+			ExpectedHashString:   "2a83585d1ceadd0defe403db059dda72d573b0c69102cacb9fbb3583f79e548d",
+		},
+
+		// Bytes are base64-encoded strings.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.Simple{BytesField: []byte("hi")},
+				&pb3_latest.Simple{BytesField: []byte("hi")},
+			},
+			EquivalentObject:     map[string]string{"bytesField": "aGk="},
+			EquivalentJSONString: "{\"bytesField\":\"aGk=\"}",
+			ExpectedHashString:   "fc6a0cc9aa188e9a6cf9c771d97a3e3839d7b32c39bc403d7a53b60ab8ed6ba9",
+		},
+
+		// 32-bit floats are numbers with their shortest representation, while
+		// NaN and infinities are strings.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.Simple{FloatField: proto.Float32(0.1), DoubleField: proto.Float64(math.Inf(-1))},
+				&pb3_latest.Simple{FloatField: 0.1, DoubleField: math.Inf(-1)},
+			},
+			EquivalentObject:     map[string]interface{}{"floatField": 0.1, "doubleField": "-Infinity"},
+			EquivalentJSONString: "{\"floatField\":0.1,\"doubleField\":\"-Infinity\"}",
+			ExpectedHashString:   "e2ba81b96b7efe2e086dbcc26922db73c7938558f7b8f79f99de83e3b6d3e11e",
+		},
+
+		// Enum values are their names.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.MyFavoritePlanetsV1{Planets: []pb2_latest.PlanetV1{pb2_latest.PlanetV1_EARTH_V1}},
+				&pb3_latest.MyFavoritePlanetsV1{Planets: []pb3_latest.PlanetV1{pb3_latest.PlanetV1_EARTH_V1}},
+			},
+			EquivalentObject:     map[string][]string{"planets": {"EARTH_V1"}},
+			EquivalentJSONString: "{\"planets\":[\"EARTH_V1\"]}",
+			ExpectedHashString:   "4a665d36f00075b70b37ba41a012f9314118dfd7e42e97ee04f4053c9be8b504",
+		},
+
+		// The keys of maps are strings.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.IntMaps{IntToString: map[int64]string{-5: "foo"}},
+				&pb3_latest.IntMaps{IntToString: map[int64]string{-5: "foo"}},
+			},
+			EquivalentObject:     map[string]map[string]string{"intToString": {"-5": "foo"}},
+			EquivalentJSONString: "{\"intToString\":{\"-5\":\"foo\"}}",
+			ExpectedHashString:   "2942d436e0f3091e3a2e8ce64a7feff52a49257801bfdbf394345ec776f30bdf",
+		},
+
+		// Timestamps, durations and wrappers have their own JSON format.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{
+					TimestampField:  &timestamp_pb.Timestamp{Seconds: 63108020, Nanos: 21000000},
+					DurationField:   &duration_pb.Duration{Seconds: -1, Nanos: -500000000},
+					Int64ValueField: &wrappers_pb.Int64Value{},
+				},
+				&pb3_latest.KnownTypes{
+					TimestampField:  &timestamp_pb.Timestamp{Seconds: 63108020, Nanos: 21000000},
+					DurationField:   &duration_pb.Duration{Seconds: -1, Nanos: -500000000},
+					Int64ValueField: &wrappers_pb.Int64Value{},
+				},
+			},
+			EquivalentObject: map[string]string{
+				"timestampField":  "1972-01-01T10:00:20.021Z",
+				"durationField":   "-1.500s",
+				"int64ValueField": "0",
+			},
+			EquivalentJSONString: "{\"timestampField\":\"1972-01-01T10:00:20.021Z\",\"durationField\":\"-1.500s\",\"int64ValueField\":\"0\"}",
+			ExpectedHashString:   "c2ab25aefefb7e9d1f576cb6dddd56f520ed1b55fb48172090c2a47697dc3237",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc.Check(t, hasher)
+	}
+}
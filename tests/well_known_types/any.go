@@ -0,0 +1,238 @@
+// Copyright 2018 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wellknowntypes
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	any_pb "github.com/golang/protobuf/ptypes/any"
+	duration_pb "github.com/golang/protobuf/ptypes/duration"
+
+	oi "github.com/deepmind/objecthash-proto/internal"
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+	ti "github.com/deepmind/objecthash-proto/tests/internal"
+)
+
+const (
+	proto2SimpleTypeURL = "type.googleapis.com/schema.proto2.Simple"
+	proto3SimpleTypeURL = "type.googleapis.com/schema.proto3.Simple"
+)
+
+// TestAnyAsEmbeddedMessage confirms that google.protobuf.Any protos are hashed
+// using the messages they embed when using the AnyResolver option.
+func TestAnyAsEmbeddedMessage(t *testing.T, hashers oi.ProtoHashers) {
+	hasher := hashers.AnyAsEmbeddedMessageHasher
+
+	testCases := []ti.TestCase{
+		// An Any is hashed as a message with a "type_url" string field and a
+		// "value" field that contains the embedded message.
+		{
+			Protos: []proto.Message{
+				&any_pb.Any{
+					TypeUrl: proto3SimpleTypeURL,
+					Value:   marshal(t, &pb3_latest.Simple{StringField: "foo"}),
+				},
+			},
+			EquivalentJSONString: "{\"type_url\": \"type.googleapis.com/schema.proto3.Simple\", \"value\": {\"string_field\": \"foo\"}}",
+			ExpectedHashString:   "73346588e843abad2147f22cd5c998698af14f71fc748c402271f8f1ff38fe57",
+		},
+
+		{
+			Protos: []proto.Message{
+				&any_pb.Any{
+					TypeUrl: proto2SimpleTypeURL,
+					Value:   marshal(t, &pb2_latest.Simple{StringField: proto.String("foo")}),
+				},
+			},
+			EquivalentJSONString: "{\"type_url\": \"type.googleapis.com/schema.proto2.Simple\", \"value\": {\"string_field\": \"foo\"}}",
+			ExpectedHashString:   "dce24f54d4731fd23d61b06597c8f7ea77948df8649fe00be965bf257bec8e2f",
+		},
+
+		// An Any embedding an empty message still has a "value" field.
+		{
+			Protos: []proto.Message{
+				&any_pb.Any{TypeUrl: proto3SimpleTypeURL},
+				&any_pb.Any{TypeUrl: proto3SimpleTypeURL, Value: []byte{}},
+				&any_pb.Any{TypeUrl: proto3SimpleTypeURL, Value: marshal(t, &pb3_latest.Simple{})},
+			},
+			EquivalentJSONString: "{\"type_url\": \"type.googleapis.com/schema.proto3.Simple\", \"value\": {}}",
+			ExpectedHashString:   "cd860df91e7740a35db846b4d2f5cd4ff37c19af4c5177682aebd5772215d481",
+		},
+
+		// Embedded well-known types get hashed just like they would be if they
+		// were not embedded.
+		{
+			Protos: []proto.Message{
+				&any_pb.Any{
+					TypeUrl: "type.googleapis.com/google.protobuf.Duration",
+					Value:   marshal(t, &duration_pb.Duration{Seconds: 1}),
+				},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject: map[string]interface{}{
+				"type_url": "type.googleapis.com/google.protobuf.Duration",
+				"value":    []int64{1, 0},
+			},
+			ExpectedHashString: "e14ec6662f1d2b5bce97fc6e976723c286031e4eedaa7e2f363e31dc68dfde89",
+		},
+
+		{
+			Protos: []proto.Message{
+				&any_pb.Any{
+					TypeUrl: "type.googleapis.com/google.protobuf.Any",
+					Value: marshal(t, &any_pb.Any{
+						TypeUrl: proto3SimpleTypeURL,
+						Value:   marshal(t, &pb3_latest.Simple{StringField: "foo"}),
+					}),
+				},
+			},
+			EquivalentJSONString: "{\"type_url\": \"type.googleapis.com/google.protobuf.Any\", " +
+				"\"value\": {\"type_url\": \"type.googleapis.com/schema.proto3.Simple\", \"value\": {\"string_field\": \"foo\"}}}",
+			ExpectedHashString: "a3983aac0ff4d7788d9abc388fb7a676f7dae826513300e286b53611ebef42af",
+		},
+
+		///////////////////////////////
+		//  Any within other protos. //
+		///////////////////////////////
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{AnyField: &any_pb.Any{
+					TypeUrl: proto3SimpleTypeURL,
+					Value:   marshal(t, &pb3_latest.Simple{StringField: "foo"}),
+				}},
+				&pb3_latest.KnownTypes{AnyField: &any_pb.Any{
+					TypeUrl: proto3SimpleTypeURL,
+					Value:   marshal(t, &pb3_latest.Simple{StringField: "foo"}),
+				}},
+			},
+			EquivalentJSONString: "{\"any_field\": {\"type_url\": \"type.googleapis.com/schema.proto3.Simple\", \"value\": {\"string_field\": \"foo\"}}}",
+			ExpectedHashString:   "323bafa93bc4e059537081216e48927078d03b8c825a47c8eab99840a90b7139",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc.Check(t, hasher)
+	}
+
+	////////////////////////////////////////
+	//  Any protos that can't be hashed. //
+	////////////////////////////////////////
+
+	badProtos := []proto.Message{
+		// Missing type URLs.
+		&any_pb.Any{},
+		&any_pb.Any{Value: marshal(t, &pb3_latest.Simple{StringField: "foo"})},
+		&pb3_latest.KnownTypes{AnyField: &any_pb.Any{}},
+
+		// Unresolvable type URLs.
+		&any_pb.Any{TypeUrl: "type.googleapis.com/does.not.Exist"},
+		&pb2_latest.KnownTypes{AnyField: &any_pb.Any{TypeUrl: "type.googleapis.com/does.not.Exist"}},
+
+		// Values that can't be unmarshalled as the type of their type URL.
+		&any_pb.Any{TypeUrl: proto3SimpleTypeURL, Value: []byte{0xff}},
+
+		// Embedded messages that can't be hashed.
+		&any_pb.Any{TypeUrl: "type.googleapis.com/google.protobuf.Any", Value: marshal(t, &any_pb.Any{})},
+	}
+
+	for _, message := range badProtos {
+		_, err := hasher.HashProto(message)
+		if err == nil {
+			t.Errorf("Attempting to hash %T{ %+v} should have returned an error.", message, message)
+		}
+	}
+}
+
+// TestAnyAsTypeURLAndBytes confirms that google.protobuf.Any protos are hashed
+// as regular messages when using the AnyAsTypeURLAndBytes option.
+func TestAnyAsTypeURLAndBytes(t *testing.T, hashers oi.ProtoHashers) {
+	hasher := hashers.AnyAsTypeURLAndBytesHasher
+
+	testCases := []ti.TestCase{
+		{
+			Protos: []proto.Message{
+				&any_pb.Any{
+					TypeUrl: proto3SimpleTypeURL,
+					Value:   []byte{0xca, 0x01, 0x03, 'f', 'o', 'o'},
+				},
+			},
+			// No equivalent JSON: JSON does not have a "bytes" type.
+			EquivalentObject: map[string]interface{}{
+				"type_url": proto3SimpleTypeURL,
+				"value":    []byte{0xca, 0x01, 0x03, 'f', 'o', 'o'},
+			},
+			ExpectedHashString: "44dea18d29456ce4c3f8d7275c6180d41914640841f9ad35e699c886277666bf",
+		},
+
+		// The type URL does not need to be resolvable.
+		{
+			Protos: []proto.Message{
+				&any_pb.Any{},
+				&any_pb.Any{TypeUrl: "", Value: []byte{}},
+			},
+			EquivalentJSONString: "{}",
+			EquivalentObject:     map[string]interface{}{},
+			ExpectedHashString:   "18ac3e7343f016890c510e93f935261169d9e3f565436429830faf0934f4f8e4",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{AnyField: &any_pb.Any{
+					TypeUrl: proto3SimpleTypeURL,
+					Value:   []byte{0xca, 0x01, 0x03, 'f', 'o', 'o'},
+				}},
+				&pb3_latest.KnownTypes{AnyField: &any_pb.Any{
+					TypeUrl: proto3SimpleTypeURL,
+					Value:   []byte{0xca, 0x01, 0x03, 'f', 'o', 'o'},
+				}},
+			},
+			// No equivalent JSON: JSON does not have a "bytes" type.
+			EquivalentObject: map[string]map[string]interface{}{
+				"any_field": {
+					"type_url": proto3SimpleTypeURL,
+					"value":    []byte{0xca, 0x01, 0x03, 'f', 'o', 'o'},
+				},
+			},
+			ExpectedHashString: "5e2a47150a6609f2e976494af83782627c7fb2beb075c0de14eaf7ee1c74ad37",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{AnyField: &any_pb.Any{}},
+				&pb3_latest.KnownTypes{AnyField: &any_pb.Any{}},
+			},
+			EquivalentJSONString: "{\"any_field\": {}}",
+			EquivalentObject:     map[string]map[string]interface{}{"any_field": {}},
+			ExpectedHashString:   "d65e81739320b724131bca233b4cd101480c2e5110a033f3654b9ef20173441f",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc.Check(t, hasher)
+	}
+}
+
+// marshal returns the binary serialization of a proto message.
+func marshal(t *testing.T, message proto.Message) []byte {
+	t.Helper()
+
+	b, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wellknowntypes
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	duration_pb "github.com/golang/protobuf/ptypes/duration"
+
+	oi "github.com/deepmind/objecthash-proto/internal"
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+	ti "github.com/deepmind/objecthash-proto/tests/internal"
+)
+
+// TestDurations confirms that google.protobuf.Duration protos are hashed properly.
+func TestDurations(t *testing.T, hashers oi.ProtoHashers) {
+	hasher := hashers.FieldNamesAsKeysHasher
+
+	testCases := []ti.TestCase{
+		/////////////////////////////
+		//  Empty/Zero Durations. //
+		/////////////////////////////
+
+		// Just like timestamps, the distinction between unset and zero durations
+		// happens at the message level, rather than the field level.
+		//
+		// As a result, an unset duration is one where the proto itself is nil,
+		// while an explicitly set duration with unset fields is considered to be
+		// explicitly set to 0.
+		{
+			Protos: []proto.Message{
+				&duration_pb.Duration{},
+				&duration_pb.Duration{Seconds: 0, Nanos: 0},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   []int64{0, 0},
+			ExpectedHashString: "3a82b649344529f03f52c1833f5aecc488a53b31461a1f54c305d149b12b8f53",
+		},
+
+		////////////////////////
+		//  Normal Durations. //
+		////////////////////////
+		{
+			Protos: []proto.Message{
+				&duration_pb.Duration{Seconds: 1, Nanos: 500000000},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   []int64{1, 500000000},
+			ExpectedHashString: "ddaa683afa06f1d8922127a09327028c8c589897cd2463be271202d3f6c71b23",
+		},
+
+		// Negative durations have both of their fields negative (or zero).
+		{
+			Protos: []proto.Message{
+				&duration_pb.Duration{Seconds: -1, Nanos: -500000000},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   []int64{-1, -500000000},
+			ExpectedHashString: "02336a80dbe9f7d411e9db7168a4b0e0a6a1de11637f09e1ecd21c4430852060",
+		},
+
+		// The limits of the valid range of durations.
+		{
+			Protos: []proto.Message{
+				&duration_pb.Duration{Seconds: 315576000000, Nanos: 999999999},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   []int64{315576000000, 999999999},
+			ExpectedHashString: "6a3a042b29e7108946086fb2484c973749ce52cf0e5a4e9e4e6496542e313348",
+		},
+
+		{
+			Protos: []proto.Message{
+				&duration_pb.Duration{Seconds: -315576000000, Nanos: -999999999},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   []int64{-315576000000, -999999999},
+			ExpectedHashString: "cc442ad5152715d8a538c13eae0799b9242b0c99820f124665a889967eecc120",
+		},
+
+		/////////////////////////////////////
+		//  Durations within other protos. //
+		/////////////////////////////////////
+
+		// As mentioned above, a duration with unset fields is considered to be a
+		// duration explicitly set to zero.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{DurationField: &duration_pb.Duration{}},
+				&pb2_latest.KnownTypes{DurationField: &duration_pb.Duration{Seconds: 0, Nanos: 0}},
+
+				&pb3_latest.KnownTypes{DurationField: &duration_pb.Duration{}},
+				&pb3_latest.KnownTypes{DurationField: &duration_pb.Duration{Seconds: 0, Nanos: 0}},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   map[string][]int64{"duration_field": {0, 0}},
+			ExpectedHashString: "80668dc83d8e5c0c9e24afba293e69cb1ce697772521f7a8ea3afc20a6dd617a",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{DurationField: &duration_pb.Duration{Seconds: 3600}},
+				&pb3_latest.KnownTypes{DurationField: &duration_pb.Duration{Seconds: 3600}},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   map[string][]int64{"duration_field": {3600, 0}},
+			ExpectedHashString: "dc38f360a5b86b180c36c1e9a3b56eb439cc3496acc65f11bcff61c6b3a60c36",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{DurationField: &duration_pb.Duration{Seconds: -1, Nanos: -500000000}},
+				&pb3_latest.KnownTypes{DurationField: &duration_pb.Duration{Seconds: -1, Nanos: -500000000}},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   map[string][]int64{"duration_field": {-1, -500000000}},
+			ExpectedHashString: "7fbbfdf7928027f1fd7b6cd95d9873ac8c3b1e97617f868ef9dd7545d3f9bd63",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc.Check(t, hasher)
+	}
+
+	/////////////////////////
+	//  Invalid Durations. //
+	/////////////////////////
+
+	invalidDurations := []proto.Message{
+		// Seconds out of range.
+		&duration_pb.Duration{Seconds: 315576000001},
+		&duration_pb.Duration{Seconds: -315576000001},
+		&pb2_latest.KnownTypes{DurationField: &duration_pb.Duration{Seconds: 315576000001}},
+		&pb3_latest.KnownTypes{DurationField: &duration_pb.Duration{Seconds: -315576000001}},
+
+		// Nanos out of range.
+		&duration_pb.Duration{Nanos: 1000000000},
+		&duration_pb.Duration{Nanos: -1000000000},
+		&pb2_latest.KnownTypes{DurationField: &duration_pb.Duration{Nanos: 1000000000}},
+		&pb3_latest.KnownTypes{DurationField: &duration_pb.Duration{Nanos: -1000000000}},
+
+		// Seconds and nanos with mixed signs.
+		&duration_pb.Duration{Seconds: 1, Nanos: -1},
+		&duration_pb.Duration{Seconds: -1, Nanos: 1},
+		&pb2_latest.KnownTypes{DurationField: &duration_pb.Duration{Seconds: 1, Nanos: -1}},
+		&pb3_latest.KnownTypes{DurationField: &duration_pb.Duration{Seconds: -1, Nanos: 1}},
+	}
+
+	for _, message := range invalidDurations {
+		_, err := hasher.HashProto(message)
+		if err == nil {
+			t.Errorf("Attempting to hash %T{ %+v} should have returned an error.", message, message)
+		}
+	}
+}
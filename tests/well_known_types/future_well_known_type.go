@@ -0,0 +1,31 @@
+// Copyright 2018 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wellknowntypes
+
+// futureWellKnownType is a manually created mock proto that can be used in
+// tests as an unrecognized (or new) well known type. It is not generated from
+// a .proto file.
+//
+// Note that this is not registered with the proto library (using init) to keep
+// things simple. The proto library derives its descriptor from its Go type and
+// its XXX_MessageName method, which places it in the google.protobuf package.
+type futureWellKnownType struct {
+}
+
+func (m *futureWellKnownType) Reset()                  { *m = futureWellKnownType{} }
+func (m *futureWellKnownType) String() string          { return "FutureWellKnownType" }
+func (*futureWellKnownType) ProtoMessage()             {}
+func (*futureWellKnownType) XXX_MessageName() string   { return "google.protobuf.FutureWellKnownType" }
+func (*futureWellKnownType) XXX_WellKnownType() string { return "FutureWellKnownType" }
@@ -0,0 +1,350 @@
+// Copyright 2018 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wellknowntypes
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	struct_pb "github.com/golang/protobuf/ptypes/struct"
+
+	oi "github.com/deepmind/objecthash-proto/internal"
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+	ti "github.com/deepmind/objecthash-proto/tests/internal"
+)
+
+// TestStructs confirms that google.protobuf.Struct, google.protobuf.ListValue
+// and google.protobuf.Value protos are hashed exactly like the equivalent JSON.
+func TestStructs(t *testing.T, hashers oi.ProtoHashers) {
+	// These represent JSON values rather than proto messages, so their hashes
+	// should not depend on the identifier used for proto messages.
+	standaloneHashers := []oi.ProtoHasher{
+		hashers.DefaultHasher,
+		hashers.CustomMessageIdentifierHasher,
+	}
+
+	standaloneTestCases := []ti.TestCase{
+		///////////////
+		//  Structs. //
+		///////////////
+		{
+			Protos: []proto.Message{
+				&struct_pb.Struct{},
+				&struct_pb.Struct{Fields: map[string]*struct_pb.Value{}},
+			},
+			EquivalentJSONString: "{}",
+			EquivalentObject:     map[string]interface{}{},
+			ExpectedHashString:   "18ac3e7343f016890c510e93f935261169d9e3f565436429830faf0934f4f8e4",
+		},
+
+		{
+			Protos: []proto.Message{
+				&struct_pb.Struct{Fields: map[string]*struct_pb.Value{"foo": stringValue("bar")}},
+				structValue(map[string]*struct_pb.Value{"foo": stringValue("bar")}),
+			},
+			EquivalentJSONString: "{\"foo\": \"bar\"}",
+			EquivalentObject:     map[string]string{"foo": "bar"},
+			ExpectedHashString:   "7ef5237c3027d6c58100afadf37796b3d351025cf28038280147d42fdc53b960",
+		},
+
+		{
+			Protos: []proto.Message{
+				&struct_pb.Struct{Fields: map[string]*struct_pb.Value{
+					"foo":  stringValue("bar"),
+					"baz":  numberValue(1.5),
+					"qux":  nullValue(),
+					"quux": boolValue(true),
+					"list": listValue(
+						numberValue(1),
+						stringValue("a"),
+						boolValue(false),
+						nullValue(),
+						structValue(map[string]*struct_pb.Value{"x": listValue()}),
+					),
+					"nested": structValue(map[string]*struct_pb.Value{
+						"a": structValue(map[string]*struct_pb.Value{
+							"b": numberValue(-2),
+						}),
+					}),
+				}},
+			},
+			EquivalentJSONString: "{\"foo\": \"bar\", \"baz\": 1.5, \"qux\": null, \"quux\": true, " +
+				"\"list\": [1, \"a\", false, null, {\"x\": []}], \"nested\": {\"a\": {\"b\": -2}}}",
+			ExpectedHashString: "1a42256e730723e57a86b1326ae42fa584bcc633ace42c56cdec21bc9ff417e7",
+		},
+
+		//////////////////
+		//  ListValues. //
+		//////////////////
+		{
+			Protos: []proto.Message{
+				&struct_pb.ListValue{},
+				&struct_pb.ListValue{Values: []*struct_pb.Value{}},
+				listValue(),
+			},
+			EquivalentJSONString: "[]",
+			EquivalentObject:     []interface{}{},
+			ExpectedHashString:   "acac86c0e609ca906f632b0e2dacccb2b77d22b0621f20ebece1a4835b93f6f0",
+		},
+
+		{
+			Protos: []proto.Message{
+				&struct_pb.ListValue{Values: []*struct_pb.Value{numberValue(1), numberValue(2), numberValue(3)}},
+				listValue(numberValue(1), numberValue(2), numberValue(3)),
+			},
+			EquivalentJSONString: "[1, 2, 3]",
+			EquivalentObject:     []float64{1, 2, 3},
+			ExpectedHashString:   "925d474ac71f6e8cb35dd951d123944f7cabc5cda9a043cf38cd638cc0158db0",
+		},
+
+		{
+			Protos: []proto.Message{
+				&struct_pb.ListValue{Values: []*struct_pb.Value{
+					listValue(),
+					structValue(nil),
+					listValue(nullValue()),
+				}},
+			},
+			EquivalentJSONString: "[[], {}, [null]]",
+			ExpectedHashString:   "b9a8f32186fcd7764779b7844ca80f3d240f66842a944f363564a6f6ef19b689",
+		},
+
+		//////////////
+		//  Values. //
+		//////////////
+		{
+			Protos: []proto.Message{
+				nullValue(),
+			},
+			EquivalentJSONString: "null",
+			ExpectedHashString:   "1b16b1df538ba12dc3f97edbb85caa7050d46c148134290feba80f8236c83db9",
+		},
+
+		{
+			Protos: []proto.Message{
+				numberValue(0),
+			},
+			EquivalentJSONString: "0",
+			EquivalentObject:     0.0,
+			ExpectedHashString:   "60101d8c9cb988411468e38909571f357daa67bff5a7b0a3f9ae295cd4aba33d",
+		},
+
+		{
+			Protos: []proto.Message{
+				numberValue(-1.5),
+			},
+			EquivalentJSONString: "-1.5",
+			EquivalentObject:     -1.5,
+			ExpectedHashString:   "d3bf49f1fa2d77f147137312596b1899ed82138661069f7d3817e3c47fa95c37",
+		},
+
+		{
+			Protos: []proto.Message{
+				numberValue(1e+25),
+			},
+			EquivalentJSONString: "1e+25",
+			EquivalentObject:     1e+25,
+			ExpectedHashString:   "38784fb73c107f938956418d4055a09157f17460b2a351b1c6147f2028423da9",
+		},
+
+		{
+			Protos: []proto.Message{
+				stringValue(""),
+			},
+			EquivalentJSONString: "\"\"",
+			EquivalentObject:     "",
+			ExpectedHashString:   "0bfe935e70c321c7ca3afc75ce0d0ca2f98b5422e008bb31c00c6d7f1f1c0ad6",
+		},
+
+		{
+			Protos: []proto.Message{
+				stringValue("你好"),
+			},
+			EquivalentJSONString: "\"你好\"",
+			EquivalentObject:     "你好",
+			ExpectedHashString:   "462b68f5e3d75aed5f02841b4ffee068d4cf33ce1b155105b71a9e5f358026df",
+		},
+
+		{
+			Protos: []proto.Message{
+				boolValue(true),
+			},
+			EquivalentJSONString: "true",
+			EquivalentObject:     true,
+			ExpectedHashString:   "7dc96f776c8423e57a2785489a3f9c43fb6e756876d6ad9a9cac4aa4e72ec193",
+		},
+
+		{
+			Protos: []proto.Message{
+				boolValue(false),
+			},
+			EquivalentJSONString: "false",
+			EquivalentObject:     false,
+			ExpectedHashString:   "c02c0b965e023abee808f2b548d8d5193a8b5229be6f3121a6f16e2d41a449b3",
+		},
+	}
+
+	for _, hasher := range standaloneHashers {
+		for _, tc := range standaloneTestCases {
+			tc.Check(t, hasher)
+		}
+	}
+
+	hasher := hashers.FieldNamesAsKeysHasher
+
+	testCases := []ti.TestCase{
+		////////////////////////////////////////////////////
+		//  Structs, ListValues and Values within protos. //
+		////////////////////////////////////////////////////
+
+		// Unlike other proto3 message fields, explicitly set Structs, ListValues
+		// and Values are never ignored, even when they're empty.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{StructField: &struct_pb.Struct{}},
+				&pb3_latest.KnownTypes{StructField: &struct_pb.Struct{}},
+			},
+			EquivalentJSONString: "{\"struct_field\": {}}",
+			EquivalentObject:     map[string]map[string]interface{}{"struct_field": {}},
+			ExpectedHashString:   "59bc52b0d286cc42d4fb12830aa3079b5000cb739601f4c465531eb18b9b93ec",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{StructField: &struct_pb.Struct{Fields: map[string]*struct_pb.Value{"foo": stringValue("bar")}}},
+				&pb3_latest.KnownTypes{StructField: &struct_pb.Struct{Fields: map[string]*struct_pb.Value{"foo": stringValue("bar")}}},
+			},
+			EquivalentJSONString: "{\"struct_field\": {\"foo\": \"bar\"}}",
+			EquivalentObject:     map[string]map[string]string{"struct_field": {"foo": "bar"}},
+			ExpectedHashString:   "754343554b952dda144bff4c7bb4c5a6837b5930d874e622107e20af765640ee",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{ListValueField: &struct_pb.ListValue{}},
+				&pb3_latest.KnownTypes{ListValueField: &struct_pb.ListValue{}},
+			},
+			EquivalentJSONString: "{\"list_value_field\": []}",
+			EquivalentObject:     map[string][]interface{}{"list_value_field": {}},
+			ExpectedHashString:   "2a3bcdba9f9b9d20bab46a3e52a58386500cadfd2205b0c75acc9b4347d01378",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{ListValueField: listValue(numberValue(1), numberValue(2), numberValue(3)).GetListValue()},
+				&pb3_latest.KnownTypes{ListValueField: listValue(numberValue(1), numberValue(2), numberValue(3)).GetListValue()},
+			},
+			EquivalentJSONString: "{\"list_value_field\": [1, 2, 3]}",
+			EquivalentObject:     map[string][]float64{"list_value_field": {1, 2, 3}},
+			ExpectedHashString:   "336e17a32a977e2d011a76b7240d2cb8fcc719ef83737567bd18e87123e36b4d",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{ValueField: nullValue()},
+				&pb3_latest.KnownTypes{ValueField: nullValue()},
+			},
+			EquivalentJSONString: "{\"value_field\": null}",
+			EquivalentObject:     map[string]interface{}{"value_field": nil},
+			ExpectedHashString:   "94d723c83ce9ed832f47b9c2f2f745eda4d22e2f401b4d78bfea8a5a445ed5c9",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{ValueField: numberValue(0)},
+				&pb3_latest.KnownTypes{ValueField: numberValue(0)},
+			},
+			EquivalentJSONString: "{\"value_field\": 0}",
+			EquivalentObject:     map[string]float64{"value_field": 0},
+			ExpectedHashString:   "bd9a481bef817c854b33e64812d635321209d31f5c4841cf8c75dbe58ae165d5",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{ValueField: stringValue("")},
+				&pb3_latest.KnownTypes{ValueField: stringValue("")},
+			},
+			EquivalentJSONString: "{\"value_field\": \"\"}",
+			EquivalentObject:     map[string]string{"value_field": ""},
+			ExpectedHashString:   "4a674e66f9c54b55eedc853b049017cf43d0c94675354c513e8d64546097c268",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{ValueField: structValue(map[string]*struct_pb.Value{"a": listValue(numberValue(1))})},
+				&pb3_latest.KnownTypes{ValueField: structValue(map[string]*struct_pb.Value{"a": listValue(numberValue(1))})},
+			},
+			EquivalentJSONString: "{\"value_field\": {\"a\": [1]}}",
+			ExpectedHashString:   "959b94d1653316f29b04c1acc0c6d8454d1ceefce19e2d9813b1500b8d8d247d",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc.Check(t, hasher)
+	}
+
+	//////////////////////////////////////////////
+	//  Invalid Structs, ListValues and Values. //
+	//////////////////////////////////////////////
+
+	invalidProtos := []proto.Message{
+		// Values without any kind of value set have no JSON equivalent.
+		&struct_pb.Value{},
+		&pb2_latest.KnownTypes{ValueField: &struct_pb.Value{}},
+		&pb3_latest.KnownTypes{ValueField: &struct_pb.Value{}},
+		&struct_pb.Struct{Fields: map[string]*struct_pb.Value{"foo": {}}},
+		&struct_pb.ListValue{Values: []*struct_pb.Value{{}}},
+
+		// Nil values within Structs and ListValues.
+		&struct_pb.Struct{Fields: map[string]*struct_pb.Value{"foo": nil}},
+		&struct_pb.ListValue{Values: []*struct_pb.Value{nil}},
+
+		// Nil struct_value and list_value.
+		&struct_pb.Value{Kind: &struct_pb.Value_StructValue{}},
+		&struct_pb.Value{Kind: &struct_pb.Value_ListValue{}},
+	}
+
+	for _, message := range invalidProtos {
+		_, err := hasher.HashProto(message)
+		if err == nil {
+			t.Errorf("Attempting to hash %T{ %+v} should have returned an error.", message, message)
+		}
+	}
+}
+
+func nullValue() *struct_pb.Value {
+	return &struct_pb.Value{Kind: &struct_pb.Value_NullValue{NullValue: struct_pb.NullValue_NULL_VALUE}}
+}
+
+func numberValue(f float64) *struct_pb.Value {
+	return &struct_pb.Value{Kind: &struct_pb.Value_NumberValue{NumberValue: f}}
+}
+
+func stringValue(s string) *struct_pb.Value {
+	return &struct_pb.Value{Kind: &struct_pb.Value_StringValue{StringValue: s}}
+}
+
+func boolValue(b bool) *struct_pb.Value {
+	return &struct_pb.Value{Kind: &struct_pb.Value_BoolValue{BoolValue: b}}
+}
+
+func structValue(fields map[string]*struct_pb.Value) *struct_pb.Value {
+	return &struct_pb.Value{Kind: &struct_pb.Value_StructValue{StructValue: &struct_pb.Struct{Fields: fields}}}
+}
+
+func listValue(values ...*struct_pb.Value) *struct_pb.Value {
+	return &struct_pb.Value{Kind: &struct_pb.Value_ListValue{ListValue: &struct_pb.ListValue{Values: values}}}
+}
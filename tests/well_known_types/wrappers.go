@@ -0,0 +1,309 @@
+// Copyright 2018 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wellknowntypes
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	wrappers_pb "github.com/golang/protobuf/ptypes/wrappers"
+
+	oi "github.com/deepmind/objecthash-proto/internal"
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+	ti "github.com/deepmind/objecthash-proto/tests/internal"
+)
+
+// TestWrappers confirms that the wrapper types (ex. google.protobuf.Int64Value)
+// are hashed as the values that they wrap.
+func TestWrappers(t *testing.T, hashers oi.ProtoHashers) {
+	hasher := hashers.FieldNamesAsKeysHasher
+
+	testCases := []ti.TestCase{
+		///////////////////////////
+		//  Zero Value Wrappers. //
+		///////////////////////////
+
+		// Just like timestamps, the distinction between unset and zero wrappers
+		// happens at the message level, rather than the field level.
+		//
+		// As a result, an unset wrapper is one where the proto itself is nil, while
+		// an explicitly set wrapper with an unset value is considered to be
+		// explicitly set to the zero value.
+		{
+			Protos: []proto.Message{
+				&wrappers_pb.BoolValue{},
+				&wrappers_pb.BoolValue{Value: false},
+			},
+			EquivalentJSONString: "false",
+			EquivalentObject:     false,
+			ExpectedHashString:   "c02c0b965e023abee808f2b548d8d5193a8b5229be6f3121a6f16e2d41a449b3",
+		},
+
+		{
+			Protos: []proto.Message{
+				&wrappers_pb.BytesValue{},
+				&wrappers_pb.BytesValue{Value: []byte{}},
+			},
+			// No equivalent JSON: JSON does not have a "bytes" type.
+			EquivalentObject:   []byte{},
+			ExpectedHashString: "454349e422f05297191ead13e21d3db520e5abef52055e4964b82fb213f593a1",
+		},
+
+		{
+			Protos: []proto.Message{
+				&wrappers_pb.DoubleValue{},
+				&wrappers_pb.DoubleValue{Value: 0},
+				&wrappers_pb.FloatValue{},
+				&wrappers_pb.FloatValue{Value: 0},
+			},
+			EquivalentJSONString: "0",
+			EquivalentObject:     0.0,
+			ExpectedHashString:   "60101d8c9cb988411468e38909571f357daa67bff5a7b0a3f9ae295cd4aba33d",
+		},
+
+		{
+			Protos: []proto.Message{
+				&wrappers_pb.Int32Value{},
+				&wrappers_pb.Int32Value{Value: 0},
+				&wrappers_pb.Int64Value{},
+				&wrappers_pb.Int64Value{Value: 0},
+				&wrappers_pb.UInt32Value{},
+				&wrappers_pb.UInt32Value{Value: 0},
+				&wrappers_pb.UInt64Value{},
+				&wrappers_pb.UInt64Value{Value: 0},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   int64(0),
+			ExpectedHashString: "a4e167a76a05add8a8654c169b07b0447a916035aef602df103e8ae0fe2ff390",
+		},
+
+		{
+			Protos: []proto.Message{
+				&wrappers_pb.StringValue{},
+				&wrappers_pb.StringValue{Value: ""},
+			},
+			EquivalentJSONString: "\"\"",
+			EquivalentObject:     "",
+			ExpectedHashString:   "0bfe935e70c321c7ca3afc75ce0d0ca2f98b5422e008bb31c00c6d7f1f1c0ad6",
+		},
+
+		///////////////////////
+		//  Normal Wrappers. //
+		///////////////////////
+		{
+			Protos: []proto.Message{
+				&wrappers_pb.BoolValue{Value: true},
+			},
+			EquivalentJSONString: "true",
+			EquivalentObject:     true,
+			ExpectedHashString:   "7dc96f776c8423e57a2785489a3f9c43fb6e756876d6ad9a9cac4aa4e72ec193",
+		},
+
+		{
+			Protos: []proto.Message{
+				&wrappers_pb.BytesValue{Value: []byte("foo")},
+			},
+			// No equivalent JSON: JSON does not have a "bytes" type.
+			EquivalentObject:   []byte("foo"),
+			ExpectedHashString: "a0765c262bb19ddaa4f4a77144431a33b666fd1b7b7080ae916e159f7a5d8f79",
+		},
+
+		{
+			Protos: []proto.Message{
+				&wrappers_pb.DoubleValue{Value: 1.5},
+				&wrappers_pb.FloatValue{Value: 1.5},
+			},
+			EquivalentJSONString: "1.5",
+			EquivalentObject:     1.5,
+			ExpectedHashString:   "7d9d2d2489ee3a73c6e6e7b84469a5f697e902793cbbb3b4b1c0da46b9b4bdec",
+		},
+
+		{
+			Protos: []proto.Message{
+				&wrappers_pb.Int32Value{Value: 5},
+				&wrappers_pb.Int64Value{Value: 5},
+				&wrappers_pb.UInt32Value{Value: 5},
+				&wrappers_pb.UInt64Value{Value: 5},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   int64(5),
+			ExpectedHashString: "0016cf5ed68e5a5349722594ae8f592653c761881562607a2337678a70d22260",
+		},
+
+		{
+			Protos: []proto.Message{
+				&wrappers_pb.Int32Value{Value: -5},
+				&wrappers_pb.Int64Value{Value: -5},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   int64(-5),
+			ExpectedHashString: "108542b6e063c1cbe1acf8ded2932545857e144f0fa835829796c5f82cdbee00",
+		},
+
+		{
+			Protos: []proto.Message{
+				&wrappers_pb.StringValue{Value: "foo"},
+			},
+			EquivalentJSONString: "\"foo\"",
+			EquivalentObject:     "foo",
+			ExpectedHashString:   "a6a6e5e783c363cd95693ec189c2682315d956869397738679b56305f2095038",
+		},
+
+		////////////////////////////////////
+		//  Wrappers within other protos. //
+		////////////////////////////////////
+
+		// Unset wrapper fields are ignored, just like any other unset message field.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{},
+				&pb3_latest.KnownTypes{},
+			},
+			EquivalentJSONString: "{}",
+			EquivalentObject:     map[string]interface{}{},
+			ExpectedHashString:   "18ac3e7343f016890c510e93f935261169d9e3f565436429830faf0934f4f8e4",
+		},
+
+		// Wrapper fields explicitly set to zero values are not ignored, even in
+		// proto3 messages.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{BoolValueField: &wrappers_pb.BoolValue{}},
+				&pb3_latest.KnownTypes{BoolValueField: &wrappers_pb.BoolValue{Value: false}},
+			},
+			EquivalentJSONString: "{\"bool_value_field\": false}",
+			EquivalentObject:     map[string]bool{"bool_value_field": false},
+			ExpectedHashString:   "8ec24416eca90851428f5b63b7529d2ea7d24fe0e9b3ca11ea2ee851d0ce2280",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{BytesValueField: &wrappers_pb.BytesValue{}},
+				&pb3_latest.KnownTypes{BytesValueField: &wrappers_pb.BytesValue{Value: []byte{}}},
+			},
+			// No equivalent JSON: JSON does not have a "bytes" type.
+			EquivalentObject:   map[string][]byte{"bytes_value_field": {}},
+			ExpectedHashString: "3f82fda1ee562d33c192024f209c1189278835f1080af302211d92448893b36e",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{DoubleValueField: &wrappers_pb.DoubleValue{}},
+				&pb3_latest.KnownTypes{DoubleValueField: &wrappers_pb.DoubleValue{Value: 0}},
+			},
+			EquivalentJSONString: "{\"double_value_field\": 0}",
+			EquivalentObject:     map[string]float64{"double_value_field": 0},
+			ExpectedHashString:   "d593d09e840e41b2f5169561acf24a6b094f0dfb6850cf2a6dcea612f8990a41",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{Int64ValueField: &wrappers_pb.Int64Value{}},
+				&pb3_latest.KnownTypes{Int64ValueField: &wrappers_pb.Int64Value{Value: 0}},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   map[string]int64{"int64_value_field": 0},
+			ExpectedHashString: "8459ba1e83e7c72aeb9dcb564daf945f42fe3c1b8837b4266fac7754657160a1",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{StringValueField: &wrappers_pb.StringValue{}},
+				&pb3_latest.KnownTypes{StringValueField: &wrappers_pb.StringValue{Value: ""}},
+			},
+			EquivalentJSONString: "{\"string_value_field\": \"\"}",
+			EquivalentObject:     map[string]string{"string_value_field": ""},
+			ExpectedHashString:   "2ce75d087e557a68b232652d48e6aac5f3fc457c597a0ed07a1b63a4c2d16039",
+		},
+
+		// Wrapper fields set to non-zero values.
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{BoolValueField: &wrappers_pb.BoolValue{Value: true}},
+				&pb3_latest.KnownTypes{BoolValueField: &wrappers_pb.BoolValue{Value: true}},
+			},
+			EquivalentJSONString: "{\"bool_value_field\": true}",
+			EquivalentObject:     map[string]bool{"bool_value_field": true},
+			ExpectedHashString:   "3363c4b1d91d9469bbcca6c255245fba3fdc340722bd0b69c3d1a3dc84ce0d58",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{FloatValueField: &wrappers_pb.FloatValue{Value: 1.5}},
+				&pb3_latest.KnownTypes{FloatValueField: &wrappers_pb.FloatValue{Value: 1.5}},
+			},
+			EquivalentJSONString: "{\"float_value_field\": 1.5}",
+			EquivalentObject:     map[string]float64{"float_value_field": 1.5},
+			ExpectedHashString:   "05f165508cbefc514772f86abb33e6701c26846ae17830c427f9437e2eed8661",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{Int64ValueField: &wrappers_pb.Int64Value{Value: 5}},
+				&pb3_latest.KnownTypes{Int64ValueField: &wrappers_pb.Int64Value{Value: 5}},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   map[string]int64{"int64_value_field": 5},
+			ExpectedHashString: "5cee4583003ca8a7d4235c61091ef8dd6a6c632831a63550c5559e16029070e8",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{Int32ValueField: &wrappers_pb.Int32Value{Value: -5}},
+				&pb3_latest.KnownTypes{Int32ValueField: &wrappers_pb.Int32Value{Value: -5}},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   map[string]int64{"int32_value_field": -5},
+			ExpectedHashString: "abedc92c749f5e5a345060176dd97ee519460063ca6982afd476d8258732c43f",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{Uint64ValueField: &wrappers_pb.UInt64Value{Value: 5}},
+				&pb3_latest.KnownTypes{Uint64ValueField: &wrappers_pb.UInt64Value{Value: 5}},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject:   map[string]uint64{"uint64_value_field": 5},
+			ExpectedHashString: "1bcccd1d84b8124101837d1287fa54584dba2113f06ba013a72d98db4d1a13ef",
+		},
+
+		{
+			Protos: []proto.Message{
+				&pb2_latest.KnownTypes{
+					BoolValueField:   &wrappers_pb.BoolValue{Value: false},
+					Int64ValueField:  &wrappers_pb.Int64Value{Value: 5},
+					StringValueField: &wrappers_pb.StringValue{Value: "foo"},
+				},
+				&pb3_latest.KnownTypes{
+					BoolValueField:   &wrappers_pb.BoolValue{Value: false},
+					Int64ValueField:  &wrappers_pb.Int64Value{Value: 5},
+					StringValueField: &wrappers_pb.StringValue{Value: "foo"},
+				},
+			},
+			// JSON treats all numbers as floats, so it is not possible to have an equivalent JSON string.
+			EquivalentObject: map[string]interface{}{
+				"bool_value_field":   false,
+				"int64_value_field":  int64(5),
+				"string_value_field": "foo",
+			},
+			ExpectedHashString: "dfc5e5ea3d85518abe58c4d3fb3159c110bdd264871617284bd0dcd893f87c52",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc.Check(t, hasher)
+	}
+}
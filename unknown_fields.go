@@ -0,0 +1,148 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"fmt"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// unknownValue is a value of an unknown field, as found in the wire format.
+type unknownValue struct {
+	wireType protowire.Type
+
+	// The value of varint and fixed-size fields.
+	number uint64
+
+	// The value of length-delimited fields.
+	bytes []byte
+}
+
+// parseUnknownFields parses the unknown fields of a message, and returns the
+// values of every tag number in order (see HashUnknownFields).
+//
+// Groups cannot be parsed, since their contents are not a single value. Values
+// whose tag number is that of a known field (ie. values with the wrong wire
+// type) are rejected too, since they would clash with the known field.
+func parseUnknownFields(md protoreflect.MessageDescriptor, unknown protoreflect.RawFields) (map[protowire.Number][]unknownValue, error) {
+	fields := make(map[protowire.Number][]unknownValue)
+	for len(unknown) > 0 {
+		n, t, size := protowire.ConsumeTag(unknown)
+		if size < 0 {
+			return nil, fmt.Errorf("%w: got malformed unknown fields: %v", ErrUnrecognizedFields, protowire.ParseError(size))
+		}
+		unknown = unknown[size:]
+
+		if fd := md.Fields().ByNumber(n); fd != nil {
+			err := fmt.Errorf("%w: got an unknown field with the tag number of the field %q", ErrUnrecognizedFields, fd.Name())
+			return nil, withPathElement(err, unknownFieldLabel(n))
+		}
+
+		v := unknownValue{wireType: t}
+		switch t {
+		case protowire.VarintType:
+			v.number, size = protowire.ConsumeVarint(unknown)
+		case protowire.Fixed32Type:
+			var x uint32
+			x, size = protowire.ConsumeFixed32(unknown)
+			v.number = uint64(x)
+		case protowire.Fixed64Type:
+			v.number, size = protowire.ConsumeFixed64(unknown)
+		case protowire.BytesType:
+			v.bytes, size = protowire.ConsumeBytes(unknown)
+		default:
+			err := fmt.Errorf("%w: got an unknown field with the wire type %d (ex. a group), which cannot be hashed", ErrUnrecognizedFields, t)
+			return nil, withPathElement(err, unknownFieldLabel(n))
+		}
+		if size < 0 {
+			return nil, withPathElement(fmt.Errorf("%w: got a malformed unknown field: %v", ErrUnrecognizedFields, protowire.ParseError(size)), unknownFieldLabel(n))
+		}
+		unknown = unknown[size:]
+
+		fields[n] = append(fields[n], v)
+	}
+	return fields, nil
+}
+
+// unknownFieldEntries returns the hashes of the unknown fields of a message,
+// keyed by their tag numbers (see HashUnknownFields).
+//
+// A tag number with a single value is hashed as that value, while a tag
+// number with several values is hashed as the list of its values.
+func (hasher *objectHasher) unknownFieldEntries(m protoreflect.Message) ([]hashEntry, error) {
+	fields, err := parseUnknownFields(m.Descriptor(), m.GetUnknown())
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make([]protowire.Number, 0, len(fields))
+	for n := range fields {
+		numbers = append(numbers, n)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	entries := make([]hashEntry, 0, len(numbers))
+	for _, n := range numbers {
+		khash, err := hasher.hashInt64(int64(n))
+		if err != nil {
+			return nil, err
+		}
+
+		values := fields[n]
+		hashes := make([][]byte, len(values))
+		for j, v := range values {
+			if hashes[j], err = hasher.hashUnknownValue(v); err != nil {
+				return nil, withPathElement(err, unknownFieldLabel(n))
+			}
+			if len(values) > 1 {
+				hasher.traceLabel(fmt.Sprintf("[%d]", j))
+			}
+		}
+
+		vhash := hashes[0]
+		if len(values) > 1 {
+			if vhash, err = hasher.hashList(hashes); err != nil {
+				return nil, withPathElement(err, unknownFieldLabel(n))
+			}
+		}
+		hasher.traceLabel(unknownFieldLabel(n))
+
+		entries = append(entries, hashEntry{khash: khash, vhash: vhash})
+	}
+	return entries, nil
+}
+
+// hashUnknownValue hashes a value of an unknown field based on its wire type:
+// varints are hashed as signed integers, fixed-size values as unsigned
+// integers and length-delimited values as bytes.
+func (hasher *objectHasher) hashUnknownValue(v unknownValue) ([]byte, error) {
+	switch v.wireType {
+	case protowire.VarintType:
+		return hasher.hashInt64(int64(v.number))
+	case protowire.Fixed32Type, protowire.Fixed64Type:
+		return hasher.hashUint64(v.number)
+	default:
+		return hasher.hashBytes(v.bytes)
+	}
+}
+
+// unknownFieldLabel returns the name of an unknown field within paths, which
+// is its tag number within parentheses (ex. "(7)").
+func unknownFieldLabel(n protowire.Number) string {
+	return fmt.Sprintf("(%d)", n)
+}
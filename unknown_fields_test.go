@@ -0,0 +1,117 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+)
+
+// TestHashUnknownFields checks when the hashes of unknown fields match the
+// hashes of the fields they were before being forgotten.
+func TestHashUnknownFields(t *testing.T) {
+	hasher := NewHasher(HashUnknownFields())
+
+	testCases := []struct {
+		message protoV1.Message
+		matches bool
+	}{
+		{message: &pb2_latest.Int32Message{Value: protoV1.Int32(-5), Values: []int32{1, 2}}, matches: true},
+		{message: &pb2_latest.Int64Message{Value: protoV1.Int64(-1 << 40)}, matches: true},
+		{message: &pb2_latest.Uint32Message{Value: protoV1.Uint32(7)}, matches: true},
+		{message: &pb2_latest.Uint64Message{Value: protoV1.Uint64(1 << 62)}, matches: true},
+		{message: &pb2_latest.Fixed32Message{Value: protoV1.Uint32(1 << 31), Values: []uint32{1, 2, 3}}, matches: true},
+		{message: &pb2_latest.Fixed64Message{Value: protoV1.Uint64(1 << 63)}, matches: true},
+		{message: &pb2_latest.Sfixed64Message{Value: protoV1.Int64(5)}, matches: true},
+		{message: &pb2_latest.Simple{BytesField: []byte("foo"), Int32Field: protoV1.Int32(0)}, matches: true},
+
+		{message: &pb2_latest.Uint64Message{Value: protoV1.Uint64(1 << 63)}, matches: false},
+		{message: &pb2_latest.Sfixed32Message{Value: protoV1.Int32(-5)}, matches: false},
+		{message: &pb2_latest.Sint32Message{Value: protoV1.Int32(5)}, matches: false},
+		{message: &pb2_latest.Int32Message{Values: []int32{1}}, matches: false},
+		{message: &pb2_latest.Simple{StringField: protoV1.String("foo")}, matches: false},
+		{message: &pb2_latest.Simple{BoolField: protoV1.Bool(true)}, matches: false},
+		{message: &pb2_latest.Simple{DoubleField: protoV1.Float64(1.5)}, matches: false},
+		{message: &pb2_latest.Simple{SimpleField: &pb2_latest.Simple{}}, matches: false},
+	}
+
+	for _, tc := range testCases {
+		expected, err := hasher.HashProto(protoV1.MessageV2(tc.message))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := protoV1.Marshal(tc.message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forgotten := &pb2_latest.Empty{}
+		if err := protoV1.Unmarshal(b, forgotten); err != nil {
+			t.Fatal(err)
+		}
+
+		h, err := hasher.HashProto(protoV1.MessageV2(forgotten))
+		if err != nil {
+			t.Errorf("Got an error when hashing the unknown fields of %T{ %[1]v }: %v", tc.message, err)
+			continue
+		}
+		if tree, err := HashProtoTree(hasher, protoV1.MessageV2(forgotten)); err != nil || !bytes.Equal(tree.Hash, h) {
+			t.Errorf("Could not build the hash tree of the unknown fields of %T{ %[1]v }: %v", tc.message, err)
+		}
+		if bytes.Equal(h, expected) != tc.matches {
+			t.Errorf("The hash of the unknown fields of %T{ %[1]v } should match: %v", tc.message, tc.matches)
+		}
+	}
+}
+
+// TestHashUnknownFieldsWithBadFields checks that unknown fields which cannot be
+// hashed result in errors.
+func TestHashUnknownFieldsWithBadFields(t *testing.T) {
+	hasher := NewHasher(HashUnknownFields())
+
+	group := protowire.AppendTag(nil, 100, protowire.StartGroupType)
+	group = protowire.AppendTag(group, 100, protowire.EndGroupType)
+
+	testCases := []struct {
+		message protoV1.Message
+		unknown []byte
+		path    string
+	}{
+		{message: &pb2_latest.Empty{}, unknown: group, path: "(100)"},
+		{message: &pb2_latest.Simple{}, unknown: protowire.AppendBytes(protowire.AppendTag(nil, 1, protowire.BytesType), []byte("foo")), path: "(1)"},
+		{message: &pb2_latest.Empty{}, unknown: protowire.AppendTag(nil, 100, protowire.Fixed64Type), path: "(100)"},
+	}
+
+	for _, tc := range testCases {
+		protoV1.MessageReflect(tc.message).SetUnknown(tc.unknown)
+
+		_, err := hasher.HashProto(protoV1.MessageV2(tc.message))
+		var he *HashError
+		if !errors.As(err, &he) || !errors.Is(err, ErrUnrecognizedFields) || he.Path != tc.path {
+			t.Errorf("Got the error %v for the unknown fields %x, expected %v at %q", err, tc.unknown, ErrUnrecognizedFields, tc.path)
+		}
+
+		verr := Validate(hasher, protoV1.MessageV2(tc.message))
+		if errs, ok := verr.(ValidationErrors); !ok || len(errs) != 1 || errs[0].Path != tc.path {
+			t.Errorf("Got the validation error %v for the unknown fields %x, expected a problem at %q", verr, tc.unknown, tc.path)
+		}
+	}
+}
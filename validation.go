@@ -0,0 +1,221 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ValidationErrors is the error returned by Validate, which lists every
+// problem that was found within a message.
+type ValidationErrors []*HashError
+
+func (e ValidationErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "found %d problems:", len(e))
+	for _, err := range e {
+		fmt.Fprintf(b, "\n- %v", err)
+	}
+	return b.String()
+}
+
+// Unwrap returns the listed errors, so that they can be checked with errors.Is
+// and errors.As.
+func (e ValidationErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Validate checks whether a message can be hashed, and returns every problem
+// that prevents it from being hashed as ValidationErrors, sorted by path. This
+// is unlike the hashers, which stop at the first problem.
+//
+// The message is checked using the same rules as when it gets hashed: it must
+// not contain required fields or fields with explicit defaults (unless the
+// hasher supports them, see RequiredFieldsAsOrdinaryFields and
+// ExplicitDefaultsAsOrdinaryFields), extendable messages (or unregistered
+// extensions, see ExtensionResolver), unrecognized fields, nil messages within
+// repeated fields or maps, nor malformed oneofs. Well-known types are checked
+// by hashing them, so only their first problem is reported.
+//
+// The hasher must be one returned by NewHasher, since the options of the
+// hasher affect which messages can be hashed (ex. AnyResolver).
+func Validate(hasher ProtoHasher, pb proto.Message) error {
+	oh, ok := hasher.(*objectHasher)
+	if !ok {
+		return fmt.Errorf("validation is not supported by %T", hasher)
+	}
+
+	// Nil messages are hashed as nil.
+	if pb == nil || !pb.ProtoReflect().IsValid() {
+		return nil
+	}
+
+	pb, err := oh.withoutIgnoredFields(pb)
+	if err != nil {
+		return err
+	}
+
+	v := validator{hasher: oh}
+	v.validateMessage(pb.ProtoReflect(), "")
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		return v.errs[i].Path < v.errs[j].Path
+	})
+	return v.errs
+}
+
+// validator collects the problems found within a message (see Validate).
+type validator struct {
+	hasher *objectHasher
+	errs   ValidationErrors
+}
+
+// report records a problem with the value at the given path, which is within
+// a message of the given type.
+func (v *validator) report(err error, path string, md protoreflect.MessageDescriptor) {
+	he, ok := err.(*HashError)
+	if !ok {
+		he = &HashError{Reason: err}
+	}
+
+	he.Path = joinPath(path, he.Path)
+	if he.MessageType == "" {
+		he.MessageType = md.FullName()
+	}
+	v.errs = append(v.errs, he)
+}
+
+func (v *validator) validateMessage(m protoreflect.Message, path string) {
+	md := m.Descriptor()
+
+	if name, ok := CheckWellKnownType(md); ok {
+		if _, err := v.hasher.hashWellKnownType(name, m); err != nil {
+			v.report(err, path, md)
+		}
+		return
+	}
+	if v.hasher.isProtoJSONFieldMask(md) {
+		if _, err := v.hasher.hashProtoJSONFieldMask(m); err != nil {
+			v.report(err, path, md)
+		}
+		return
+	}
+
+	// Unknown fields are only reported once any extension data among them has
+	// been parsed.
+	resolved, extensions, err := v.hasher.messageExtensions(m)
+	if err != nil {
+		v.report(err, path, md)
+	} else {
+		m = resolved
+	}
+	if len(m.GetUnknown()) > 0 && !errors.Is(err, ErrUnregisteredExtension) {
+		if !v.hasher.hashUnknownFields {
+			v.report(ErrUnrecognizedFields, path, md)
+		} else if _, err := parseUnknownFields(md, m.GetUnknown()); err != nil {
+			v.report(err, path, md)
+		}
+	}
+	for _, err := range malformedOneOfs(m) {
+		v.report(err, path, md)
+	}
+
+	fields := md.Fields()
+	fds := make([]protoreflect.FieldDescriptor, 0, fields.Len()+len(extensions))
+	for i := 0; i < fields.Len(); i++ {
+		fds = append(fds, fields.Get(i))
+	}
+	fds = append(fds, extensions...)
+
+	for _, fd := range fds {
+		fieldPath := joinPath(path, fieldLabel(fd))
+
+		// Required fields are rejected whether they're set or not, and so are
+		// fields with explicit defaults, unless they're unset oneof fields (or
+		// unless the hasher supports them), as well as fields that are meant to
+		// be hashed as sets but are not repeated fields.
+		if fd.Cardinality() == protoreflect.Required && v.hasher.requiredFieldPolicy == requiredFieldsAsErrors {
+			v.report(ErrRequiredField, fieldPath, md)
+		}
+		if fd.HasDefault() && (fd.ContainingOneof() == nil || m.Has(fd)) && v.hasher.explicitDefaultPolicy == explicitDefaultsAsErrors {
+			v.report(ErrExplicitDefault, fieldPath, md)
+		}
+		if err := v.hasher.failIfNotRepeatedField(fd); err != nil {
+			v.report(err, fieldPath, md)
+		}
+
+		if v.hasher.isUnset(m, fd) || annotationsOf(fd).ignore {
+			continue
+		}
+
+		val := m.Get(fd)
+		switch {
+		case fd.IsList():
+			list := val.List()
+			for j := 0; j < list.Len(); j++ {
+				v.validateValue(fd, list.Get(j), joinPath(fieldPath, fmt.Sprintf("[%d]", j)), md, "in a repeated field")
+			}
+		case fd.IsMap():
+			val.Map().Range(func(key protoreflect.MapKey, val protoreflect.Value) bool {
+				keyPath := joinPath(fieldPath, mapKeyLabel(key))
+				if _, err := v.hasher.hashMapKey(fd.MapKey(), key); err != nil {
+					v.report(err, keyPath, md)
+				}
+				v.validateValue(fd.MapValue(), val, keyPath, md, "in a map field")
+				return true
+			})
+		default:
+			v.validateValue(fd, val, fieldPath, md, "as a value of a oneof field")
+		}
+	}
+}
+
+// validateValue checks a single value of a field of a message of the given
+// type. Messages must not be nil messages, while scalars only need to be
+// checked when messages are hashed like their JSON format (ex. strings must be
+// valid UTF-8), by hashing them.
+func (v *validator) validateValue(fd protoreflect.FieldDescriptor, val protoreflect.Value, path string, md protoreflect.MessageDescriptor, where string) {
+	if k := fd.Kind(); k != protoreflect.MessageKind && k != protoreflect.GroupKind {
+		if v.hasher.protoJSONCompatible {
+			if _, err := v.hasher.hashValue(fd, val); err != nil {
+				v.report(err, path, md)
+			}
+		}
+		return
+	}
+
+	if isNilMessage(val) {
+		v.report(fmt.Errorf("%w %s, which is invalid", ErrNilMessage, where), path, md)
+		return
+	}
+	v.validateMessage(val.Message(), path)
+}
@@ -0,0 +1,168 @@
+// Copyright 2017 The ObjectHash-Proto Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package protohash
+
+import (
+	"errors"
+	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	any_pb "github.com/golang/protobuf/ptypes/any"
+	duration_pb "github.com/golang/protobuf/ptypes/duration"
+	_struct "github.com/golang/protobuf/ptypes/struct"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	pb2_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto2"
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// sentinels are the errors wrapped by the errors returned by hashers.
+var sentinels = []error{
+	ErrRequiredField,
+	ErrExplicitDefault,
+	ErrNotRepeatedField,
+	ErrExtendableMessage,
+	ErrUnregisteredExtension,
+	ErrUnrecognizedFields,
+	ErrNilMessage,
+	ErrMalformedOneof,
+	ErrUnsupportedType,
+	ErrUnresolvableAny,
+	ErrInvalidWellKnownType,
+}
+
+// TestValidate checks that Validate reports every problem within a message.
+func TestValidate(t *testing.T) {
+	hasher := NewHasher()
+
+	withUnknownFields := &pb3_latest.Simple{}
+	protoV1.MessageReflect(withUnknownFields).SetUnknown(protowire.AppendVarint(protowire.AppendTag(nil, 1000, protowire.VarintType), 1))
+
+	type problem struct {
+		path   string
+		reason error
+	}
+
+	testCases := []struct {
+		message  protoV1.Message
+		problems []problem
+	}{
+		{
+			message:  &pb3_latest.Simple{StringField: "foo", SimpleField: &pb3_latest.Simple{BoolField: true}},
+			problems: nil,
+		},
+		{
+			message: &pb3_latest.Simple{
+				SimpleField:     withUnknownFields,
+				RepetitiveField: &pb3_latest.Repetitive{SimpleField: []*pb3_latest.Simple{nil, {}, nil}},
+				SingletonField:  &pb3_latest.Singleton{Singleton: &pb3_latest.Singleton_TheSimple{}},
+			},
+			problems: []problem{
+				{path: "repetitive_field.simple_field[0]", reason: ErrNilMessage},
+				{path: "repetitive_field.simple_field[2]", reason: ErrNilMessage},
+				{path: "simple_field", reason: ErrUnrecognizedFields},
+				{path: "singleton_field.the_simple", reason: ErrNilMessage},
+			},
+		},
+		{
+			message: &pb2_latest.IntMaps{IntToSimple: map[int64]*pb2_latest.Simple{
+				1: nil,
+				2: {SingletonField: &pb2_latest.Singleton{Singleton: (*pb2_latest.Singleton_TheString)(nil)}},
+			}},
+			problems: []problem{
+				{path: "int_to_simple[1]", reason: ErrNilMessage},
+				{path: "int_to_simple[2].singleton_field.singleton", reason: ErrMalformedOneof},
+			},
+		},
+		{
+			message:  &pb2_latest.BadWithRequirements{},
+			problems: []problem{{path: "text", reason: ErrRequiredField}},
+		},
+		{
+			message:  &pb2_latest.BadWithDefaults{},
+			problems: []problem{{path: "text", reason: ErrExplicitDefault}},
+		},
+		{
+			message:  &pb2_latest.BadWithExtensions{},
+			problems: []problem{{path: "", reason: ErrExtendableMessage}},
+		},
+		{
+			message: &pb3_latest.KnownTypes{
+				AnyField:      &any_pb.Any{},
+				DurationField: &duration_pb.Duration{Seconds: -1, Nanos: 1},
+				StructField:   &_struct.Struct{Fields: map[string]*_struct.Value{"foo": {}}},
+			},
+			problems: []problem{
+				{path: "any_field", reason: ErrUnsupportedType},
+				{path: "duration_field", reason: ErrInvalidWellKnownType},
+				{path: "struct_field[foo]", reason: ErrInvalidWellKnownType},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		err := Validate(hasher, protoV1.MessageV2(tc.message))
+		_, hashErr := hasher.HashProto(protoV1.MessageV2(tc.message))
+
+		if len(tc.problems) == 0 {
+			if err != nil || hashErr != nil {
+				t.Errorf("Validating %T{ %[1]v } returned an error: %v (hashing returned: %v)", tc.message, err, hashErr)
+			}
+			continue
+		}
+
+		var errs ValidationErrors
+		if !errors.As(err, &errs) {
+			t.Errorf("Validating %T{ %[1]v } should have returned ValidationErrors, instead got: %v", tc.message, err)
+			continue
+		}
+		if len(errs) != len(tc.problems) {
+			t.Errorf("Validating %T{ %[1]v } returned the wrong number of problems: %v", tc.message, err)
+			continue
+		}
+		for i, p := range tc.problems {
+			if errs[i].Path != p.path || !errors.Is(errs[i], p.reason) {
+				t.Errorf("Validating %T{ %[1]v } returned the wrong problem. Got %q: %v, expected %q: %v", tc.message, errs[i].Path, errs[i].Reason, p.path, p.reason)
+			}
+		}
+
+		// The reason of the error returned by hashing must be among the listed
+		// problems.
+		for _, reason := range sentinels {
+			if errors.Is(hashErr, reason) && !errors.Is(err, reason) {
+				t.Errorf("Validating %T{ %[1]v } did not report the error returned by hashing: %v", tc.message, hashErr)
+			}
+		}
+	}
+}
+
+// TestValidationErrorsMessage checks the message of ValidationErrors.
+func TestValidationErrorsMessage(t *testing.T) {
+	errs := ValidationErrors{
+		{Path: "foo", MessageType: "example.Message", Reason: ErrRequiredField},
+		{Path: "bar[1]", MessageType: "example.Message", Reason: ErrNilMessage},
+	}
+
+	expected := "found 2 problems:\n" +
+		"- foo (in example.Message): required fields are not allowed because they're bad for backwards compatibility\n" +
+		"- bar[1] (in example.Message): got a nil message"
+	if actual := errs.Error(); actual != expected {
+		t.Errorf("Got the wrong error message.\nActual:\n%s\nExpected:\n%s", actual, expected)
+	}
+
+	if actual := errs[:1].Error(); actual != errs[0].Error() {
+		t.Errorf("Got the wrong error message for a single problem: %s", actual)
+	}
+}
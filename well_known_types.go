@@ -15,30 +15,103 @@
 package protohash
 
 import (
-	"bytes"
 	"fmt"
-	"reflect"
+	"math"
+	"sort"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 // Supported well-known types.
 const (
+	anyType   string = "Any"
+	duration  string = "Duration"
 	timestamp string = "Timestamp"
+
+	// Wrapper types.
+	boolValue   string = "BoolValue"
+	bytesValue  string = "BytesValue"
+	doubleValue string = "DoubleValue"
+	floatValue  string = "FloatValue"
+	int32Value  string = "Int32Value"
+	int64Value  string = "Int64Value"
+	stringValue string = "StringValue"
+	uint32Value string = "UInt32Value"
+	uint64Value string = "UInt64Value"
+
+	// JSON-like types.
+	listValue   string = "ListValue"
+	structValue string = "Struct"
+	value       string = "Value"
+)
+
+// Valid ranges of google.protobuf.Duration fields, as documented in
+// google/protobuf/duration.proto.
+const (
+	maxDurationSeconds int64 = 315576000000
+	maxDurationNanos   int64 = 999999999
+)
+
+// anyHashingMode specifies how google.protobuf.Any messages get hashed.
+type anyHashingMode int
+
+const (
+	// Any messages cannot be hashed.
+	anyUnsupported anyHashingMode = iota
+
+	// Any messages are hashed using the hash of the message they embed.
+	// See AnyResolver.
+	anyAsEmbeddedMessage
+
+	// Any messages are hashed using their type URL and serialized bytes.
+	// See AnyAsTypeURLAndBytes.
+	anyAsTypeURLAndBytes
 )
 
 // hashWellKnownType hashes proto messages that are Well-known types.
 //
-// This method uses the reflect.Value of a well-known type's underlying struct
-// object to calculate its hash.
-//
 // Well-known types are proto messages that have special semantics which are
 // defined within the proto library. As a result, special treatment while
 // calculating their hash is often (but not always) needed.
-func (hasher *objectHasher) hashWellKnownType(name string, sv reflect.Value) ([]byte, error) {
-	if name == timestamp {
-		return hasher.hashTimestamp(sv)
+func (hasher *objectHasher) hashWellKnownType(name string, m protoreflect.Message) ([]byte, error) {
+	if err := hasher.failIfUnsupportedWellKnownType(name); err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case anyType:
+		return hasher.hashAny(m)
+	case duration:
+		return hasher.hashDuration(m)
+	case timestamp:
+		return hasher.hashTimestamp(m)
+	case listValue:
+		return hasher.hashListValue(m)
+	case structValue:
+		return hasher.hashStructValue(m)
+	case value:
+		return hasher.hashJSONValue(m)
+	default: // Wrapper types.
+		return hasher.hashWrapper(name, m)
+	}
+}
+
+// failIfUnsupportedWellKnownType returns an error if the hasher cannot hash the
+// well-known type with the provided short name (ex. "Timestamp").
+func (hasher *objectHasher) failIfUnsupportedWellKnownType(name string) error {
+	switch name {
+	case anyType:
+		if hasher.anyHashingMode != anyUnsupported {
+			return nil
+		}
+	case duration, timestamp, listValue, structValue, value,
+		boolValue, bytesValue, doubleValue, floatValue, int32Value, int64Value, stringValue, uint32Value, uint64Value:
+		return nil
 	}
 
-	return nil, fmt.Errorf("Got a currently unsupported protobuf well-known type: %s", name)
+	return fmt.Errorf("%w: google.protobuf.%s is currently unsupported", ErrUnsupportedType, name)
 }
 
 // hashTimestamp calculates the object hash of a google.protobuf.Timestamp.
@@ -56,29 +129,394 @@ func (hasher *objectHasher) hashWellKnownType(name string, sv reflect.Value) ([]
 // messages, where unset/zero fields must be considered to be unset, because
 // they're indistinguishable in the general case.
 //
-// Note that this function's argument is a reflect.Value of the underlying
-// struct object, rather than the proto message itself.
-func (hasher *objectHasher) hashTimestamp(sv reflect.Value) ([]byte, error) {
-	sk := sv.Kind()
-	if sk != reflect.Struct {
-		return nil, fmt.Errorf("Got a bad google.protobuf.Timestamp proto: %v. Expected a Struct, instead got a %s", sv, sk)
+// When messages are hashed like their JSON format, the timestamp is hashed as
+// a string instead (see hashProtoJSONTimestamp).
+func (hasher *objectHasher) hashTimestamp(m protoreflect.Message) ([]byte, error) {
+	seconds, nanos, err := secondsAndNanos(m)
+	if err != nil {
+		return nil, err
+	}
+	if hasher.protoJSONCompatible {
+		return hasher.hashProtoJSONTimestamp(seconds, nanos)
 	}
+	return hasher.hashSecondsAndNanos(seconds, nanos)
+}
 
-	b := new(bytes.Buffer)
+// hashDuration calculates the object hash of a google.protobuf.Duration.
+//
+// This will be equivalent to the ObjectHash of a list of two integers, where
+// the first list item is the duration's signed number of seconds, and the
+// second list item is the duration's signed fractions of a second at
+// nanosecond resolution. For example, a duration of -1.5 seconds has the same
+// ObjectHash as the list [-1, -500000000].
+//
+// Durations are validated before being hashed: the seconds must be within
+// +/-315,576,000,000 (about 10,000 years), the nanos must be within
+// +/-999,999,999, and the two fields must not have opposite signs.
+//
+// Just like timestamps, an unset duration is one where the proto itself is
+// nil, while an explicitly set duration with unset fields is considered to be
+// explicitly set to 0.
+//
+// When messages are hashed like their JSON format, the duration is hashed as
+// a string instead (see hashProtoJSONDuration).
+func (hasher *objectHasher) hashDuration(m protoreflect.Message) ([]byte, error) {
+	seconds, nanos, err := secondsAndNanos(m)
+	if err != nil {
+		return nil, err
+	}
 
-	// Hash seconds and nanoseconds.
-	for _, field := range []string{"Seconds", "Nanos"} {
-		fieldValue := sv.FieldByName(field)
-		fk := fieldValue.Kind()
-		if fk != reflect.Int64 && fk != reflect.Int32 {
-			return nil, fmt.Errorf("Got a google.protobuf.Timestamp proto with a bad '%s' field: %v. Expected an integer, instead got a %s", field, sv, fk)
+	if seconds < -maxDurationSeconds || seconds > maxDurationSeconds {
+		return nil, fmt.Errorf("%w: a google.protobuf.Duration proto with out of range seconds: %d", ErrInvalidWellKnownType, seconds)
+	}
+	if nanos < -maxDurationNanos || nanos > maxDurationNanos {
+		return nil, fmt.Errorf("%w: a google.protobuf.Duration proto with out of range nanos: %d", ErrInvalidWellKnownType, nanos)
+	}
+	if (seconds < 0 && nanos > 0) || (seconds > 0 && nanos < 0) {
+		return nil, fmt.Errorf("%w: a google.protobuf.Duration proto with mixed signs: seconds=%d, nanos=%d", ErrInvalidWellKnownType, seconds, nanos)
+	}
+
+	if hasher.protoJSONCompatible {
+		return hasher.hashProtoJSONDuration(seconds, nanos)
+	}
+	return hasher.hashSecondsAndNanos(seconds, nanos)
+}
+
+// wellKnownTypeField returns the descriptor of a field of a well-known type,
+// after checking that it is a singular field of one of the expected kinds.
+//
+// Repeated fields and maps are only accepted when the expected kind is a
+// message kind, in which case the caller is responsible for checking them.
+func wellKnownTypeField(m protoreflect.Message, name protoreflect.Name, kinds ...protoreflect.Kind) (protoreflect.FieldDescriptor, error) {
+	md := m.Descriptor()
+
+	fd := md.Fields().ByName(name)
+	if fd == nil {
+		return nil, fmt.Errorf("%w: a %s proto without a '%s' field", ErrInvalidWellKnownType, md.FullName(), name)
+	}
+
+	for _, k := range kinds {
+		if fd.Kind() == k {
+			return fd, nil
 		}
-		h, err := hashInt64(fieldValue.Int())
+	}
+	return nil, fmt.Errorf("%w: a %s proto with a bad '%s' field. Expected one of %v, instead got a %v", ErrInvalidWellKnownType, md.FullName(), name, kinds, fd.Kind())
+}
+
+// secondsAndNanos extracts the values of the "seconds" and "nanos" fields of a
+// Timestamp or Duration proto.
+func secondsAndNanos(m protoreflect.Message) (seconds int64, nanos int64, err error) {
+	values := make([]int64, 2)
+	for i, name := range []protoreflect.Name{"seconds", "nanos"} {
+		fd, err := wellKnownTypeField(m, name, protoreflect.Int64Kind, protoreflect.Int32Kind)
+		if err != nil {
+			return 0, 0, err
+		}
+		if fd.IsList() {
+			return 0, 0, fmt.Errorf("%w: a %s proto with a repeated '%s' field", ErrInvalidWellKnownType, m.Descriptor().FullName(), name)
+		}
+		values[i] = m.Get(fd).Int()
+	}
+
+	return values[0], values[1], nil
+}
+
+// hashSecondsAndNanos returns the ObjectHash of the list [seconds, nanos].
+func (hasher *objectHasher) hashSecondsAndNanos(seconds, nanos int64) ([]byte, error) {
+	hashes := make([][]byte, 2)
+
+	// Hash seconds and nanoseconds.
+	for i, v := range []int64{seconds, nanos} {
+		h, err := hasher.hashInt64(v)
 		if err != nil {
 			return nil, err
 		}
-		b.Write(h[:])
+		hasher.traceLabel([]string{"seconds", "nanos"}[i])
+		hashes[i] = h
 	}
 
-	return hash(listIdentifier, b.Bytes())
+	return hasher.hashList(hashes)
+}
+
+// hashWrapper calculates the object hash of a wrapper type (ex.
+// google.protobuf.Int64Value).
+//
+// Wrappers are hashed as the value they wrap. For example, an Int64Value
+// containing 5 has the same ObjectHash as the integer 5.
+//
+// Similar to timestamps, the distinction between unset and zero happens at the
+// message level. A wrapper field that is unset (ie. nil) does not contribute
+// to the hash of its parent message, while a wrapper that is explicitly set
+// with a zero value is hashed as that zero value. This makes it possible to
+// tell apart an explicitly set zero from an unset value, which is the main
+// reason for using wrappers in the first place.
+func (hasher *objectHasher) hashWrapper(name string, m protoreflect.Message) ([]byte, error) {
+	fd := m.Descriptor().Fields().ByName("value")
+	if fd == nil {
+		return nil, fmt.Errorf("%w: a google.protobuf.%s proto without a 'value' field", ErrInvalidWellKnownType, name)
+	}
+
+	// The wrapped value is never considered unset, even if it is a zero value.
+	if fd.IsList() || fd.IsMap() {
+		return nil, fmt.Errorf("%w: a google.protobuf.%s proto with a repeated 'value' field", ErrInvalidWellKnownType, name)
+	}
+	if k := fd.Kind(); k == protoreflect.MessageKind || k == protoreflect.GroupKind {
+		return nil, fmt.Errorf("%w: a google.protobuf.%s proto with a bad 'value' field. Expected a scalar, instead got a %v", ErrInvalidWellKnownType, name, k)
+	}
+
+	return hasher.hashValue(fd, m.Get(fd))
+}
+
+// hashStructValue calculates the object hash of a google.protobuf.Struct.
+//
+// A Struct represents a JSON object, so it is hashed as an ObjectHash
+// dictionary that maps each key (as a unicode string) to its value. This makes
+// the hash of a Struct equal to the ObjectHash of the equivalent JSON object.
+//
+// Note that the map identifier is always used for Struct protos, even when a
+// custom MessageIdentifier is set, because they represent JSON objects rather
+// than proto messages.
+func (hasher *objectHasher) hashStructValue(m protoreflect.Message) ([]byte, error) {
+	fd, err := wellKnownTypeField(m, "fields", protoreflect.MessageKind)
+	if err != nil {
+		return nil, err
+	}
+	if !fd.IsMap() || fd.MapKey().Kind() != protoreflect.StringKind {
+		return nil, fmt.Errorf("%w: a google.protobuf.Struct proto with a bad 'fields' field. Expected a map with string keys, instead got %v", ErrInvalidWellKnownType, fd.Cardinality())
+	}
+
+	fields := m.Get(fd).Map()
+	mapHashEntries := make([]hashEntry, 0, fields.Len())
+	fields.Range(func(key protoreflect.MapKey, val protoreflect.Value) bool {
+		var khash, vhash []byte
+		khash, err = hasher.hashJSONString(key.String())
+		if err != nil {
+			err = withPathElement(err, mapKeyLabel(key))
+			return false
+		}
+
+		vhash, err = hasher.hashNestedJSONValue(val)
+		if err != nil {
+			err = withPathElement(err, mapKeyLabel(key))
+			return false
+		}
+		hasher.traceLabel(mapKeyLabel(key))
+
+		mapHashEntries = append(mapHashEntries, hashEntry{khash: khash, vhash: vhash})
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Sort(byKHash(mapHashEntries))
+	return hasher.hashEntries(mapIdentifier, mapHashEntries)
+}
+
+// hashListValue calculates the object hash of a google.protobuf.ListValue.
+//
+// A ListValue represents a JSON array, so it is hashed as an ObjectHash list
+// of its values. This makes the hash of a ListValue equal to the ObjectHash of
+// the equivalent JSON array.
+func (hasher *objectHasher) hashListValue(m protoreflect.Message) ([]byte, error) {
+	fd, err := wellKnownTypeField(m, "values", protoreflect.MessageKind)
+	if err != nil {
+		return nil, err
+	}
+	if !fd.IsList() {
+		return nil, fmt.Errorf("%w: a google.protobuf.ListValue proto with a bad 'values' field. Expected a repeated field, instead got %v", ErrInvalidWellKnownType, fd.Cardinality())
+	}
+
+	values := m.Get(fd).List()
+	hashes := make([][]byte, values.Len())
+	for i := 0; i < values.Len(); i++ {
+		h, err := hasher.hashNestedJSONValue(values.Get(i))
+		if err != nil {
+			return nil, withPathElement(err, fmt.Sprintf("[%d]", i))
+		}
+		hasher.traceLabel(fmt.Sprintf("[%d]", i))
+		hashes[i] = h
+	}
+	return hasher.hashList(hashes)
+}
+
+// jsonValueKinds maps the fields of the "kind" oneof of google.protobuf.Value
+// to their expected kinds.
+var jsonValueKinds = map[protoreflect.Name]protoreflect.Kind{
+	"null_value":   protoreflect.EnumKind,
+	"number_value": protoreflect.DoubleKind,
+	"string_value": protoreflect.StringKind,
+	"bool_value":   protoreflect.BoolKind,
+	"struct_value": protoreflect.MessageKind,
+	"list_value":   protoreflect.MessageKind,
+}
+
+// hashJSONValue calculates the object hash of a google.protobuf.Value.
+//
+// A Value represents a JSON value, so it is hashed as the ObjectHash of that
+// JSON value:
+// - A null_value is hashed as nil.
+// - A number_value is hashed as a float.
+// - A string_value is hashed as a unicode string.
+// - A bool_value is hashed as a boolean.
+// - A struct_value is hashed as a dictionary (see hashStructValue).
+// - A list_value is hashed as a list (see hashListValue).
+//
+// A Value that does not have any of its kinds set has no JSON equivalent, and
+// results in an error.
+func (hasher *objectHasher) hashJSONValue(m protoreflect.Message) ([]byte, error) {
+	od := m.Descriptor().Oneofs().ByName("kind")
+	if od == nil {
+		return nil, fmt.Errorf("%w: a google.protobuf.Value proto without a 'kind' oneof", ErrInvalidWellKnownType)
+	}
+
+	fd := m.WhichOneof(od)
+	if fd == nil {
+		return nil, fmt.Errorf("%w: a google.protobuf.Value proto without any kind of value set", ErrInvalidWellKnownType)
+	}
+	if expected, ok := jsonValueKinds[fd.Name()]; !ok || fd.Kind() != expected || fd.IsList() {
+		return nil, fmt.Errorf("%w: a google.protobuf.Value proto with an unsupported kind: %s", ErrInvalidWellKnownType, fd.Name())
+	}
+	v := m.Get(fd)
+
+	switch fd.Name() {
+	case "null_value":
+		return hasher.hashNil()
+	case "number_value":
+		// Only finite numbers have a JSON equivalent.
+		if f := v.Float(); hasher.protoJSONCompatible && (math.IsNaN(f) || math.IsInf(f, 0)) {
+			return nil, fmt.Errorf("%w: a google.protobuf.Value proto with a number_value that has no JSON equivalent: %v", ErrInvalidWellKnownType, f)
+		}
+		return hasher.hashFloat(v.Float())
+	case "string_value":
+		return hasher.hashJSONString(v.String())
+	case "bool_value":
+		return hasher.hashBool(v.Bool())
+	case "struct_value":
+		if !v.Message().IsValid() {
+			return nil, fmt.Errorf("%w as the struct_value of a google.protobuf.Value proto, which is invalid", ErrNilMessage)
+		}
+		return hasher.hashStructValue(v.Message())
+	default: // "list_value"
+		if !v.Message().IsValid() {
+			return nil, fmt.Errorf("%w as the list_value of a google.protobuf.Value proto, which is invalid", ErrNilMessage)
+		}
+		return hasher.hashListValue(v.Message())
+	}
+}
+
+// hashNestedJSONValue calculates the object hash of a google.protobuf.Value,
+// as found within Struct and ListValue protos.
+func (hasher *objectHasher) hashNestedJSONValue(v protoreflect.Value) ([]byte, error) {
+	if isNilMessage(v) {
+		return nil, fmt.Errorf("%w as a google.protobuf.Value, which is invalid", ErrNilMessage)
+	}
+	return hasher.hashJSONValue(v.Message())
+}
+
+// hashAny calculates the object hash of a google.protobuf.Any.
+//
+// Depending on the options of the hasher, this will either be equivalent to
+// the ObjectHash of a message whose "value" field is set to the message
+// embedded within the Any (see AnyResolver), or to the ObjectHash of the Any
+// message as a regular message (see AnyAsTypeURLAndBytes). With the former,
+// the Any is hashed like its JSON format when messages are (see
+// ProtoJSONCompatible).
+func (hasher *objectHasher) hashAny(m protoreflect.Message) ([]byte, error) {
+	switch hasher.anyHashingMode {
+	case anyAsTypeURLAndBytes:
+		return hasher.hashStructFields(m, nil)
+	case anyAsEmbeddedMessage:
+		// Handled below.
+	default:
+		return nil, fmt.Errorf("%w: google.protobuf.%s is currently unsupported", ErrUnsupportedType, anyType)
+	}
+
+	typeURLField, err := wellKnownTypeField(m, "type_url", protoreflect.StringKind)
+	if err != nil {
+		return nil, err
+	}
+	valueField, err := wellKnownTypeField(m, "value", protoreflect.BytesKind)
+	if err != nil {
+		return nil, err
+	}
+	if typeURLField.IsList() || valueField.IsList() {
+		return nil, fmt.Errorf("%w: a google.protobuf.Any proto with repeated fields", ErrInvalidWellKnownType)
+	}
+	typeURL := m.Get(typeURLField).String()
+
+	// An empty Any is an empty object in the JSON format.
+	if hasher.protoJSONCompatible && typeURL == "" && len(m.Get(valueField).Bytes()) == 0 {
+		return hasher.hashEntries(hasher.messageTypeIdentifier(), nil)
+	}
+
+	embedded, err := hasher.resolveAny(typeURL)
+	if err != nil {
+		return nil, err
+	}
+	// Missing required fields are only an error when required fields are.
+	opts := proto.UnmarshalOptions{AllowPartial: hasher.requiredFieldPolicy != requiredFieldsAsErrors}
+	if err = opts.Unmarshal(m.Get(valueField).Bytes(), embedded); err != nil {
+		return nil, fmt.Errorf("%w: could not unmarshal the value of a google.protobuf.Any proto with type URL %q: %v", ErrInvalidWellKnownType, typeURL, err)
+	}
+
+	if hasher.protoJSONCompatible {
+		return hasher.hashProtoJSONAny(typeURL, embedded.ProtoReflect())
+	}
+
+	// Each key is hashed right before its value, which keeps the entries
+	// together in hash trees (see HashProtoTree).
+	typeURLKey, err := hasher.hashFieldKey(typeURLField)
+	if err != nil {
+		return nil, err
+	}
+	typeURLHash, err := hasher.hashUnicode(typeURL)
+	if err != nil {
+		return nil, err
+	}
+	hasher.traceLabel(string(typeURLField.Name()))
+
+	valueKey, err := hasher.hashFieldKey(valueField)
+	if err != nil {
+		return nil, err
+	}
+	valueHash, err := hasher.hashStruct(embedded.ProtoReflect())
+	if err != nil {
+		return nil, withPathElement(err, string(valueField.Name()))
+	}
+	hasher.traceLabel(string(valueField.Name()))
+
+	entries := []hashEntry{
+		{khash: typeURLKey, vhash: typeURLHash},
+		{khash: valueKey, vhash: valueHash},
+	}
+	sort.Sort(byKHash(entries))
+	return hasher.hashEntries(hasher.messageTypeIdentifier(), entries)
+}
+
+// resolveAny returns an empty proto message of the type referred to by the
+// type URL of a google.protobuf.Any message.
+func (hasher *objectHasher) resolveAny(typeURL string) (proto.Message, error) {
+	if typeURL == "" {
+		return nil, fmt.Errorf("%w: a google.protobuf.Any proto with an empty type URL, which cannot be resolved", ErrInvalidWellKnownType)
+	}
+
+	if hasher.anyResolver != nil {
+		m, err := hasher.anyResolver.Resolve(typeURL)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %q: %w", ErrUnresolvableAny, typeURL, err)
+		}
+		if m == nil || !m.ProtoReflect().IsValid() {
+			return nil, fmt.Errorf("%w: %q: the resolver returned a nil message", ErrUnresolvableAny, typeURL)
+		}
+		return m, nil
+	}
+
+	// Use the messages registered with the proto library. The message name is
+	// whatever comes after the last slash of the type URL.
+	mt, err := protoregistry.GlobalTypes.FindMessageByURL(typeURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %w", ErrUnresolvableAny, typeURL, err)
+	}
+	return mt.New().Interface(), nil
 }
@@ -15,32 +15,259 @@
 package protohash
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"reflect"
+	"sort"
 	"testing"
+
+	protoV1 "github.com/golang/protobuf/proto"
+	any_pb "github.com/golang/protobuf/ptypes/any"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	pb3_latest "github.com/deepmind/objecthash-proto/test_protos/generated/latest/proto3"
+)
+
+// badWellKnownType returns an empty dynamic message with the supplied name
+// and fields, which is placed within the well-known types package.
+//
+// This is used for creating malformed well-known types.
+func badWellKnownType(t *testing.T, name string, fields ...*descriptorpb.FieldDescriptorProto) protoreflect.Message {
+	t.Helper()
+
+	msg := &descriptorpb.DescriptorProto{Name: protoV1.String(name), Field: fields}
+	for _, f := range fields {
+		if f.OneofIndex != nil {
+			msg.OneofDecl = []*descriptorpb.OneofDescriptorProto{{Name: protoV1.String("kind")}}
+		}
+	}
+
+	fd, err := protodesc.NewFile(&descriptorpb.FileDescriptorProto{
+		Name:        protoV1.String("bad_well_known_types/" + name + ".proto"),
+		Package:     protoV1.String("google.protobuf"),
+		Syntax:      protoV1.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{msg},
+	}, new(protoregistry.Files))
+	if err != nil {
+		t.Fatalf("Could not create a descriptor for a bad %s: %v", name, err)
+	}
+	return dynamicpb.NewMessage(fd.Messages().Get(0))
+}
+
+// field returns the descriptor of a singular field with the supplied name,
+// tag number and type.
+func field(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	return &descriptorpb.FieldDescriptorProto{
+		Name:     protoV1.String(name),
+		JsonName: protoV1.String(name),
+		Number:   protoV1.Int32(number),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     typ.Enum(),
+	}
+}
+
+// repeatedField returns the descriptor of a repeated field with the supplied
+// name, tag number and type.
+func repeatedField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	f := field(name, number, typ)
+	f.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	return f
+}
+
+// oneofField returns the descriptor of a field with the supplied name, tag
+// number and type, which is part of the "kind" oneof.
+func oneofField(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type) *descriptorpb.FieldDescriptorProto {
+	f := field(name, number, typ)
+	f.OneofIndex = protoV1.Int32(0)
+	return f
+}
+
+const (
+	typeBytes  = descriptorpb.FieldDescriptorProto_TYPE_BYTES
+	typeDouble = descriptorpb.FieldDescriptorProto_TYPE_DOUBLE
+	typeInt64  = descriptorpb.FieldDescriptorProto_TYPE_INT64
+	typeString = descriptorpb.FieldDescriptorProto_TYPE_STRING
 )
 
 // TestHashTimestampWithBadInputs tests how hashTimestamp handles bad inputs.
 func TestHashTimestampWithBadInputs(t *testing.T) {
 	hasher := objectHasher{}
 
-	badTimestampValues := []reflect.Value{
-		// Not a struct.
-		reflect.ValueOf(0.0),
+	badTimestampValues := []protoreflect.Message{
+		// Not a valid Timestamp (fields have the wrong type).
+		badWellKnownType(t, "Timestamp", field("seconds", 1, typeDouble), field("nanos", 2, typeDouble)),
 
-		// Not a valid Timestamp struct (fields have the wrong type).
-		reflect.ValueOf(struct {
-			Seconds float64
-			Nanos   float64
-		}{}),
+		// Not a valid Timestamp (fields are repeated).
+		badWellKnownType(t, "Timestamp", repeatedField("seconds", 1, typeInt64), repeatedField("nanos", 2, typeInt64)),
 	}
 
-	for i, v := range badTimestampValues {
+	for i, m := range badTimestampValues {
 		t.Run(fmt.Sprintf("TestBadTimestamps-%d", i), func(t *testing.T) {
-			_, err := hasher.hashTimestamp(v)
+			_, err := hasher.hashTimestamp(m)
 			if err == nil {
-				t.Errorf("Attempting to hash %T{ %[1]v } as a timestamp should have returned an error.", v)
+				t.Errorf("Attempting to hash %v as a timestamp should have returned an error.", m.Descriptor())
 			}
 		})
 	}
 }
+
+// TestHashDurationWithBadInputs tests how hashDuration handles bad inputs.
+func TestHashDurationWithBadInputs(t *testing.T) {
+	hasher := objectHasher{}
+
+	badDurationValues := []protoreflect.Message{
+		// Not a valid Duration (fields have the wrong type).
+		badWellKnownType(t, "Duration", field("seconds", 1, typeDouble), field("nanos", 2, typeDouble)),
+
+		// Not a valid Duration (fields are missing).
+		badWellKnownType(t, "Duration", field("seconds", 1, typeInt64)),
+	}
+
+	for i, m := range badDurationValues {
+		t.Run(fmt.Sprintf("TestBadDurations-%d", i), func(t *testing.T) {
+			_, err := hasher.hashDuration(m)
+			if err == nil {
+				t.Errorf("Attempting to hash %v as a duration should have returned an error.", m.Descriptor())
+			}
+		})
+	}
+}
+
+// TestHashWrapperWithBadInputs tests how hashWrapper handles bad inputs.
+func TestHashWrapperWithBadInputs(t *testing.T) {
+	hasher := objectHasher{}
+
+	badWrapperValues := []protoreflect.Message{
+		// Not a valid wrapper (the value field is missing).
+		badWellKnownType(t, "Int64Value", field("seconds", 1, typeInt64)),
+
+		// Not a valid wrapper (the value field is repeated).
+		badWellKnownType(t, "Int64Value", repeatedField("value", 1, typeInt64)),
+	}
+
+	for i, m := range badWrapperValues {
+		t.Run(fmt.Sprintf("TestBadWrappers-%d", i), func(t *testing.T) {
+			_, err := hasher.hashWrapper("Int64Value", m)
+			if err == nil {
+				t.Errorf("Attempting to hash %v as a wrapper should have returned an error.", m.Descriptor())
+			}
+		})
+	}
+}
+
+// TestHashJSONLikeTypesWithBadInputs tests how hashStructValue, hashListValue
+// and hashJSONValue handle bad inputs.
+func TestHashJSONLikeTypesWithBadInputs(t *testing.T) {
+	hasher := objectHasher{}
+
+	// A Value whose number_value has the wrong type.
+	badKind := badWellKnownType(t, "Value", oneofField("number_value", 2, typeString))
+	badKind.Set(badKind.Descriptor().Fields().Get(0), protoreflect.ValueOfString("1"))
+
+	badValues := map[string][]protoreflect.Message{
+		"Struct": {
+			// Not a valid Struct (the expected field is missing).
+			badWellKnownType(t, "Struct", field("seconds", 1, typeInt64)),
+
+			// Not a valid Struct (the expected field has the wrong type).
+			badWellKnownType(t, "Struct", field("fields", 1, typeBytes)),
+		},
+		"ListValue": {
+			// Not a valid ListValue (the expected field is missing).
+			badWellKnownType(t, "ListValue", field("seconds", 1, typeInt64)),
+
+			// Not a valid ListValue (the expected field has the wrong type).
+			badWellKnownType(t, "ListValue", repeatedField("values", 1, typeString)),
+		},
+		"Value": {
+			// Not a valid Value (the expected oneof is missing).
+			badWellKnownType(t, "Value", field("seconds", 1, typeInt64)),
+
+			// Not a valid Value (the kind of value has the wrong type).
+			badKind,
+		},
+	}
+
+	hashFunctions := map[string]func(protoreflect.Message) ([]byte, error){
+		"Struct":    hasher.hashStructValue,
+		"ListValue": hasher.hashListValue,
+		"Value":     hasher.hashJSONValue,
+	}
+
+	for name, hashFunction := range hashFunctions {
+		for i, m := range badValues[name] {
+			t.Run(fmt.Sprintf("TestBad%s-%d", name, i), func(t *testing.T) {
+				_, err := hashFunction(m)
+				if err == nil {
+					t.Errorf("Attempting to hash %v as a %s should have returned an error.", m.Descriptor(), name)
+				}
+			})
+		}
+	}
+}
+
+// errUnknownTypeURL is returned by testResolver for unknown type URLs.
+var errUnknownTypeURL = errors.New("unknown type URL")
+
+// testResolver is a TypeResolver that only knows about a single type URL.
+type testResolver struct{}
+
+func (testResolver) Resolve(typeURL string) (proto.Message, error) {
+	if typeURL == "example.com/simple" {
+		return protoV1.MessageV2(&pb3_latest.Simple{}), nil
+	}
+	return nil, errUnknownTypeURL
+}
+
+// TestAnyResolver tests that the AnyResolver option uses the supplied resolver.
+func TestAnyResolver(t *testing.T) {
+	hasher := NewHasher(AnyResolver(testResolver{}))
+
+	value, err := protoV1.Marshal(&pb3_latest.Simple{StringField: "foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := hasher.HashProto(&any_pb.Any{TypeUrl: "type.googleapis.com/schema.proto3.Simple", Value: value})
+	if !errors.Is(err, ErrUnresolvableAny) || !errors.Is(err, errUnknownTypeURL) {
+		t.Errorf("Expected an ErrUnresolvableAny error wrapping the error of the resolver for a type URL that is unknown to the resolver, instead got %x: %v", expected, err)
+	}
+
+	expected, err = NewHasher(AnyResolver(nil)).HashProto(&any_pb.Any{TypeUrl: "example.com/simple", Value: value})
+	if !errors.Is(err, ErrUnresolvableAny) || !errors.Is(err, protoregistry.NotFound) {
+		t.Errorf("Expected an ErrUnresolvableAny error wrapping protoregistry.NotFound for a type URL that is not registered, instead got %x: %v", expected, err)
+	}
+
+	h, err := hasher.HashProto(&any_pb.Any{TypeUrl: "example.com/simple", Value: value})
+	if err != nil {
+		t.Fatalf("Attempting to hash an Any with a resolvable type URL returned an error: %v", err)
+	}
+
+	// The hash must be the same as that of a regular message that has the
+	// embedded message as its second field.
+	oh := objectHasher{}
+	typeURLKey, _ := oh.hashInt64(1)
+	valueKey, _ := oh.hashInt64(2)
+	typeURLHash, _ := oh.hashUnicode("example.com/simple")
+	valueHash, _ := hasher.HashProto(protoV1.MessageV2(&pb3_latest.Simple{StringField: "foo"}))
+	entries := []hashEntry{
+		{khash: typeURLKey, vhash: typeURLHash},
+		{khash: valueKey, vhash: valueHash},
+	}
+	sort.Sort(byKHash(entries))
+	b := new(bytes.Buffer)
+	for _, e := range entries {
+		b.Write(e.khash)
+		b.Write(e.vhash)
+	}
+	expected, _ = oh.hash(mapIdentifier, b.Bytes())
+
+	if !bytes.Equal(h, expected) {
+		t.Errorf("Got the wrong objecthash for an Any proto.\nActual:   %x\nExpected: %x\n", h, expected)
+	}
+}